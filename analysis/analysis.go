@@ -0,0 +1,288 @@
+// Package analysis turns text into index terms. An Analyzer is a Tokenizer
+// followed by a chain of Filters; the index runs the same one over
+// documents and queries, so both sides agree on what a term is.
+package analysis
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Token is a term and its position, counted in words from the start of
+// the analyzed text. Filters that drop tokens leave positions unchanged,
+// so the gaps stay visible to phrase matching. Stop marks a stopword kept
+// by Stopwords with Keep set.
+type Token struct {
+	Term     string
+	Position int
+	Stop     bool
+}
+
+// Tokenizer splits text into tokens.
+type Tokenizer interface {
+	Tokenize(text string) []Token
+}
+
+// Filter rewrites a token stream; it may change, drop or add tokens.
+type Filter interface {
+	Filter(tokens []Token) []Token
+}
+
+// A QueryFilter whose QueryOnly is true is applied by AnalyzeQuery and
+// skipped by Analyze, changing how queries are read without reindexing.
+type QueryFilter interface {
+	Filter
+	QueryOnly() bool
+}
+
+// Analyzer runs a Tokenizer and then its Filters in order.
+type Analyzer struct {
+	Tokenizer Tokenizer
+	Filters   []Filter
+	spec      string
+	lang      string
+}
+
+// Analyze returns text's tokens after filtering, for indexing.
+func (a *Analyzer) Analyze(text string) []Token {
+	return a.analyze(text, false)
+}
+
+// AnalyzeQuery is Analyze for a query, with the query-only filters too.
+func (a *Analyzer) AnalyzeQuery(text string) []Token {
+	return a.analyze(text, true)
+}
+
+func (a *Analyzer) analyze(text string, query bool) []Token {
+	tokens := a.Tokenizer.Tokenize(text)
+	for _, f := range a.Filters {
+		if qf, ok := f.(QueryFilter); ok && qf.QueryOnly() && !query {
+			continue
+		}
+		tokens = f.Filter(tokens)
+	}
+	return tokens
+}
+
+// Terms is Analyze without positions.
+func (a *Analyzer) Terms(text string) []string {
+	tokens := a.Analyze(text)
+	terms := make([]string, len(tokens))
+	for i, t := range tokens {
+		terms[i] = t.Term
+	}
+	return terms
+}
+
+// Spec is the filter list the analyzer was built from with Parse, which
+// rebuilds it; "" for analyzers put together by hand.
+func (a *Analyzer) Spec() string {
+	return a.spec
+}
+
+// Lang is the language ParseLang built the analyzer for.
+func (a *Analyzer) Lang() string {
+	return a.lang
+}
+
+// DefaultSpec lowercases, strips punctuation inside words, drops
+// stopwords and one-letter terms, stems and folds accents, stopwords and
+// stemming following the language of the text.
+const DefaultSpec = "lowercase,punctuation,stop,minlength,stem,fold"
+
+// DefaultLang is the language Parse assumes.
+const DefaultLang = "en"
+
+// A FilterFactory builds the filter a spec names. It is given the argument
+// after the colon in "name:arg", or "" if there is none, and the language
+// the analyzer is for. A nil Filter leaves the stage out, as for stemming
+// a language without a stemmer.
+type FilterFactory func(arg, lang string) (Filter, error)
+
+// A TokenizerFactory builds the tokenizer a spec names, given the argument
+// after the colon.
+type TokenizerFactory func(arg string) (Tokenizer, error)
+
+// registry guards filters and tokenizers, which RegisterFilter and
+// RegisterTokenizer add to.
+var registry sync.RWMutex
+
+// tokenizers are the named tokenizers Parse knows.
+var tokenizers = map[string]TokenizerFactory{
+	"words":  func(string) (Tokenizer, error) { return Words{}, nil },
+	"code":   func(string) (Tokenizer, error) { return Code{}, nil },
+	"social": func(string) (Tokenizer, error) { return Social{}, nil },
+}
+
+// filters are the named filters Parse knows.
+var filters = map[string]FilterFactory{
+	"lowercase":   func(_, _ string) (Filter, error) { return Lowercase{}, nil },
+	"fold":        func(_, _ string) (Filter, error) { return AccentFold{}, nil },
+	"punctuation": func(_, _ string) (Filter, error) { return Punctuation{}, nil },
+	"minlength": func(arg, _ string) (Filter, error) {
+		if arg == "" {
+			return MinLength{N: 2}, nil
+		}
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("analysis: minlength: bad length %q", arg)
+		}
+		return MinLength{N: n}, nil
+	},
+	// "stem" stems in the analyzer's language, "stem:de" always in German.
+	"stem": func(arg, lang string) (Filter, error) {
+		if arg == "" {
+			if _, ok := stemmers[lang]; !ok {
+				return nil, nil
+			}
+			arg = lang
+		}
+		return NewStem(strings.ToLower(arg))
+	},
+	// "stop" drops the stopwords of the analyzer's language, "stop:de"
+	// German ones, "stop:/etc/stopwords.txt" those listed in a file;
+	// "keepstop" marks them instead.
+	"stop": func(arg, lang string) (Filter, error) {
+		return langStopwords(arg, lang, false)
+	},
+	"keepstop": func(arg, lang string) (Filter, error) {
+		return langStopwords(arg, lang, true)
+	},
+	// "synonyms:/etc/synonyms.txt" expands synonyms in indexed text and
+	// queries, "querysynonyms:/etc/synonyms.txt" in queries only.
+	"synonyms": func(arg, _ string) (Filter, error) {
+		return newSynonyms(arg, false)
+	},
+	"querysynonyms": func(arg, _ string) (Filter, error) {
+		return newSynonyms(arg, true)
+	},
+}
+
+// RegisterFilter makes a filter available to specs under name, for
+// analysis the built-in filters don't cover. Register in an init function
+// or before the index is opened: an index whose spec names a filter that
+// isn't registered fails to open. RegisterFilter panics if name is taken,
+// by a filter or a tokenizer, or has a comma or colon in it.
+func RegisterFilter(name string, f FilterFactory) {
+	register(name)
+	filters[name] = f
+	registry.Unlock()
+}
+
+// RegisterTokenizer is RegisterFilter for a tokenizer, which a spec names
+// as its first item: "code,lowercase,stop".
+func RegisterTokenizer(name string, t TokenizerFactory) {
+	register(name)
+	tokenizers[name] = t
+	registry.Unlock()
+}
+
+// register checks name and returns with registry locked.
+func register(name string) {
+	if name != strings.ToLower(strings.TrimSpace(name)) || name == "" || strings.ContainsAny(name, ",:") {
+		panic(fmt.Sprintf("analysis: bad name %q", name))
+	}
+	registry.Lock()
+	_, f := filters[name]
+	_, t := tokenizers[name]
+	if f || t {
+		registry.Unlock()
+		panic(fmt.Sprintf("analysis: %q registered twice", name))
+	}
+}
+
+func newSynonyms(path string, query bool) (Filter, error) {
+	if path == "" {
+		return nil, errors.New("analysis: synonyms needs a file: synonyms:<path>")
+	}
+	return NewSynonyms(path, query)
+}
+
+func langStopwords(arg, lang string, keep bool) (Filter, error) {
+	if arg == "" {
+		if _, ok := builtinStopwords[lang]; !ok {
+			return nil, nil
+		}
+		arg = lang
+	}
+	return NewStopwords(arg, keep)
+}
+
+// Languages lists the languages with a stemmer or a stopword list, the
+// ones ParseLang builds a language-specific analyzer for.
+func Languages() []string {
+	langs := slices.Collect(maps.Keys(builtinStopwords))
+	for lang := range stemmers {
+		if len(lang) == 2 && !slices.Contains(langs, lang) {
+			langs = append(langs, lang)
+		}
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// Parse builds an analyzer for DefaultLang from a comma-separated list of
+// filters, applied in order after word tokenization:
+// "lowercase,stem:de,fold". Stopword lists match terms as the filters
+// before them leave them, so "stop" belongs after "lowercase" and
+// "punctuation" and before "stem". The list may start with a tokenizer,
+// "words" (the default), "code", "social" or one registered with
+// RegisterTokenizer: "code,lowercase,stop".
+func Parse(spec string) (*Analyzer, error) {
+	return ParseLang(spec, DefaultLang)
+}
+
+// ParseLang is Parse for text in lang, an ISO 639-1 code: "stem" and
+// "stop" without an argument use its stemmer and stopwords, and are left
+// out if it has none.
+func ParseLang(spec, lang string) (*Analyzer, error) {
+	a := &Analyzer{Tokenizer: Words{}, lang: lang}
+	var names []string
+	registry.RLock()
+	defer registry.RUnlock()
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, arg, _ := strings.Cut(item, ":")
+		name = strings.ToLower(name)
+		if newTokenizer, ok := tokenizers[name]; ok {
+			if len(names) > 0 {
+				return nil, fmt.Errorf("analysis: tokenizer %q must come first", name)
+			}
+			t, err := newTokenizer(arg)
+			if err != nil {
+				return nil, err
+			}
+			a.Tokenizer = t
+			names = append(names, item)
+			continue
+		}
+		newFilter, ok := filters[name]
+		if !ok {
+			return nil, fmt.Errorf("analysis: unknown filter %q", name)
+		}
+		f, err := newFilter(arg, lang)
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			a.Filters = append(a.Filters, f)
+		}
+		names = append(names, item)
+	}
+	a.spec = strings.Join(names, ",")
+	return a, nil
+}
+
+// Default is the analyzer for DefaultSpec.
+func Default() *Analyzer {
+	a, _ := Parse(DefaultSpec)
+	return a
+}
@@ -0,0 +1,105 @@
+package analysis
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Lowercase maps terms to lower case.
+type Lowercase struct{}
+
+func (Lowercase) Filter(tokens []Token) []Token {
+	for i := range tokens {
+		tokens[i].Term = strings.ToLower(tokens[i].Term)
+	}
+	return tokens
+}
+
+// AccentFold removes diacritics, so "café" and "cafe" are one term.
+// Letters that aren't a base letter plus a mark, such as "ø" and "ß", are
+// left alone.
+type AccentFold struct{}
+
+func (AccentFold) Filter(tokens []Token) []Token {
+	for i, t := range tokens {
+		if !isASCII(t.Term) {
+			// A Transformer carries state, so each call gets its own.
+			fold := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+			if s, _, err := transform.String(fold, t.Term); err == nil {
+				tokens[i].Term = s
+			}
+		}
+	}
+	return tokens
+}
+
+// Punctuation strips punctuation inside terms ("don't" becomes "dont",
+// "u.s.a" "usa"), except between digits, where it is part of the number
+// ("3.14", "1,000"). Terms left empty are dropped.
+type Punctuation struct{}
+
+func (Punctuation) Filter(tokens []Token) []Token {
+	out := tokens[:0]
+	for _, t := range tokens {
+		t.Term = stripPunct(t.Term)
+		if t.Term != "" {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func stripPunct(s string) string {
+	if !strings.ContainsFunc(s, unicode.IsPunct) {
+		return s
+	}
+	var b strings.Builder
+	prev := rune(-1)
+	for i, r := range s {
+		if unicode.IsPunct(r) {
+			next, _ := utf8.DecodeRuneInString(s[i+utf8.RuneLen(r):])
+			if !unicode.IsDigit(prev) || !unicode.IsDigit(next) {
+				continue
+			}
+		}
+		b.WriteRune(r)
+		prev = r
+	}
+	return b.String()
+}
+
+// MinLength drops terms shorter than N characters. Terms in scripts
+// written without spaces are kept whatever their length; one ideograph can
+// be a word.
+type MinLength struct {
+	N int
+}
+
+func (f MinLength) Filter(tokens []Token) []Token {
+	out := tokens[:0]
+	for _, t := range tokens {
+		if utf8.RuneCountInString(t.Term) >= f.N || isIdeographic(t.Term) {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func isIdeographic(s string) bool {
+	r, _ := utf8.DecodeRuneInString(s)
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul, unicode.Thai)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,74 @@
+package analysis
+
+import (
+	"fmt"
+	"strings"
+
+	snowball "github.com/blevesearch/snowballstem"
+	"github.com/blevesearch/snowballstem/danish"
+	"github.com/blevesearch/snowballstem/dutch"
+	"github.com/blevesearch/snowballstem/english"
+	"github.com/blevesearch/snowballstem/finnish"
+	"github.com/blevesearch/snowballstem/french"
+	"github.com/blevesearch/snowballstem/german"
+	"github.com/blevesearch/snowballstem/hungarian"
+	"github.com/blevesearch/snowballstem/italian"
+	"github.com/blevesearch/snowballstem/norwegian"
+	"github.com/blevesearch/snowballstem/porter"
+	"github.com/blevesearch/snowballstem/portuguese"
+	"github.com/blevesearch/snowballstem/romanian"
+	"github.com/blevesearch/snowballstem/russian"
+	"github.com/blevesearch/snowballstem/spanish"
+	"github.com/blevesearch/snowballstem/swedish"
+	"github.com/blevesearch/snowballstem/turkish"
+)
+
+// stemmers are the Snowball stemmers by ISO 639-1 code. "porter" is the
+// original Porter algorithm, for indexes that need to match its output.
+var stemmers = map[string]func(*snowball.Env) bool{
+	"da": danish.Stem, "de": german.Stem, "en": english.Stem, "es": spanish.Stem,
+	"fi": finnish.Stem, "fr": french.Stem, "hu": hungarian.Stem, "it": italian.Stem,
+	"nl": dutch.Stem, "no": norwegian.Stem, "pt": portuguese.Stem, "ro": romanian.Stem,
+	"ru": russian.Stem, "sv": swedish.Stem, "tr": turkish.Stem, "porter": porter.Stem,
+}
+
+// Stem reduces terms to their Snowball stem, so "crawling" and "crawled"
+// both become "crawl". Terms must already be lowercase. Stemmers expect
+// their language's accents, so AccentFold belongs after Stem.
+//
+// Snowball English leaves agent nouns alone; Stem also cuts "-er" from
+// English stems of at least MinAgentStem letters, so "crawler" and
+// "server" meet "crawl" and "serv". Shorter stems ("water", "paper")
+// would mostly be wrong.
+type Stem struct {
+	Language string // a key of stemmers
+	stem     func(*snowball.Env) bool
+}
+
+// NewStem returns the stemmer for lang ("en", "de", ...).
+func NewStem(lang string) (Stem, error) {
+	stem, ok := stemmers[lang]
+	if !ok {
+		return Stem{}, fmt.Errorf("analysis: no stemmer for %q", lang)
+	}
+	return Stem{Language: lang, stem: stem}, nil
+}
+
+// MinAgentStem is the shortest stem left by removing an English "-er".
+const MinAgentStem = 4
+
+func (s Stem) Filter(tokens []Token) []Token {
+	env := snowball.NewEnv("")
+	for i, t := range tokens {
+		env.SetCurrent(t.Term)
+		s.stem(env)
+		st := env.Current()
+		if s.Language == "en" && len(st) >= MinAgentStem+2 && strings.HasSuffix(st, "er") {
+			st = st[:len(st)-2]
+		}
+		if st != "" {
+			tokens[i].Term = st
+		}
+	}
+	return tokens
+}
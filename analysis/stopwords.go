@@ -0,0 +1,93 @@
+package analysis
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// builtinStopwords are short lists of the commonest function words per
+// language, written as the punctuation filter leaves them ("dont").
+var builtinStopwords = map[string]string{
+	"en": `a about an and are as at be been but by can do does dont for from had has have he her his
+		how i if in into is it its me my no not of on or our she so than that the their them then
+		there these they this to up was we were what when where which who why will with would you your`,
+	"de": `aber als am an auch auf aus bei bin bis da das dass dem den der des die doch du ein eine
+		einem einen einer es für hat hatte ich ihr im in ist ja kann mit nach nicht noch nur oder
+		sein sich sie sind so um und von war was wie wir wird zu zum zur über`,
+	"fr": `au aux avec ce ces cette dans de des du elle en est et il ils je la le les leur lui mais
+		me même mon ne nous on ou par pas pour qu que qui sa se ses son sont sur ta te tu un une
+		vous à été être`,
+	"es": `a al como con de del el ella en entre era es esta este fue ha la las le les lo los más me
+		mi no nos o para pero por que se si sin sobre su sus también te tu un una uno y ya él`,
+	"it": `a ad al alla anche che chi ci come con da dal del della di e è gli ha ho i il in io la le
+		lo ma mi ne nel non o per più se si sono su sua suo tra tu un una uno`,
+	"pt": `a ao aos as até com como da das de do dos e ela ele em entre era essa esse está eu foi há
+		isso já mais mas me na nas no nos não o os ou para pela pelo por que se sem seu sua são também
+		um uma`,
+	"nl": `aan al als bij dan dat de den der die dit door een en er had heb het hij hoe ik in is je
+		kan maar me met mij naar niet nog of om ook op over te tot uit van voor was wat we wel wij
+		zal ze zij zijn zo`,
+	"sv": `att av de den denna det du efter en ett från för han har hon i inte jag man med men mot
+		nu när och om på sig som så till under upp ut var vi vid än är över`,
+}
+
+// Stopwords drops function words that occur in nearly every document and
+// say little about any of them. Dropped tokens leave gaps in the
+// positions, so a phrase still only matches words that were adjacent.
+//
+// With Keep set, stopwords stay in the stream marked Stop instead, so the
+// index can match phrases word for word ("to be or not to be"); the index
+// then treats them as optional in ordinary queries.
+type Stopwords struct {
+	Words map[string]bool
+	Keep  bool
+}
+
+// NewStopwords returns the filter for a built-in list ("en", "de", ...), or
+// for the list in the file at path, one word per line with # comments. A
+// file replaces the built-in lists, it does not add to them.
+func NewStopwords(list string, keep bool) (Stopwords, error) {
+	var words []string
+	if text, ok := builtinStopwords[strings.ToLower(list)]; ok {
+		words = strings.Fields(text)
+	} else if strings.ContainsAny(list, `/\.`) {
+		f, err := os.Open(list)
+		if err != nil {
+			return Stopwords{}, fmt.Errorf("analysis: stopwords: %w", err)
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			line, _, _ := strings.Cut(sc.Text(), "#")
+			words = append(words, strings.Fields(line)...)
+		}
+		if err := sc.Err(); err != nil {
+			return Stopwords{}, fmt.Errorf("analysis: stopwords: %s: %w", list, err)
+		}
+	} else {
+		return Stopwords{}, fmt.Errorf("analysis: no stopword list for %q", list)
+	}
+	s := Stopwords{Words: make(map[string]bool, len(words)), Keep: keep}
+	for _, w := range words {
+		if w = stripPunct(strings.ToLower(w)); w != "" {
+			s.Words[w] = true
+		}
+	}
+	return s, nil
+}
+
+func (s Stopwords) Filter(tokens []Token) []Token {
+	out := tokens[:0]
+	for _, t := range tokens {
+		if s.Words[t.Term] {
+			if !s.Keep {
+				continue
+			}
+			t.Stop = true
+		}
+		out = append(out, t)
+	}
+	return out
+}
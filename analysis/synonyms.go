@@ -0,0 +1,104 @@
+package analysis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Synonyms adds a token for each synonym of a term at the term's own
+// position, so a query for "js" finds pages about "javascript" and a
+// phrase still lines up. Terms map to what they are replaced with, which
+// for equivalent words includes the term itself.
+//
+// Indexing synonyms makes the index larger and needs a reindex when the
+// list changes; with Query set they are left out of indexed text and
+// added to queries only, see QueryFilter.
+type Synonyms struct {
+	Terms map[string][]string
+	Query bool
+}
+
+// NewSynonyms reads a synonym file. Each line is a comma-separated list of
+// equivalent words, "js, javascript", or a one-way mapping of words to the
+// ones they mean, "colour, colours => color"; # starts a comment. Words
+// are lowercased and stripped of punctuation, so the filter belongs after
+// "lowercase" and "punctuation". Synonyms are single words.
+func NewSynonyms(path string, query bool) (*Synonyms, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("analysis: synonyms: %w", err)
+	}
+	defer f.Close()
+	s := &Synonyms{Terms: make(map[string][]string), Query: query}
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		from, to, oneWay := strings.Cut(text, "=>")
+		words, err := synonymList(from)
+		targets := words
+		if err == nil && oneWay {
+			if targets, err = synonymList(to); err == nil && (len(words) == 0 || len(targets) == 0) {
+				err = errors.New("=> needs words on both sides")
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("analysis: synonyms: %s:%d: %w", path, line, err)
+		}
+		for _, w := range words {
+			s.add(w, targets)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("analysis: synonyms: %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// synonymList splits a comma-separated list of single words.
+func synonymList(s string) ([]string, error) {
+	var words []string
+	for _, w := range strings.Split(s, ",") {
+		w = strings.TrimSpace(w)
+		if strings.ContainsFunc(w, func(r rune) bool { return r == ' ' || r == '\t' }) {
+			return nil, fmt.Errorf("%q is more than one word", w)
+		}
+		if w = stripPunct(strings.ToLower(w)); w != "" && !slices.Contains(words, w) {
+			words = append(words, w)
+		}
+	}
+	return words, nil
+}
+
+// add adds to the terms w is replaced with; a word on several lines gets
+// the synonyms of each.
+func (s *Synonyms) add(w string, terms []string) {
+	for _, t := range terms {
+		if !slices.Contains(s.Terms[w], t) {
+			s.Terms[w] = append(s.Terms[w], t)
+		}
+	}
+}
+
+func (s *Synonyms) Filter(tokens []Token) []Token {
+	var out []Token
+	for _, t := range tokens {
+		terms, ok := s.Terms[t.Term]
+		if !ok {
+			out = append(out, t)
+			continue
+		}
+		for _, term := range terms {
+			out = append(out, Token{Term: term, Position: t.Position, Stop: t.Stop})
+		}
+	}
+	return out
+}
+
+// QueryOnly reports whether the synonyms are for queries alone.
+func (s *Synonyms) QueryOnly() bool {
+	return s.Query
+}
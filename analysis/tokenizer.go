@@ -0,0 +1,138 @@
+package analysis
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rivo/uniseg"
+)
+
+// Words splits text at Unicode word boundaries (UAX #29), so "don't" and
+// "3.14" stay whole while "e-mail" is two words, in any script. Ideographs
+// have no spaces between words and come out one per token. Segments
+// without a letter or digit (spaces, punctuation, emoji) are not tokens.
+type Words struct{}
+
+func (Words) Tokenize(text string) []Token {
+	var tokens []Token
+	state := -1
+	for text != "" {
+		var word string
+		word, text, state = uniseg.FirstWordInString(text, state)
+		if hasAlnum(word) {
+			tokens = append(tokens, Token{Term: word, Position: len(tokens)})
+		}
+	}
+	return tokens
+}
+
+func hasAlnum(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Code splits text the way source code and API docs are written: letters,
+// digits and underscores joined by ".", "::" or "->" make one token, so
+// "fmt.Println", "std::vector" and "snake_case" stay whole. The pieces of
+// such a token are added at its position too, so a search for "println"
+// still finds "fmt.Println". The punctuation filter would glue the pieces
+// back into one word; leave it out of specs that start with "code".
+type Code struct{}
+
+// codeJoiners join identifiers into one Code token.
+var codeJoiners = []string{".", "::", "->"}
+
+func (Code) Tokenize(text string) []Token {
+	var tokens []Token
+	pos := 0
+	for i := 0; i < len(text); {
+		r, n := utf8.DecodeRuneInString(text[i:])
+		if !isIdent(r) {
+			i += n
+			continue
+		}
+		start := i
+		for i < len(text) {
+			if r, n := utf8.DecodeRuneInString(text[i:]); isIdent(r) {
+				i += n
+				continue
+			}
+			j := joinerAt(text, i)
+			if j == 0 {
+				break
+			}
+			if r, _ := utf8.DecodeRuneInString(text[i+j:]); !isIdent(r) {
+				break
+			}
+			i += j
+		}
+		word := text[start:i]
+		if !hasAlnum(word) {
+			continue
+		}
+		tokens = append(tokens, Token{Term: word, Position: pos})
+		parts := strings.FieldsFunc(word, func(r rune) bool { return !isIdent(r) || r == '_' })
+		if len(parts) > 1 {
+			for _, p := range parts {
+				tokens = append(tokens, Token{Term: p, Position: pos})
+			}
+		}
+		pos++
+	}
+	return tokens
+}
+
+func isIdent(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r) || r == '_'
+}
+
+// joinerAt is the length of the codeJoiner at text[i:], or 0.
+func joinerAt(text string, i int) int {
+	for _, j := range codeJoiners {
+		if strings.HasPrefix(text[i:], j) {
+			return len(j)
+		}
+	}
+	return 0
+}
+
+// Social is Words that keeps hashtags and mentions: "#golang" and "@gopher"
+// at the start of a word are tokens with their sign, at the same position
+// as the word without it, so "#golang" finds the tag alone and "golang"
+// the tag and the word. The punctuation filter strips the sign; leave it
+// out to tell the two apart.
+type Social struct{}
+
+func (Social) Tokenize(text string) []Token {
+	var words []string
+	state := -1
+	for text != "" {
+		var word string
+		word, text, state = uniseg.FirstWordInString(text, state)
+		words = append(words, word)
+	}
+	var tokens []Token
+	pos := 0
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if (w == "#" || w == "@") && i+1 < len(words) && hasAlnum(words[i+1]) &&
+			(i == 0 || strings.TrimSpace(words[i-1]) == "") {
+			i++
+			tokens = append(tokens,
+				Token{Term: w + words[i], Position: pos},
+				Token{Term: words[i], Position: pos})
+			pos++
+			continue
+		}
+		if hasAlnum(w) {
+			tokens = append(tokens, Token{Term: w, Position: pos})
+			pos++
+		}
+	}
+	return tokens
+}
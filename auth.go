@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ----- Authenticated crawls -----
+
+// credentials authenticate the crawler to one host, configured under
+// "credentials" in CRAWLER_CONFIG keyed by exact hostname:
+//
+//	"credentials": {
+//	  "staging.example.com": {"username": "bot", "password_env": "STAGING_PASS"},
+//	  "wiki.example.com": {"login": {
+//	    "url": "https://wiki.example.com/login",
+//	    "fields": {"user": "bot", "pass": "$WIKI_PASS"}
+//	  }}
+//	}
+//
+// Secrets can be kept out of the file: password_env names a variable, and
+// login field values starting with "$" are read from the environment.
+// Subdomains are not covered, so credentials never leak to other hosts.
+type credentials struct {
+	Username    string     `json:"username"`
+	Password    string     `json:"password"`
+	PasswordEnv string     `json:"password_env"`
+	Login       *loginStep `json:"login"`
+}
+
+// loginStep is a form POST made once before crawling; the session cookies
+// it sets are kept in the cookie jar.
+type loginStep struct {
+	URL    string            `json:"url"`
+	Fields map[string]string `json:"fields"`
+}
+
+func (c credentials) password() string {
+	if c.PasswordEnv != "" {
+		return os.Getenv(c.PasswordEnv)
+	}
+	return c.Password
+}
+
+// credentialsFor returns the credentials configured for exactly host.
+func (c *crawlConfig) credentialsFor(host string) (credentials, bool) {
+	cred, ok := c.Credentials[strings.ToLower(host)]
+	return cred, ok
+}
+
+// needsJar reports whether any login step is configured.
+func (c *crawlConfig) needsJar() bool {
+	for _, cred := range c.Credentials {
+		if cred.Login != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newCookieJar returns a jar when COOKIE_JAR=true or a login step needs
+// one, and nil otherwise so ordinary crawls stay stateless.
+func newCookieJar(cfg *crawlConfig) http.CookieJar {
+	if !getEnvBool("COOKIE_JAR", false) && !cfg.needsJar() {
+		return nil
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		log.Printf("cookies: %v", err)
+		return nil
+	}
+	return jar
+}
+
+// login runs every configured login step. A failed login is logged and
+// the crawl goes ahead; the host's pages will simply fail or come back as
+// the login screen.
+func login(ctx context.Context, client *http.Client, cfg *crawlConfig) {
+	for host, cred := range cfg.Credentials {
+		if cred.Login == nil {
+			continue
+		}
+		if err := cred.Login.run(ctx, client); err != nil {
+			log.Printf("login: %s: %v", host, err)
+			continue
+		}
+		log.Printf("login: %s: ok", host)
+	}
+}
+
+func (l *loginStep) run(ctx context.Context, client *http.Client) error {
+	form := url.Values{}
+	for k, v := range l.Fields {
+		if name, ok := strings.CutPrefix(v, "$"); ok {
+			v = os.Getenv(name)
+		}
+		form.Set(k, v)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, l.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, MaxBodyBytes))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
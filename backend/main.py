@@ -1,9 +1,12 @@
 import os
 import math
 import re
+import json
+import base64
+import binascii
 from collections import defaultdict
 
-from fastapi import FastAPI, Query
+from fastapi import FastAPI, HTTPException, Query
 from fastapi.middleware.cors import CORSMiddleware
 from dotenv import load_dotenv
 from pymongo import MongoClient
@@ -25,6 +28,12 @@ db = client[MONGO_DB_NAME]
 DOCS_COLL = db["documents"]
 INDEX_COLL = db["index_terms"]
 
+# Pagination: a page holds at most MAX_LIMIT results, and only the first
+# MAX_RESULT_WINDOW results of a query can be paged to, with offset or
+# with cursors; ranking deeper than that costs more than anyone reads.
+MAX_LIMIT = int(os.getenv("SEARCH_MAX_LIMIT", "100"))
+MAX_RESULT_WINDOW = int(os.getenv("SEARCH_MAX_WINDOW", "1000"))
+
 app = FastAPI(
     title="Mini Search Engine API",
     description="Simple TF-IDF based search API",
@@ -62,14 +71,46 @@ def tokenize(text: str):
 
 # ------------------ Search logic ------------------ #
 
-def search_query(q: str, limit: int = 20):
+def encode_cursor(q: str, position: int, last: tuple) -> str:
+    """The cursor for the results after last, the (score, id) of a page's last
+    ranked document, of which position were returned up to it."""
+    data = {"q": q, "n": position, "s": last[0], "id": last[1]}
+    raw = json.dumps(data, separators=(",", ":")).encode()
+    return base64.urlsafe_b64encode(raw).decode().rstrip("=")
+
+
+def decode_cursor(q: str, cursor: str) -> dict:
+    """Reads a cursor encode_cursor made for the same query."""
+    try:
+        raw = base64.urlsafe_b64decode(cursor + "=" * (-len(cursor) % 4))
+        data = json.loads(raw)
+        if data["q"] != q:
+            raise ValueError("cursor is for another query")
+        return {"n": int(data["n"]), "s": float(data["s"]), "id": str(data["id"])}
+    except (binascii.Error, ValueError, KeyError, TypeError):
+        raise HTTPException(status_code=400, detail="invalid cursor")
+
+
+def search_query(q: str, limit: int = 20, offset: int = 0, after: dict = None):
+    """
+    Ranks the documents matching q and returns the page of up to limit of
+    them starting at offset, or after the (score, id) of a cursor, the
+    number of matches and the (score, id) of the page's last ranked
+    document if more follow it, else None. That document may be missing
+    from the results, with any other whose metadata is gone, so a page can
+    be short. Documents are ordered by score, ties by id, so while the
+    index is unchanged the order is the same on every request and pages
+    never overlap or skip.
+    """
     terms = tokenize(q)
     if not terms:
-        return []
+        return [], 0, None
 
-    index_docs = list(INDEX_COLL.find({"term": {"$in": terms}}))
+    # Terms are summed in one order, so a document scores the very same
+    # float each time and cursors compare equal.
+    index_docs = sorted(INDEX_COLL.find({"term": {"$in": terms}}), key=lambda t: t["term"])
     if not index_docs:
-        return []
+        return [], 0, None
 
     scores = defaultdict(float)
 
@@ -83,10 +124,19 @@ def search_query(q: str, limit: int = 20):
             scores[doc_id] += score
 
     if not scores:
-        return []
+        return [], 0, None
 
-    sorted_docs = sorted(scores.items(), key=lambda x: x[1], reverse=True)
-    top_docs = sorted_docs[:limit]
+    def rank(item):
+        doc_id, score = item
+        return (-score, str(doc_id))
+
+    sorted_docs = sorted(scores.items(), key=rank)
+    if after is not None:
+        sorted_docs = [d for d in sorted_docs if rank(d) > (-after["s"], after["id"])]
+    top_docs = sorted_docs[offset:offset + limit]
+    last = None
+    if top_docs and len(sorted_docs) > offset + limit:
+        last = (top_docs[-1][1], str(top_docs[-1][0]))
 
     doc_ids = [doc_id for doc_id, _ in top_docs]
     normalized_ids = [ObjectId(d) if not isinstance(d, ObjectId) else d for d in doc_ids]
@@ -100,7 +150,8 @@ def search_query(q: str, limit: int = 20):
             "snippet": 1,
             "favicon": 1,       # NEW
             "image": 1,         # NEW
-            "site_name": 1      # NEW
+            "site_name": 1,     # NEW
+            "breadcrumbs": 1
         }
     )
 
@@ -122,26 +173,62 @@ def search_query(q: str, limit: int = 20):
             "favicon": meta.get("favicon", ""),
             "image": meta.get("image", ""),
             "site_name": meta.get("site_name", ""),
+            "breadcrumbs": meta.get("breadcrumbs", []),  # [{"name", "url"}], root first
 
             "score": score,
         })
 
-    return results
+    return results, len(scores), last
 
 
 
 # ------------------ API endpoints ------------------ #
 
 @app.get("/search")
-def search(q: str = Query(..., description="Search query"), limit: int = 20):
+def search(
+    q: str = Query(..., description="Search query"),
+    limit: int = Query(20, ge=1, le=MAX_LIMIT, description="Results per page"),
+    offset: int = Query(0, ge=0, description="Results to skip"),
+    cursor: str = Query(None, description="next_cursor of the previous page"),
+):
     """
     Search endpoint.
     Example: GET /search?q=python
+
+    Pages either by offset, GET /search?q=python&offset=20&limit=20, or by
+    cursor: each page's next_cursor, passed back as cursor, gets the one
+    after it, and is null on the last. A cursor resumes after the (score, id)
+    of the last document its page ranked. Indexing in between changes IDF
+    and so the scores, and the pages that follow can then repeat or miss
+    results, as with offsets. Either way only the first MAX_RESULT_WINDOW
+    results of a query can be reached: the page reaching it is cut short
+    and has no next_cursor, and an offset past it is an error.
     """
-    results = search_query(q, limit=limit)
+    after = None
+    if cursor is not None:
+        if offset:
+            raise HTTPException(status_code=400, detail="use offset or cursor, not both")
+        after = decode_cursor(q, cursor)
+    start = after["n"] if after else offset
+    if start >= MAX_RESULT_WINDOW:
+        raise HTTPException(
+            status_code=400,
+            detail=f"offset is past the result window of {MAX_RESULT_WINDOW}",
+        )
+    limit = min(limit, MAX_RESULT_WINDOW - start)
+
+    results, total, last = search_query(q, limit=limit, offset=0 if after else offset, after=after)
+    position = start + len(results)
+    next_cursor = None
+    if last is not None and position < MAX_RESULT_WINDOW:
+        next_cursor = encode_cursor(q, position, last)
     return {
         "query": q,
         "count": len(results),
+        "total": total,
+        "offset": start,
+        "limit": limit,
+        "next_cursor": next_cursor,
         "results": results,
     }
 
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/blevesearch/bleve/v2"
+	_ "github.com/blevesearch/bleve/v2/config" // analyzers by name, "en", "de", "standard", ...
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"github.com/realutkarshh/mini-search-crawler/index"
+)
+
+// ----- Bleve backend -----
+
+// DefaultBleveDir is where the Bleve index lives unless BLEVE_DIR says
+// otherwise.
+const DefaultBleveDir = "bleve-index"
+
+func bleveDir() string {
+	return getEnv("BLEVE_DIR", DefaultBleveDir)
+}
+
+// bleveBackend reports whether SEARCH_BACKEND picks the Bleve index, which
+// rebuilds and crawls then write to instead of the index in INDEX_DIR.
+func bleveBackend() bool {
+	return strings.EqualFold(getEnv("SEARCH_BACKEND", "index"), "bleve")
+}
+
+// bleveIndex indexes and searches pages with Bleve, as an alternative to
+// package index with Bleve's analyzers and storage. Pages have the fields
+// of an index.Document, under the same names, analyzed with the Bleve
+// analyzer BLEVE_ANALYZER, by default the one named after INDEX_LANGUAGE
+// ("en"); the analyzer is fixed when the index is created. Queries read
+// as they do for the index: every word must match in some field, quoted
+// phrases match as phrases and "crawl*" as a wildcard; fields weigh
+// BOOST_<FIELD>.
+//
+// Bleve keeps its index locked while open, so searching fails while a
+// crawl is writing to it.
+type bleveIndex struct {
+	idx    bleve.Index
+	boosts map[string]float64
+}
+
+// bleveMapping maps index.Documents, as bleveDoc has them, to Bleve fields.
+// Only the fields results show are stored; the favicon and image are
+// stored and not indexed.
+func bleveMapping() *mapping.IndexMappingImpl {
+	m := bleve.NewIndexMapping()
+	m.DefaultAnalyzer = getEnv("BLEVE_ANALYZER", getEnv("INDEX_LANGUAGE", "en"))
+	doc := bleve.NewDocumentStaticMapping()
+	for _, f := range index.Fields {
+		text := bleve.NewTextFieldMapping()
+		text.Store = f == index.FieldTitle || f == index.FieldSnippet
+		doc.AddFieldMappingsAt(f, text)
+	}
+	for _, f := range []string{"favicon", "image"} {
+		stored := bleve.NewKeywordFieldMapping()
+		stored.Index = false
+		stored.IncludeInAll = false
+		doc.AddFieldMappingsAt(f, stored)
+	}
+	for _, f := range []string{"url", "lang"} {
+		keyword := bleve.NewKeywordFieldMapping()
+		keyword.IncludeInAll = false
+		doc.AddFieldMappingsAt(f, keyword)
+	}
+	for _, f := range index.Numeric {
+		var fm *mapping.FieldMapping
+		if f == index.NumCrawled || f == index.NumPublished {
+			fm = bleve.NewDateTimeFieldMapping()
+		} else {
+			fm = bleve.NewNumericFieldMapping()
+		}
+		fm.IncludeInAll = false
+		doc.AddFieldMappingsAt(f, fm)
+	}
+	m.DefaultMapping = doc
+	return m
+}
+
+// openBleve opens the Bleve index in dir, creating it if create is set
+// and there is none. A read-only index can be open in several processes.
+func openBleve(dir string, create, readOnly bool) (*bleveIndex, error) {
+	idx, err := bleve.OpenUsing(dir, map[string]interface{}{"read_only": readOnly, "bolt_timeout": "5s"})
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) && create {
+		idx, err = bleve.New(dir, bleveMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bleve: %s: %w", dir, err)
+	}
+	b := &bleveIndex{idx: idx, boosts: make(map[string]float64)}
+	for _, f := range index.Fields {
+		b.boosts[f] = getEnvFloat("BOOST_"+strings.ToUpper(f), index.DefaultBoosts[f])
+	}
+	return b, nil
+}
+
+// bleveDoc is d as Bleve indexes it, under its URL. Unknown values are
+// left out, so they are outside every range as in the index.
+func bleveDoc(d index.Document) map[string]any {
+	doc := map[string]any{
+		"url":               d.URL,
+		index.FieldTitle:    d.Title,
+		index.FieldHeadings: d.Headings,
+		index.FieldSnippet:  d.Snippet,
+		index.FieldAnchors:  d.Anchors,
+		index.FieldBody:     d.Body,
+		"lang":              d.Lang,
+		"favicon":           d.Favicon,
+		"image":             d.Image,
+	}
+	if !d.Crawled.IsZero() {
+		doc[index.NumCrawled] = d.Crawled
+	}
+	if !d.Published.IsZero() {
+		doc[index.NumPublished] = d.Published
+	}
+	if d.Words > 0 {
+		doc[index.NumWords] = d.Words
+	}
+	if d.Inlinks > 0 {
+		doc[index.NumInlinks] = d.Inlinks
+	}
+	return doc
+}
+
+// Add indexes d, replacing the page indexed under its URL.
+func (b *bleveIndex) Add(d index.Document) error {
+	return b.idx.Index(d.URL, bleveDoc(d))
+}
+
+// Delete takes the page at url out of the index.
+func (b *bleveIndex) Delete(url string) error {
+	return b.idx.Delete(url)
+}
+
+func (b *bleveIndex) Close() error { return b.idx.Close() }
+
+// bleveQuery builds the query for text narrowed down by opts, or nil if
+// text has nothing to search for.
+func (b *bleveIndex) bleveQuery(text string, opts index.Options) (query.Query, error) {
+	var must []query.Query
+	anyField := func(build func(field string) query.Query) query.Query {
+		var qs []query.Query
+		for _, f := range index.Fields {
+			q := build(f)
+			q.(query.BoostableQuery).SetBoost(b.boosts[f])
+			qs = append(qs, q)
+		}
+		return bleve.NewDisjunctionQuery(qs...)
+	}
+	analyzer := b.idx.Mapping().AnalyzerNamed(b.idx.Mapping().AnalyzerNameForPath(index.FieldBody))
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			if strings.TrimSpace(part) != "" {
+				must = append(must, anyField(func(f string) query.Query {
+					q := bleve.NewMatchPhraseQuery(part)
+					q.SetField(f)
+					return q
+				}))
+			}
+			continue
+		}
+		for _, w := range strings.Fields(part) {
+			w = strings.TrimFunc(w, func(r rune) bool { return unicode.IsPunct(r) && r != '*' && r != '?' })
+			if j := strings.IndexAny(w, "*?"); j >= index.MinWildcardPrefix {
+				must = append(must, anyField(func(f string) query.Query {
+					q := bleve.NewWildcardQuery(strings.ToLower(w))
+					q.SetField(f)
+					return q
+				}))
+				continue
+			}
+			// Stopwords analyze to nothing and match nothing; they are
+			// left out rather than required.
+			if analyzer != nil && len(analyzer.Analyze([]byte(w))) == 0 {
+				continue
+			}
+			must = append(must, anyField(func(f string) query.Query {
+				q := bleve.NewMatchQuery(w)
+				q.SetField(f)
+				return q
+			}))
+		}
+	}
+	if len(must) == 0 {
+		return nil, nil
+	}
+	if opts.Lang != "" {
+		q := bleve.NewTermQuery(opts.Lang)
+		q.SetField("lang")
+		must = append(must, q)
+	}
+	inclusive := true
+	for _, r := range opts.Ranges {
+		switch r.Field {
+		case index.NumCrawled, index.NumPublished:
+			var start, end time.Time // zero leaves the range open
+			if r.Min > 0 {
+				start = time.Unix(r.Min, 0)
+			}
+			if r.Max != 0 {
+				end = time.Unix(r.Max, 0)
+			}
+			if start.IsZero() && end.IsZero() {
+				start = time.Unix(1, 0)
+			}
+			q := bleve.NewDateRangeInclusiveQuery(start, end, &inclusive, &inclusive)
+			q.SetField(r.Field)
+			must = append(must, q)
+		case index.NumWords, index.NumInlinks:
+			lo := float64(max(r.Min, 1))
+			var hi *float64
+			if r.Max != 0 {
+				v := float64(r.Max)
+				hi = &v
+			}
+			q := bleve.NewNumericRangeInclusiveQuery(&lo, hi, &inclusive, &inclusive)
+			q.SetField(r.Field)
+			must = append(must, q)
+		default:
+			return nil, fmt.Errorf("search: unknown numeric field %q", r.Field)
+		}
+	}
+	return bleve.NewConjunctionQuery(must...), nil
+}
+
+func (b *bleveIndex) Search(ctx context.Context, text string, limit int, opts index.Options) ([]index.Result, error) {
+	q, err := b.bleveQuery(text, opts)
+	if err != nil || q == nil {
+		return nil, err
+	}
+	req := bleve.NewSearchRequestOptions(q, limit, 0, false)
+	req.Fields = append([]string{"url", index.FieldTitle, index.FieldSnippet, "favicon", "image", "lang"}, index.Numeric...)
+	if opts.Sort != "" {
+		req.SortBy([]string{"-" + opts.Sort, "-_score"})
+	}
+	res, err := b.idx.SearchInContext(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	results := make([]index.Result, len(res.Hits))
+	for i, h := range res.Hits {
+		str := func(f string) string { s, _ := h.Fields[f].(string); return s }
+		values := make(map[string]int64)
+		for _, f := range index.Numeric {
+			switch v := h.Fields[f].(type) {
+			case float64:
+				values[f] = int64(v)
+			case string:
+				if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+					values[f] = t.Unix()
+				}
+			}
+		}
+		results[i] = index.Result{
+			DocInfo: index.DocInfo{URL: h.ID, Title: str(index.FieldTitle), Snippet: str(index.FieldSnippet), Favicon: str("favicon"), Image: str("image"), Lang: str("lang"), Values: values},
+			Score:   h.Score,
+		}
+	}
+	return results, nil
+}
+
+// BleveBatch is how many pages a Bleve rebuild indexes per batch.
+const BleveBatch = 1000
+
+// rebuildBleve is rebuildIndex for the Bleve index in BLEVE_DIR: it is
+// built beside the old one and replaces it when complete, picking up a
+// new BLEVE_ANALYZER.
+func rebuildBleve(ctx context.Context, store Store) error {
+	start := time.Now()
+	dir := bleveDir()
+	tmp := dir + ".new"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	b, err := openBleve(tmp, true, false)
+	if err != nil {
+		return err
+	}
+	batch := b.idx.NewBatch()
+	err = eachDocument(ctx, store, func(d index.Document) error {
+		if err := batch.Index(d.URL, bleveDoc(d)); err != nil {
+			return err
+		}
+		if batch.Size() < BleveBatch {
+			return nil
+		}
+		err := b.idx.Batch(batch)
+		batch.Reset()
+		return err
+	})
+	if err == nil {
+		err = b.idx.Batch(batch)
+	}
+	var n uint64
+	if err == nil {
+		n, err = b.idx.DocCount()
+	}
+	if cerr := b.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return err
+	}
+	log.Printf("bleve: %d documents saved to %s in %s", n, dir, time.Since(start).Round(time.Second))
+	return nil
+}
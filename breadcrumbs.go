@@ -0,0 +1,127 @@
+package main
+
+import (
+	"net/url"
+	"slices"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Breadcrumbs -----
+
+// MaxCrumbs bounds trails; anything deeper is a mis-parsed menu.
+const MaxCrumbs = 10
+
+// Crumb is one step of a breadcrumb trail, root first. URL is empty for
+// steps that aren't links, usually the current page.
+type Crumb struct {
+	Name string `bson:"name"`
+	URL  string `bson:"url,omitempty"`
+}
+
+// breadcrumbSelectors find visible trails on pages without markup.
+const breadcrumbSelectors = `nav[aria-label*="breadcrumb" i], [class~="breadcrumb"], [class~="breadcrumbs"], ` +
+	`[id="breadcrumb"], [id="breadcrumbs"], .breadcrumb-trail`
+
+// extractBreadcrumbs reads a BreadcrumbList from JSON-LD or microdata,
+// else the links of a breadcrumb nav. Only the first trail is kept; pages
+// listed under several sections mark up one trail per section.
+func extractBreadcrumbs(doc *goquery.Document, base *url.URL) []Crumb {
+	nodes := jsonLD(doc)
+	for _, syntax := range []markupSyntax{microdataSyntax, rdfaSyntax} {
+		nodes = append(nodes, markupNodes(doc, syntax)...)
+	}
+	for _, node := range nodes {
+		if slices.Contains(ldTypes(node["@type"]), "BreadcrumbList") {
+			if trail := breadcrumbList(node, base); len(trail) > 0 {
+				return trail
+			}
+		}
+	}
+
+	var trail []Crumb
+	nav := doc.Find(breadcrumbSelectors).First()
+	items := nav.Find("li")
+	if items.Length() == 0 {
+		items = nav.Find("a, span:not(:has(a))")
+	}
+	items.EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		name := visibleText(s)
+		if name == "" || len(name) > MaxAnchorChars {
+			return true
+		}
+		c := Crumb{Name: safeUTF8(name)}
+		href := s.AttrOr("href", "")
+		if href == "" {
+			href = s.Find("a[href]").First().AttrOr("href", "")
+		}
+		if href != "" {
+			c.URL = resolveRef(base, href)
+		}
+		trail = append(trail, c)
+		return len(trail) < MaxCrumbs
+	})
+	if len(trail) < 2 {
+		return nil // a lone link is a "back to" or home link, not a trail
+	}
+	return trail
+}
+
+// breadcrumbList orders a BreadcrumbList's ListItems by position. The
+// item may be a URL or a Thing carrying the name and @id.
+func breadcrumbList(node map[string]any, base *url.URL) []Crumb {
+	type ranked struct {
+		pos float64
+		c   Crumb
+	}
+	var list []ranked
+	for _, v := range ldObjects(node["itemListElement"]) {
+		c := Crumb{Name: ldString(v["name"])}
+		item := v["item"]
+		if thing, ok := item.(map[string]any); ok && c.Name == "" {
+			c.Name = ldString(thing["name"])
+		}
+		if href := ldURL(item); href != "" {
+			c.URL = resolveRef(base, href)
+		}
+		if c.Name == "" {
+			continue
+		}
+		c.Name = safeUTF8(c.Name)
+		list = append(list, ranked{ldFloat(v["position"]), c})
+	}
+	slices.SortStableFunc(list, func(a, b ranked) int {
+		switch {
+		case a.pos < b.pos:
+			return -1
+		case a.pos > b.pos:
+			return 1
+		}
+		return 0
+	})
+	var trail []Crumb
+	for _, r := range list {
+		if len(trail) == MaxCrumbs {
+			break
+		}
+		trail = append(trail, r.c)
+	}
+	return trail
+}
+
+// ldObjects returns an object value, or the objects of a list.
+func ldObjects(v any) []map[string]any {
+	switch v := v.(type) {
+	case map[string]any:
+		return []map[string]any{v}
+	case []any:
+		var out []map[string]any
+		for _, e := range v {
+			if m, ok := e.(map[string]any); ok {
+				out = append(out, m)
+			}
+		}
+		return out
+	}
+	return nil
+}
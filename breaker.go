@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ----- Per-host circuit breaker -----
+
+const (
+	BreakerThreshold   = 5 // consecutive failures that open a host's circuit
+	BreakerCooldown    = time.Minute
+	BreakerMaxCooldown = 30 * time.Minute
+	BreakerMaxTrips    = 4 // trips after which a host is abandoned for the run
+)
+
+// hostTrip records a circuit opening, for the run report.
+type hostTrip struct {
+	Host     string        `bson:"host"`
+	At       time.Time     `bson:"at"`
+	Failures int           `bson:"failures"`
+	Cooldown time.Duration `bson:"cooldown"`
+	Error    string        `bson:"error"`
+}
+
+type breakerState struct {
+	failures  int // consecutive
+	trips     int // since the last success
+	openUntil time.Time
+}
+
+// hostBreaker stops crawling hosts that keep timing out or returning 5xx.
+// After threshold consecutive failures a host is skipped for a cool-down
+// that doubles with every trip. Once it expires fetches resume, on
+// probation: one success closes the circuit, one failure reopens it. After
+// maxTrips the host is given up on until the next run.
+type hostBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	maxTrips  int
+
+	mu    sync.Mutex
+	hosts map[string]*breakerState
+	trips []hostTrip
+}
+
+func newHostBreaker() *hostBreaker {
+	return &hostBreaker{
+		threshold: max(getEnvInt("BREAKER_THRESHOLD", BreakerThreshold), 1),
+		cooldown:  getEnvDuration("BREAKER_COOLDOWN", BreakerCooldown),
+		maxTrips:  getEnvInt("BREAKER_MAX_TRIPS", BreakerMaxTrips),
+		hosts:     make(map[string]*breakerState),
+	}
+}
+
+// Open reports whether host is currently cooling down and for how long.
+// dead means the host has been abandoned for the run.
+func (b *hostBreaker) Open(host string) (wait time.Duration, dead, open bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.hosts[host]
+	if !ok {
+		return 0, false, false
+	}
+	if b.maxTrips > 0 && s.trips >= b.maxTrips {
+		return 0, true, true
+	}
+	wait = time.Until(s.openUntil)
+	return wait, false, wait > 0
+}
+
+func (b *hostBreaker) Success(host string) {
+	b.mu.Lock()
+	delete(b.hosts, host)
+	b.mu.Unlock()
+}
+
+// Failure counts a failed fetch against host and reports whether it opened
+// the circuit.
+func (b *hostBreaker) Failure(host string, err error) (time.Duration, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	s, ok := b.hosts[host]
+	if !ok {
+		s = &breakerState{}
+		b.hosts[host] = s
+	}
+	s.failures++
+	if s.failures < b.threshold {
+		return 0, false
+	}
+
+	cooldown := min(b.cooldown<<s.trips, BreakerMaxCooldown)
+	s.trips++
+	s.openUntil = time.Now().Add(cooldown)
+	b.trips = append(b.trips, hostTrip{
+		Host:     host,
+		At:       time.Now().UTC(),
+		Failures: s.failures,
+		Cooldown: cooldown,
+		Error:    err.Error(),
+	})
+	return cooldown, true
+}
+
+// Trips returns the circuit openings so far.
+func (b *hostBreaker) Trips() []hostTrip {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]hostTrip(nil), b.trips...)
+}
+
+// hostFailure reports whether err says something about the host as a
+// whole (timeouts, refused connections, 5xx) rather than about one URL.
+func hostFailure(err error) bool {
+	var fe *fetchError
+	if !errors.As(err, &fe) {
+		return false
+	}
+	if fe.Status != 0 {
+		return fe.Status >= 500
+	}
+	return fe.retryable
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"hash/fnv"
+	"strings"
+	"time"
+)
+
+// ----- Change tracking -----
+
+// ChangeSketchSize is the number of MinHash values kept per page; change
+// ratios are estimated to within about 1/ChangeSketchSize.
+const ChangeSketchSize = 64
+
+// MinChangeRatio is the share of main content that has to differ before a
+// change speeds up recrawling. Smaller edits are usually a date, a counter
+// or a rotated teaser.
+const MinChangeRatio = 0.05
+
+// pageChange compares a visit's main content with the previous visit's.
+type pageChange struct {
+	Hash        string
+	Sketch      []uint32
+	Changed     bool
+	Ratio       float64 // 0 = same, 1 = nothing in common
+	LastChanged time.Time
+}
+
+// detectChange fingerprints mainText and compares it with prev. First
+// visits count as changed at crawl time. Pages stored before main-content
+// fingerprints existed fall back to the full-text hash.
+func detectChange(prev *pageMeta, mainText, contentHashNow string) pageChange {
+	now := time.Now().UTC()
+	c := pageChange{Hash: contentHash(mainText), Sketch: minHashSketch(mainText), LastChanged: now}
+	switch {
+	case prev == nil:
+		c.Changed, c.Ratio = true, 1
+		return c
+	case prev.MainHash == "":
+		c.Changed = prev.ContentHash != contentHashNow
+	default:
+		c.Changed = prev.MainHash != c.Hash
+	}
+	if !c.Changed {
+		c.LastChanged = prev.LastChanged
+		return c
+	}
+	c.Ratio = 1
+	if len(c.Sketch) == ChangeSketchSize && len(prev.MainSketch) == ChangeSketchSize {
+		same := 0
+		for i, v := range c.Sketch {
+			if prev.MainSketch[i] == v {
+				same++
+			}
+		}
+		c.Ratio = 1 - float64(same)/ChangeSketchSize
+	}
+	return c
+}
+
+// significant reports whether the change should shorten the recrawl interval.
+func (c pageChange) significant() bool {
+	return c.Changed && c.Ratio >= MinChangeRatio
+}
+
+func (c pageChange) apply(p *Page) {
+	p.MainHash = c.Hash
+	p.MainSketch = c.Sketch
+	p.Changed = c.Changed
+	p.ChangeRatio = c.Ratio
+	p.LastChanged = c.LastChanged
+}
+
+// minHashSketch summarizes text's word shingles so that the share of
+// positions two sketches agree on estimates the shingles' Jaccard
+// similarity. Texts shorter than one shingle have no sketch.
+func minHashSketch(text string) []uint32 {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) < SimHashShingle {
+		return nil
+	}
+	sketch := make([]uint32, ChangeSketchSize)
+	for i := range sketch {
+		sketch[i] = ^uint32(0)
+	}
+	h := fnv.New64a()
+	for i := 0; i+SimHashShingle <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+SimHashShingle], " ")))
+		sum := h.Sum64()
+		// Double hashing derives the ChangeSketchSize hash functions
+		// from one 64-bit hash.
+		h1, h2 := uint32(sum), uint32(sum>>32)|1
+		for k := range sketch {
+			if v := h1 + uint32(k)*h2; v < sketch[k] {
+				sketch[k] = v
+			}
+		}
+	}
+	return sketch
+}
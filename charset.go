@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+// ----- Character sets -----
+
+// utf8Reader returns body transcoded to UTF-8. The encoding is taken from
+// a BOM, the Content-Type charset, or a <meta charset> / http-equiv tag in
+// the first 1024 bytes. Undeclared bodies that are valid UTF-8 are kept
+// as they are; anything else falls back to windows-1252, as browsers do.
+func utf8Reader(body []byte, contentType string) io.Reader {
+	enc, _, certain := charset.DetermineEncoding(body, contentType)
+	if enc == unicode.UTF8 || enc == encoding.Nop || (!certain && utf8.Valid(body)) {
+		return bytes.NewReader(body)
+	}
+	return transform.NewReader(bytes.NewReader(body), enc.NewDecoder())
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Frontier checkpoints -----
+
+// MaxCheckpointBytes is the encoded size a checkpoint is filled up to,
+// leaving room under Mongo's 16 MB document limit for its other fields.
+// Items in flight or parked and the budgets always go in; the visited set
+// comes next, then the highest-priority frontier URLs that still fit. A
+// Bloom filter too big for what is left is saved without, and a resumed
+// run then re-checks already crawled URLs against the store.
+const MaxCheckpointBytes = 15 << 20
+
+// MaxCheckpointItems is the most frontier URLs considered for a checkpoint.
+const MaxCheckpointItems = 100000
+
+// MaxCheckpointVisited bounds the visited URLs saved with a checkpoint, so
+// they don't crowd out the frontier. URLs left out are re-checked against
+// the store on resume, which skips the pages that are still fresh.
+const MaxCheckpointVisited = 50000
+
+// checkpoint is the crawler state saved to crawl_checkpoints, one document
+// per run: every CHECKPOINT_EVERY pages, and when a run ends with work left
+// over. `crawl -resume <run-id>` starts a new run from it.
+type checkpoint struct {
+	RunID        primitive.ObjectID `bson:"_id"`
+	CreatedAt    time.Time          `bson:"created_at"`
+	PagesCrawled int                `bson:"pages_crawled"`
+	Frontier     []QueueItem        `bson:"frontier"`
+	Visited      []string           `bson:"visited"`
+	VisitedBloom *bloomState        `bson:"visited_bloom,omitempty"` // VISITED_SET=bloom
+	DomainPages  []domainCount      `bson:"domain_pages"`
+}
+
+// domainCount avoids dotted hostnames as BSON keys.
+type domainCount struct {
+	Domain string `bson:"domain"`
+	Pages  int    `bson:"pages"`
+}
+
+// snapshot captures the frontier, visited set and budgets, within
+// MaxCheckpointBytes. Items still in flight are saved as queued and left
+// out of visited, so a crash mid-fetch retries them on resume; the pages
+// and budget they hold are left out of the counts, or the retry would
+// count them twice.
+func (c *crawler) snapshot(runID primitive.ObjectID) checkpoint {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cp := checkpoint{
+		RunID:        runID,
+		CreatedAt:    time.Now().UTC(),
+		PagesCrawled: c.pagesCrawled,
+	}
+	domainPages := maps.Clone(c.domainPages)
+	for _, r := range c.reserved {
+		domainPages[r.key]--
+		if r.crawled {
+			cp.PagesCrawled--
+		}
+	}
+	budget := MaxCheckpointBytes
+	for d, n := range domainPages {
+		dc := domainCount{Domain: d, Pages: n}
+		cp.DomainPages = append(cp.DomainPages, dc)
+		budget -= arrayEntrySize(dc)
+	}
+	for _, item := range c.active {
+		cp.Frontier = append(cp.Frontier, item)
+		budget -= arrayEntrySize(item)
+	}
+	for _, items := range c.parked {
+		for _, item := range items {
+			cp.Frontier = append(cp.Frontier, item)
+			budget -= arrayEntrySize(item)
+		}
+	}
+	switch v := c.visited.(type) {
+	case urlLister:
+		v.Each(func(u string) {
+			if n := arrayEntrySize(u); len(cp.Visited) < MaxCheckpointVisited && n <= budget {
+				cp.Visited = append(cp.Visited, u)
+				budget -= n
+			}
+		})
+	case *bloomSet:
+		st := v.state()
+		if n := arrayEntrySize(st); n <= budget {
+			cp.VisitedBloom = &st
+			budget -= n
+		}
+	}
+	for _, item := range c.frontier.Top(MaxCheckpointItems) {
+		if c.seenLocked(item.URL) {
+			continue
+		}
+		n := arrayEntrySize(item)
+		if n > budget {
+			break
+		}
+		cp.Frontier = append(cp.Frontier, item)
+		budget -= n
+	}
+	return cp
+}
+
+// arrayEntrySize is v's encoded size as an element of a BSON array: type
+// byte, index key and value.
+func arrayEntrySize(v any) int {
+	const head = 1 + 8 // type byte, key of up to 7 digits and its NUL
+	if s, ok := v.(string); ok {
+		return head + 4 + len(s) + 1
+	}
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return head
+	}
+	return head + len(b)
+}
+
+func saveCheckpoint(ctx context.Context, db *mongo.Database, cp checkpoint) error {
+	_, err := db.Collection("crawl_checkpoints").ReplaceOne(ctx, bson.M{"_id": cp.RunID}, cp, options.Replace().SetUpsert(true))
+	return err
+}
+
+// runIDFromHex parses the run id given to -resume.
+func runIDFromHex(runID string) (primitive.ObjectID, error) {
+	id, err := primitive.ObjectIDFromHex(runID)
+	if err != nil {
+		return id, fmt.Errorf("resume: bad run id %q", runID)
+	}
+	return id, nil
+}
+
+func loadCheckpoint(ctx context.Context, db *mongo.Database, runID string) (*checkpoint, error) {
+	id, err := runIDFromHex(runID)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	err = db.Collection("crawl_checkpoints").FindOne(ctx, bson.M{"_id": id}).Decode(&cp)
+	if err == mongo.ErrNoDocuments {
+		return nil, fmt.Errorf("resume: no checkpoint for run %s", runID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// restore loads a checkpoint into a crawler that has not started yet.
+func (c *crawler) restore(cp *checkpoint) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pagesCrawled = cp.PagesCrawled
+	for _, u := range cp.Visited {
+		c.visited.Add(u)
+	}
+	if b, ok := c.visited.(*bloomSet); ok && cp.VisitedBloom != nil && !b.load(*cp.VisitedBloom) {
+		log.Printf("resume: saved Bloom filter doesn't match BLOOM_CAPACITY/BLOOM_FP_RATE, ignoring it")
+	}
+	for _, dc := range cp.DomainPages {
+		c.domainPages[dc.Domain] = dc.Pages
+	}
+}
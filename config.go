@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// ----- Per-domain config -----
+
+// domainConfig holds overrides for one domain (and its subdomains).
+type domainConfig struct {
+	UserAgent string   `json:"user_agent"`
+	MaxPages  int      `json:"max_pages"` // pages per run, 0 = MAX_PAGES_PER_DOMAIN
+	MaxDepth  int      `json:"max_depth"` // link depth for seeds on this domain, 0 = MaxDepth
+	URLs      urlRules `json:"urls"`      // applied on top of the global rules
+	Renderer  string   `json:"renderer"`  // "chrome" renders pages in headless Chrome
+
+	// Extra request headers, e.g. Accept-Language, Referer or an API key.
+	Headers map[string]string `json:"headers"`
+
+	// Politeness: "delay" between requests to one host (e.g. "50ms"),
+	// and "jitter", the random extra as a fraction of it (e.g. 0.25).
+	// Unset values fall back to HOST_CRAWL_INTERVAL / HOST_CRAWL_JITTER.
+	Delay  duration `json:"delay"`
+	Jitter *float64 `json:"jitter"`
+}
+
+// duration is a time.Duration written in JSON as "1.5s" / "200ms", or as
+// a number of seconds.
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case float64:
+		*d = duration(v * float64(time.Second))
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = duration(parsed)
+	default:
+		return fmt.Errorf("duration: want a string like \"500ms\" or seconds, got %s", data)
+	}
+	return nil
+}
+
+// politenessFor applies host's domain config, if any, on top of def.
+func (c *crawlConfig) politenessFor(host string, def politeness) (politeness, bool) {
+	dc, ok := c.forHost(host)
+	if !ok || (dc.Delay == 0 && dc.Jitter == nil) {
+		return def, false
+	}
+	p := def
+	if dc.Delay > 0 {
+		p.Delay = time.Duration(dc.Delay)
+	}
+	if dc.Jitter != nil {
+		p.Jitter = *dc.Jitter
+	}
+	return p, true
+}
+
+// crawlConfig is loaded from the JSON file named by CRAWLER_CONFIG, e.g.
+//
+//	{
+//	  "urls": {"deny": ["[?&]sessionid="]},
+//	  "domains": {"example.com": {"user_agent": "ExampleBot/2.0"}}
+//	}
+//
+// See credentials for the "credentials" section.
+type crawlConfig struct {
+	URLs        urlRules                `json:"urls"`
+	Domains     map[string]domainConfig `json:"domains"`
+	Credentials map[string]credentials  `json:"credentials"`
+}
+
+// urlAllowed applies the global URL rules, then those of u's domain.
+func (c *crawlConfig) urlAllowed(u *url.URL) bool {
+	raw := u.String()
+	if !c.URLs.Allowed(raw) {
+		return false
+	}
+	dc, _ := c.forHost(u.Hostname())
+	return dc.URLs.Allowed(raw)
+}
+
+func loadCrawlConfig() (*crawlConfig, error) {
+	cfg := &crawlConfig{}
+	path := getEnv("CRAWLER_CONFIG", "")
+	if path == "" {
+		return cfg, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("crawler config: %w", err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("crawler config %s: %w", path, err)
+	}
+	// Key by the same punycode spelling hosts get in canonicalizeURL.
+	cfg.Domains = canonicalKeys(cfg.Domains)
+	cfg.Credentials = canonicalKeys(cfg.Credentials)
+	return cfg, nil
+}
+
+func canonicalKeys[V any](m map[string]V) map[string]V {
+	out := make(map[string]V, len(m))
+	for host, v := range m {
+		out[canonicalHost(host)] = v
+	}
+	return out
+}
+
+// forHost returns the entry for the most specific domain matching host.
+func (c *crawlConfig) forHost(host string) (domainConfig, bool) {
+	_, dc, ok := c.lookup(host)
+	return dc, ok
+}
+
+// lookup is forHost that also reports which configured domain matched.
+func (c *crawlConfig) lookup(host string) (string, domainConfig, bool) {
+	host = strings.ToLower(host)
+	for {
+		if dc, ok := c.Domains[host]; ok {
+			return host, dc, true
+		}
+		i := strings.IndexByte(host, '.')
+		if i < 0 {
+			return "", domainConfig{}, false
+		}
+		host = host[i+1:]
+	}
+}
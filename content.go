@@ -0,0 +1,44 @@
+package main
+
+import (
+	"mime"
+	"strings"
+)
+
+// ----- Document types -----
+
+// MaxPDFBytes is the download limit for PDFs, which run far larger than
+// HTML pages for the same amount of text.
+const MaxPDFBytes = 16 * 1024 * 1024
+
+// documentTypes are the media types the crawler indexes, with the most
+// it will download of each.
+var documentTypes = map[string]int64{
+	"text/html":             MaxBodyBytes,
+	"application/xhtml+xml": MaxBodyBytes,
+	"application/pdf":       MaxPDFBytes,
+	"text/plain":            MaxBodyBytes,
+	"text/markdown":         MaxBodyBytes,
+	"text/x-markdown":       MaxBodyBytes,
+}
+
+// documentType returns the media type of a Content-Type header and the
+// body limit for it, or false if pages of that type aren't indexed.
+func documentType(contentType string) (string, int64, bool) {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mt = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	}
+	limit, ok := documentTypes[mt]
+	return mt, limit, ok
+}
+
+// isText reports whether a media type is text to transcode to UTF-8.
+func isText(mediaType string) bool {
+	return isHTML(mediaType) || strings.HasPrefix(mediaType, "text/")
+}
+
+// isHTML reports whether a media type from documentType is parsed as HTML.
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
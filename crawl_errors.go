@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Crawl errors -----
+
+// CrawlError is the latest failure recorded for a URL in crawl_errors.
+type CrawlError struct {
+	URL       string    `bson:"url"`
+	Status    int       `bson:"status"` // 0 when no response was received
+	Error     string    `bson:"error"`
+	Attempts  int       `bson:"attempts"`
+	Permanent bool      `bson:"permanent"` // 4xx, DNS failure, unsupported content
+	Failures  int       `bson:"failures"`  // total failed fetches across runs
+	Time      time.Time `bson:"time"`
+
+	TLS *tlsFailure `bson:"tls,omitempty"` // certificate validation failure details
+}
+
+// newCrawlError is the record of err for pageURL, without the failure
+// count.
+func newCrawlError(pageURL string, err error) CrawlError {
+	ce := CrawlError{
+		URL:   safeUTF8(pageURL),
+		Error: safeUTF8(err.Error()),
+		Time:  time.Now().UTC(),
+	}
+	var fe *fetchError
+	if errors.As(err, &fe) {
+		ce.Status = fe.Status
+		ce.Attempts = fe.Attempts
+		ce.Permanent = !fe.retryable
+	}
+	ce.TLS, _ = certFailure(err)
+	return ce
+}
+
+func recordCrawlError(ctx context.Context, col *mongo.Collection, pageURL string, err error) error {
+	ce := newCrawlError(pageURL, err)
+	set := bson.M{
+		"status":    ce.Status,
+		"error":     ce.Error,
+		"attempts":  ce.Attempts,
+		"permanent": ce.Permanent,
+		"time":      ce.Time,
+	}
+	update := bson.M{"$set": set, "$inc": bson.M{"failures": 1}}
+	if ce.TLS != nil {
+		set["tls"] = ce.TLS
+	} else {
+		update["$unset"] = bson.M{"tls": ""}
+	}
+	_, err = col.UpdateOne(ctx, bson.M{"url": ce.URL}, update, options.Update().SetUpsert(true))
+	return err
+}
+
+// clearCrawlError forgets past failures once a URL fetches successfully.
+func clearCrawlError(ctx context.Context, col *mongo.Collection, pageURL string) error {
+	_, err := col.DeleteOne(ctx, bson.M{"url": pageURL})
+	return err
+}
+
+// permanentlyFailed reports whether pageURL hit a permanent error within ttl.
+func permanentlyFailed(ctx context.Context, col *mongo.Collection, pageURL string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return false
+	}
+	filter := bson.M{
+		"url":       pageURL,
+		"permanent": true,
+		"time":      bson.M{"$gt": time.Now().UTC().Add(-ttl)},
+	}
+	return col.FindOne(ctx, filter).Err() == nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Publish dates and authors -----
+
+// MinPublishYear rejects placeholder dates such as 1970-01-01.
+const MinPublishYear = 1990
+
+var dateLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	time.RFC1123Z,
+	time.RFC1123,
+	"January 2, 2006",
+	"2 January 2006",
+	"Jan 2, 2006",
+}
+
+// parseDate reads the date formats found in meta tags, <time datetime>
+// and JSON-LD. Dates before MinPublishYear or more than a day ahead are
+// treated as missing.
+func parseDate(s string) (time.Time, bool) {
+	t, ok := parseTimestamp(s)
+	if !ok || t.Year() < MinPublishYear || t.After(time.Now().Add(24*time.Hour)) {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// parseTimestamp is parseDate without the range checks, for dates that
+// may lie in the future, such as event dates.
+func parseTimestamp(s string) (time.Time, bool) {
+	s = strings.TrimSpace(s)
+	for _, layout := range dateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// bylineRE strips the "By" and trailing roles common in visible bylines.
+var bylineRE = regexp.MustCompile(`(?i)^\s*(?:by|von|par|por|di)\s+|\s*[|,]\s*(?:staff|reporter|editor|correspondent).*$`)
+
+// articleInfo is what articleMeta found; zero fields were not found.
+type articleInfo struct {
+	Published time.Time
+	Modified  time.Time
+	Author    string
+}
+
+// articleMeta looks for publish and modified dates and an author, in order
+// of how reliable each source tends to be: Open Graph article tags and
+// JSON-LD first, then <time> elements and author meta tags, then the
+// visible byline.
+func articleMeta(doc *goquery.Document) articleInfo {
+	var info articleInfo
+	setDate := func(dst *time.Time, s string) {
+		if dst.IsZero() {
+			if t, ok := parseDate(s); ok {
+				*dst = t
+			}
+		}
+	}
+	setAuthor := func(s string) {
+		if info.Author == "" {
+			info.Author = cleanByline(s)
+		}
+	}
+
+	for _, prop := range []string{"article:published_time", "og:published_time", "datePublished"} {
+		setDate(&info.Published, doc.Find(`meta[property="`+prop+`"], meta[itemprop="`+prop+`"]`).AttrOr("content", ""))
+	}
+	for _, prop := range []string{"article:modified_time", "og:updated_time", "dateModified"} {
+		setDate(&info.Modified, doc.Find(`meta[property="`+prop+`"], meta[itemprop="`+prop+`"]`).AttrOr("content", ""))
+	}
+	setAuthor(doc.Find(`meta[property="article:author"]`).AttrOr("content", ""))
+
+	for _, node := range jsonLD(doc) {
+		if s, ok := node["datePublished"].(string); ok {
+			setDate(&info.Published, s)
+		}
+		if s, ok := node["dateModified"].(string); ok {
+			setDate(&info.Modified, s)
+		}
+		setAuthor(ldName(node["author"]))
+	}
+
+	doc.Find("time[datetime]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		setDate(&info.Published, s.AttrOr("datetime", ""))
+		return info.Published.IsZero()
+	})
+	setAuthor(doc.Find(`meta[name="author"]`).AttrOr("content", ""))
+	setAuthor(visibleText(doc.Find(`[itemprop="author"], [rel="author"], .byline, .author`).First()))
+	return info
+}
+
+// cleanByline turns "By Jane Doe | Staff Writer" into "Jane Doe". Values
+// that are URLs (article:author is often a profile link) or too long to be
+// a name are dropped.
+func cleanByline(s string) string {
+	s = cleanText(bylineRE.ReplaceAllString(s, ""))
+	if strings.Contains(s, "://") || len([]rune(s)) > 100 {
+		return ""
+	}
+	return s
+}
+
+// ldName reads a JSON-LD value that may be a name, a Person or
+// Organization object, or a list of either; lists yield the first name.
+func ldName(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case map[string]any:
+		s, _ := v["name"].(string)
+		return s
+	case []any:
+		for _, e := range v {
+			if s := ldName(e); s != "" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// jsonLD decodes the page's JSON-LD blocks into a flat list of nodes,
+// unpacking top-level arrays and @graph. Blocks that don't parse are
+// skipped; hand-written JSON-LD is often invalid.
+func jsonLD(doc *goquery.Document) []map[string]any {
+	var nodes []map[string]any
+	var collect func(v any)
+	collect = func(v any) {
+		switch v := v.(type) {
+		case []any:
+			for _, e := range v {
+				collect(e)
+			}
+		case map[string]any:
+			if g, ok := v["@graph"]; ok {
+				collect(g)
+				return
+			}
+			nodes = append(nodes, v)
+		}
+	}
+	doc.Find(`script[type="application/ld+json"]`).Each(func(_ int, s *goquery.Selection) {
+		var v any
+		if json.Unmarshal([]byte(s.Text()), &v) == nil {
+			collect(v)
+		}
+	})
+	return nodes
+}
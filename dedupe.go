@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Duplicate detection -----
+
+// MinDedupeChars keeps near-empty pages (which all hash alike) out of
+// duplicate matching.
+const MinDedupeChars = 100
+
+// findDuplicate returns the URL of another stored page with the same content
+// hash, or "" when there is none.
+func findDuplicate(ctx context.Context, col *mongo.Collection, hash, pageURL string) (string, error) {
+	var doc struct {
+		URL string `bson:"url"`
+	}
+	filter := bson.M{"content_hash": hash, "url": bson.M{"$ne": pageURL}}
+	err := col.FindOne(ctx, filter, options.FindOne().SetProjection(bson.M{"url": 1})).Decode(&doc)
+	if err == mongo.ErrNoDocuments {
+		return "", nil
+	}
+	return doc.URL, err
+}
+
+func addAlias(ctx context.Context, col *mongo.Collection, pageURL, alias string) error {
+	_, err := col.UpdateOne(ctx, bson.M{"url": pageURL}, bson.M{"$addToSet": bson.M{"aliases": alias}})
+	return err
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// ----- Domain scope -----
+
+// domainPolicy is how ALLOWED_DOMAINS entries match hosts, chosen with
+// DOMAIN_POLICY:
+//
+//	subdomains  the domain and any subdomain of it (the default)
+//	exact       the host exactly as listed
+//	registered  anything under the same registered domain (eTLD+1), so
+//	            "blog.example.co.uk" admits "www.example.co.uk"
+//	wildcard    glob patterns such as "*.example.com" or "docs.*.org";
+//	            "*" may span dots
+type domainPolicy int
+
+const (
+	policySubdomains domainPolicy = iota
+	policyExact
+	policyRegistered
+	policyWildcard
+)
+
+// domainScope limits the crawl to ALLOWED_DOMAINS; empty allows all.
+type domainScope struct {
+	policy  domainPolicy
+	domains []string
+}
+
+func domainScopeFromEnv() (domainScope, error) {
+	var s domainScope
+	switch v := strings.ToLower(getEnv("DOMAIN_POLICY", "subdomains")); v {
+	case "subdomains":
+		s.policy = policySubdomains
+	case "exact":
+		s.policy = policyExact
+	case "registered":
+		s.policy = policyRegistered
+	case "wildcard":
+		s.policy = policyWildcard
+	default:
+		return s, fmt.Errorf("DOMAIN_POLICY: unknown policy %q (want subdomains, exact, registered or wildcard)", v)
+	}
+
+	for _, d := range strings.Split(getEnv("ALLOWED_DOMAINS", ""), ",") {
+		if d = strings.TrimSpace(d); d == "" {
+			continue
+		}
+		switch s.policy {
+		case policyWildcard:
+			d = strings.ToLower(d)
+			if _, err := path.Match(d, ""); err != nil {
+				return s, fmt.Errorf("ALLOWED_DOMAINS: bad pattern %q", d)
+			}
+		case policyRegistered:
+			d = registeredDomain(canonicalHost(d))
+		default:
+			d = canonicalHost(d)
+		}
+		s.domains = append(s.domains, d)
+	}
+	return s, nil
+}
+
+// Allows reports whether host is in scope.
+func (s domainScope) Allows(host string) bool {
+	if len(s.domains) == 0 {
+		return true
+	}
+	host = strings.ToLower(host)
+	if s.policy == policyRegistered {
+		host = registeredDomain(host)
+	}
+	for _, d := range s.domains {
+		switch s.policy {
+		case policyExact, policyRegistered:
+			if host == d {
+				return true
+			}
+		case policySubdomains:
+			if host == d || strings.HasSuffix(host, "."+d) {
+				return true
+			}
+		case policyWildcard:
+			if ok, _ := path.Match(d, host); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// registeredDomain is host's eTLD+1, or host itself for IPs, bare public
+// suffixes and single-label names.
+func registeredDomain(host string) string {
+	if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return d
+	}
+	return host
+}
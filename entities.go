@@ -0,0 +1,125 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ----- Entity validation -----
+//
+// Markup is hand-written and often wrong: prices without currencies,
+// ratings on a 10-point scale, events that end before they start. The
+// validate methods normalize what they can and clear what they can't, so
+// stored fields can be trusted by vertical search without re-checking.
+
+// MaxRecipeMinutes bounds recipe times; a week covers curing and brining.
+const MaxRecipeMinutes = 7 * 24 * 60
+
+// MaxIngredients bounds ingredient lists.
+const MaxIngredients = 100
+
+var currencyRE = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// currencySymbols maps the symbols sites put in priceCurrency to ISO 4217
+// codes. "$" is taken to be USD, the most common reading.
+var currencySymbols = map[string]string{
+	"$": "USD", "US$": "USD", "€": "EUR", "£": "GBP", "¥": "JPY", "₹": "INR",
+	"C$": "CAD", "A$": "AUD", "R$": "BRL", "₩": "KRW", "₽": "RUB", "CHF": "CHF",
+}
+
+// availabilityTerms is schema.org's ItemAvailability enumeration.
+var availabilityTerms = map[string]bool{
+	"InStock": true, "OutOfStock": true, "PreOrder": true, "BackOrder": true,
+	"Discontinued": true, "LimitedAvailability": true, "SoldOut": true,
+	"OnlineOnly": true, "InStoreOnly": true, "PreSale": true, "MadeToOrder": true,
+	"Reserved": true,
+}
+
+// eventStatusTerms is schema.org's EventStatusType enumeration.
+var eventStatusTerms = map[string]bool{
+	"EventScheduled": true, "EventCancelled": true, "EventPostponed": true,
+	"EventRescheduled": true, "EventMovedOnline": true,
+}
+
+// currencyCode returns the ISO 4217 code for s, or "".
+func currencyCode(s string) string {
+	s = strings.TrimSpace(s)
+	if code, ok := currencySymbols[s]; ok {
+		return code
+	}
+	if s = strings.ToUpper(s); currencyRE.MatchString(s) {
+		return s
+	}
+	return ""
+}
+
+// normalizeRating rescales value from [worst, best] to 0-5; best and worst
+// default to 5 and 1 as in schema.org. Values outside the scale return 0.
+func normalizeRating(value, best, worst float64) float64 {
+	if best == 0 {
+		best = 5
+	}
+	if worst == 0 && best == 5 {
+		worst = 1
+	}
+	if best <= worst || value < worst || value > best || math.IsNaN(value) {
+		return 0
+	}
+	if best == 5 {
+		return value
+	}
+	return math.Round(value/best*5*100) / 100
+}
+
+// durationMinutes reads an ISO 8601 duration as whole minutes, rounded
+// up; 0 if missing or implausible for a recipe.
+func durationMinutes(s string) int {
+	m := (parseDuration(s) + 59) / 60
+	if m <= 0 || m > MaxRecipeMinutes {
+		return 0
+	}
+	return m
+}
+
+// validate keeps a price only with a positive amount and a known currency,
+// and an availability only from the schema.org enumeration.
+func (p *ProductEntity) validate() bool {
+	p.Currency = currencyCode(p.Currency)
+	if p.Price <= 0 || math.IsInf(p.Price, 0) || math.IsNaN(p.Price) || p.Currency == "" {
+		p.Price, p.Currency = 0, ""
+	}
+	if !availabilityTerms[p.Availability] {
+		p.Availability = ""
+	}
+	p.ReviewCount = max(p.ReviewCount, 0)
+	return p.Name != ""
+}
+
+// validate fills TotalMinutes from prep and cook time when it's missing
+// and trims the ingredient list.
+func (r *RecipeEntity) validate() bool {
+	if r.TotalMinutes == 0 && r.PrepMinutes+r.CookMinutes <= MaxRecipeMinutes {
+		r.TotalMinutes = r.PrepMinutes + r.CookMinutes
+	}
+	var ingredients []string
+	for _, s := range r.Ingredients {
+		if s = cleanText(s); s != "" && len(ingredients) < MaxIngredients {
+			ingredients = append(ingredients, s)
+		}
+	}
+	r.Ingredients = ingredients
+	return r.Name != ""
+}
+
+// validate requires a start date and drops an end date before it.
+func (e *EventEntity) validate() bool {
+	if !e.End.IsZero() && e.End.Before(e.Start) {
+		e.End = time.Time{}
+	}
+	if !eventStatusTerms[e.Status] {
+		e.Status = ""
+	}
+	return e.Name != "" && !e.Start.IsZero()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ----- Favicons -----
+
+// faviconChecker verifies that favicon URLs serve an image. Answers are
+// kept for the run, so a site's icon costs one request rather than one per
+// page.
+type faviconChecker struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	known map[string]bool // icon URL -> serves an image
+}
+
+func newFaviconChecker(client *http.Client) *faviconChecker {
+	return &faviconChecker{client: client, known: make(map[string]bool)}
+}
+
+// resolve picks the favicon to store for page: the declared icon if it is
+// an image, else the site's /favicon.ico if that is, else "".
+func (f *faviconChecker) resolve(ctx context.Context, declared string, page *url.URL) string {
+	if strings.HasPrefix(declared, "data:image/") {
+		return declared
+	}
+	if declared != "" && f.valid(ctx, declared) {
+		return declared
+	}
+	fallback := (&url.URL{Scheme: page.Scheme, Host: page.Host, Path: "/favicon.ico"}).String()
+	if fallback != declared && f.valid(ctx, fallback) {
+		return fallback
+	}
+	return ""
+}
+
+func (f *faviconChecker) valid(ctx context.Context, icon string) bool {
+	f.mu.Lock()
+	ok, seen := f.known[icon]
+	f.mu.Unlock()
+	if seen {
+		return ok
+	}
+	ok = f.check(ctx, icon)
+	if ctx.Err() == nil { // an interrupted check says nothing about the icon
+		f.mu.Lock()
+		f.known[icon] = ok
+		f.mu.Unlock()
+	}
+	return ok
+}
+
+// check asks for icon with HEAD, retrying with GET where HEAD isn't
+// supported, and accepts a 2xx image response. Error pages served with
+// status 200 fail on their HTML content type.
+func (f *faviconChecker) check(ctx context.Context, icon string) bool {
+	u, err := url.Parse(icon)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, icon, nil)
+		if err != nil {
+			return false
+		}
+		resp, err := f.client.Do(req)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented {
+			continue
+		}
+		mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+		return resp.StatusCode >= 200 && resp.StatusCode < 300 && strings.HasPrefix(mt, "image/")
+	}
+	return false
+}
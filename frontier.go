@@ -0,0 +1,120 @@
+package main
+
+import (
+	"container/heap"
+	"math"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// ----- Crawl frontier -----
+
+// FrontierSignals are the facts about a URL available when it is queued.
+type FrontierSignals struct {
+	Inlinks       int // times the URL has been discovered this run
+	HostRemaining int // pages left in the host's budget, -1 when unlimited
+}
+
+// ScoreFunc ranks frontier items; higher scores are crawled first. Ties
+// are broken in discovery order.
+type ScoreFunc func(item QueueItem, sig FrontierSignals) float64
+
+// DefaultScore prefers shallow, often-linked, short URLs on hosts that still
+// have budget left. With no inlinks or budgets in play it degrades to BFS.
+func DefaultScore(item QueueItem, sig FrontierSignals) float64 {
+	score := -10 * float64(item.Depth)
+	score += 2 * math.Log1p(float64(sig.Inlinks))
+
+	if u, err := url.Parse(item.URL); err == nil {
+		segments := strings.Count(strings.Trim(u.Path, "/"), "/")
+		if u.Path != "/" {
+			segments++
+		}
+		score -= 0.5 * float64(segments)
+		if u.RawQuery != "" {
+			score -= 1 + float64(strings.Count(u.RawQuery, "&"))
+		}
+	}
+
+	if sig.HostRemaining == 0 {
+		score -= 1000 // would be dropped at fetch time anyway
+	}
+	return score
+}
+
+type frontierEntry struct {
+	item  QueueItem
+	score float64
+	seq   uint64
+}
+
+type frontierHeap []frontierEntry
+
+func (h frontierHeap) Len() int { return len(h) }
+func (h frontierHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].seq < h[j].seq
+}
+func (h frontierHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *frontierHeap) Push(x any)   { *h = append(*h, x.(frontierEntry)) }
+func (h *frontierHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return e
+}
+
+// frontier is a priority queue of URLs. Rediscovering a queued URL pushes
+// it again with its new score; the stale entry is skipped by the visited
+// check when popped. Not safe for concurrent use; the crawler locks it.
+type frontier struct {
+	heap    frontierHeap
+	seq     uint64
+	lifo    bool // ties go to the most recently pushed entry
+	inlinks map[string]int
+}
+
+func newFrontier(lifo bool) *frontier {
+	return &frontier{lifo: lifo, inlinks: make(map[string]int)}
+}
+
+func (f *frontier) Len() int { return f.heap.Len() }
+
+// Inlinks is how often u has been discovered.
+func (f *frontier) Inlinks(u string) int { return f.inlinks[u] }
+
+// Discover counts an inlink and returns the updated count.
+func (f *frontier) Discover(u string) int {
+	f.inlinks[u]++
+	return f.inlinks[u]
+}
+
+func (f *frontier) Push(item QueueItem, score float64) {
+	f.seq++
+	seq := f.seq
+	if f.lifo {
+		seq = math.MaxUint64 - seq
+	}
+	heap.Push(&f.heap, frontierEntry{item: item, score: score, seq: seq})
+}
+
+func (f *frontier) Pop() QueueItem {
+	return heap.Pop(&f.heap).(frontierEntry).item
+}
+
+// Top returns up to n queued items in priority order without removing them.
+func (f *frontier) Top(n int) []QueueItem {
+	entries := append(frontierHeap(nil), f.heap...)
+	sort.Sort(entries)
+	items := make([]QueueItem, 0, min(n, len(entries)))
+	for _, e := range entries {
+		if len(items) == n {
+			break
+		}
+		items = append(items, e.item)
+	}
+	return items
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/realutkarshh/mini-search-crawler/index"
+)
+
+// ----- SQLite full-text backend -----
+
+// sqliteBackend reports whether SEARCH_BACKEND picks the SQLite full-text
+// index, which rebuilds and crawls then write to instead of the index in
+// INDEX_DIR.
+func sqliteBackend() bool {
+	return strings.EqualFold(getEnv("SEARCH_BACKEND", "index"), "sqlite")
+}
+
+// DefaultFTSTokenizer stems English, folds case and drops accents.
+const DefaultFTSTokenizer = "porter unicode61 remove_diacritics 2"
+
+// ftsSchema indexes the text of a page in a contentless FTS5 table, which
+// keeps only terms, and stores what results show and what is filtered and
+// sorted on in search_docs, under the same rowid; a search never reads a
+// body. The FTS5 tokenizer, SQLITE_TOKENIZER, is fixed when the table is
+// created; see ftsTables.
+//
+// The database's user_version is ftsVersion once the tables are created.
+// Version 1 kept the text in search_fts and no result fields in
+// search_docs; such tables are only replaced by a rebuild.
+const ftsSchema = `
+CREATE TABLE IF NOT EXISTS search_docs (
+	id        INTEGER PRIMARY KEY,
+	url       TEXT NOT NULL UNIQUE,
+	title     TEXT NOT NULL,
+	snippet   TEXT NOT NULL,
+	favicon   TEXT NOT NULL,
+	image     TEXT NOT NULL,
+	lang      TEXT NOT NULL,
+	crawled   INTEGER NOT NULL,
+	published INTEGER NOT NULL,
+	words     INTEGER NOT NULL,
+	inlinks   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS search_docs_lang ON search_docs (lang);
+CREATE VIRTUAL TABLE IF NOT EXISTS search_fts USING fts5(title, headings, snippet, anchors, body, content = '', contentless_delete = 1, tokenize = %s);
+`
+
+// ftsIndex indexes and searches pages with SQLite's FTS5, in the database
+// at SQLITE_PATH, so that with STORE=sqlite the whole engine is one file.
+// Queries read as they do for the index: every word must match in some
+// field, quoted phrases match as phrases and "crawl*" as a prefix; a
+// wildcard inside a word cuts it to a prefix there. Fields weigh
+// BOOST_<FIELD> in FTS5's BM25. Words are stemmed and stopwords kept by
+// SQLITE_TOKENIZER, DefaultFTSTokenizer unless set, whatever the page's
+// language.
+type ftsIndex struct {
+	db      *sql.DB
+	weights string // bm25() arguments, in column order
+}
+
+const ftsVersion = 2
+
+// openFTS opens the full-text index at path, creating its tables if
+// needed. Tables of an older layout fail to open unless rebuilding, which
+// replaces them.
+func openFTS(path string, rebuilding bool) (*ftsIndex, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	var version, tables int
+	err = db.QueryRow(`PRAGMA user_version`).Scan(&version)
+	if err == nil {
+		err = db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE name = 'search_docs'`).Scan(&tables)
+	}
+	if err == nil && tables > 0 && version != ftsVersion && !rebuilding {
+		err = errors.New("the full-text index has an older layout; rebuild it with SEARCH_BACKEND=sqlite index")
+	}
+	if err == nil && tables == 0 {
+		_, err = db.Exec(ftsTables() + fmt.Sprintf(`PRAGMA user_version = %d;`, ftsVersion))
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %s: %w", path, err)
+	}
+	var w []string
+	for _, f := range index.Fields {
+		w = append(w, fmt.Sprint(getEnvFloat("BOOST_"+strings.ToUpper(f), index.DefaultBoosts[f])))
+	}
+	return &ftsIndex{db: db, weights: strings.Join(w, ", ")}, nil
+}
+
+// ftsTables creates the tables of the full-text index unless they exist.
+func ftsTables() string {
+	return fmt.Sprintf(ftsSchema, sqlQuote(getEnv("SQLITE_TOKENIZER", DefaultFTSTokenizer)))
+}
+
+// sqlQuote quotes s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// addDoc indexes d in tx, replacing the page indexed under its URL.
+func addDoc(ctx context.Context, tx *sql.Tx, d index.Document) error {
+	if err := deleteDoc(ctx, tx, d.URL); err != nil {
+		return err
+	}
+	res, err := tx.ExecContext(ctx, `INSERT INTO search_docs (url, title, snippet, favicon, image, lang, crawled, published, words, inlinks)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		d.URL, d.Title, d.Snippet, d.Favicon, d.Image, d.Lang, unixOrZero(d.Crawled), unixOrZero(d.Published), d.Words, d.Inlinks)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO search_fts (rowid, title, headings, snippet, anchors, body) VALUES (?, ?, ?, ?, ?, ?)`,
+		id, d.Title, strings.Join(d.Headings, "\n"), d.Snippet, strings.Join(d.Anchors, "\n"), d.Body)
+	return err
+}
+
+// deleteDoc takes the page at url out of the index in tx.
+func deleteDoc(ctx context.Context, tx *sql.Tx, url string) error {
+	var id int64
+	err := tx.QueryRowContext(ctx, `SELECT id FROM search_docs WHERE url = ?`, url).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM search_fts WHERE rowid = ?`, id); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `DELETE FROM search_docs WHERE id = ?`, id)
+	return err
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds.
+func (f *ftsIndex) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := f.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Add indexes d, replacing the page indexed under its URL.
+func (f *ftsIndex) Add(d index.Document) error {
+	return f.inTx(context.Background(), func(tx *sql.Tx) error { return addDoc(context.Background(), tx, d) })
+}
+
+// Delete takes the page at url out of the index.
+func (f *ftsIndex) Delete(url string) error {
+	return f.inTx(context.Background(), func(tx *sql.Tx) error { return deleteDoc(context.Background(), tx, url) })
+}
+
+func (f *ftsIndex) Close() error { return f.db.Close() }
+
+// ftsQuery turns text into an FTS5 query, "" if it has nothing to search
+// for. Every word and phrase is quoted, so FTS5's operators in text are
+// searched for as words.
+func ftsQuery(text string) string {
+	var terms []string
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			if strings.TrimSpace(part) != "" {
+				terms = append(terms, `"`+part+`"`)
+			}
+			continue
+		}
+		for _, w := range strings.Fields(part) {
+			w = strings.TrimFunc(w, func(r rune) bool { return unicode.IsPunct(r) && r != '*' && r != '?' })
+			if j := strings.IndexAny(w, "*?"); j >= index.MinWildcardPrefix {
+				terms = append(terms, `"`+w[:j]+`"*`)
+				continue
+			}
+			if w = strings.Trim(w, "*?"); w != "" {
+				terms = append(terms, `"`+w+`"`)
+			}
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+func (f *ftsIndex) Search(ctx context.Context, text string, limit int, opts index.Options) ([]index.Result, error) {
+	match := ftsQuery(text)
+	if match == "" {
+		return nil, nil
+	}
+	where, args := []string{"search_fts MATCH ?"}, []any{match}
+	if opts.Lang != "" {
+		where, args = append(where, "d.lang = ?"), append(args, opts.Lang)
+	}
+	for _, r := range opts.Ranges {
+		if !slices.Contains(index.Numeric, r.Field) {
+			return nil, fmt.Errorf("search: unknown numeric field %q", r.Field)
+		}
+		where, args = append(where, "d."+r.Field+" >= ?"), append(args, max(r.Min, 1))
+		if r.Max != 0 {
+			where, args = append(where, "d."+r.Field+" <= ?"), append(args, r.Max)
+		}
+	}
+	order := "score DESC"
+	if opts.Sort != "" {
+		if !slices.Contains(index.Numeric, opts.Sort) {
+			return nil, fmt.Errorf("search: unknown numeric field %q", opts.Sort)
+		}
+		order = "d." + opts.Sort + " DESC, " + order
+	}
+	// bm25() is the lower the better; scores are negated to read like the
+	// index's.
+	query := fmt.Sprintf(`SELECT d.url, d.title, d.snippet, d.favicon, d.image, d.lang, d.crawled, d.published, d.words, d.inlinks,
+		-bm25(search_fts, %s) AS score
+		FROM search_fts JOIN search_docs d ON d.id = search_fts.rowid
+		WHERE %s ORDER BY %s LIMIT ?`, f.weights, strings.Join(where, " AND "), order)
+	rows, err := f.db.QueryContext(ctx, query, append(args, limit)...)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	defer rows.Close()
+	var results []index.Result
+	for rows.Next() {
+		var r index.Result
+		var crawled, published, words, inlinks int64
+		if err := rows.Scan(&r.URL, &r.Title, &r.Snippet, &r.Favicon, &r.Image, &r.Lang, &crawled, &published, &words, &inlinks, &r.Score); err != nil {
+			return nil, fmt.Errorf("search: %w", err)
+		}
+		r.Values = map[string]int64{index.NumCrawled: crawled, index.NumPublished: published, index.NumWords: words, index.NumInlinks: inlinks}
+		results = append(results, r)
+	}
+	return results, rows.Err()
+}
+
+// rebuildFTS is rebuildIndex for the full-text index at SQLITE_PATH: its
+// tables are recreated and filled in one transaction, picking up a new
+// SQLITE_TOKENIZER, and searches see the old index until the new one is
+// complete.
+func rebuildFTS(ctx context.Context, store Store) error {
+	start := time.Now()
+	f, err := openFTS(sqlitePath(), true)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	n := 0
+	err = f.inTx(ctx, func(tx *sql.Tx) error {
+		drop := `DROP TABLE IF EXISTS search_fts; DROP TABLE IF EXISTS search_docs;`
+		if _, err := tx.ExecContext(ctx, drop+ftsTables()+fmt.Sprintf(`PRAGMA user_version = %d;`, ftsVersion)); err != nil {
+			return err
+		}
+		return eachDocument(ctx, store, func(d index.Document) error {
+			n++
+			return addDoc(ctx, tx, d)
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := f.db.ExecContext(ctx, `INSERT INTO search_fts (search_fts) VALUES ('optimize')`); err != nil {
+		return err
+	}
+	log.Printf("sqlite: %d documents indexed in %s in %s", n, sqlitePath(), time.Since(start).Round(time.Second))
+	return nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Headings -----
+
+const (
+	MaxHeadings     = 50  // per level
+	MaxHeadingChars = 300 // longer "headings" are styled paragraphs
+)
+
+// Headings holds a page's h1-h3 text in document order, so the indexer can
+// weight it above body text and results can show section context.
+type Headings struct {
+	H1 []string `bson:"h1,omitempty"`
+	H2 []string `bson:"h2,omitempty"`
+	H3 []string `bson:"h3,omitempty"`
+}
+
+// add records text for tag ("h1", "h2" or "h3"); blank, overlong and
+// surplus headings are dropped.
+func (h *Headings) add(tag, text string) {
+	var level *[]string
+	switch tag {
+	case "h1":
+		level = &h.H1
+	case "h2":
+		level = &h.H2
+	case "h3":
+		level = &h.H3
+	default:
+		return
+	}
+	if text == "" || len([]rune(text)) > MaxHeadingChars || len(*level) >= MaxHeadings {
+		return
+	}
+	*level = append(*level, safeUTF8(text))
+}
+
+func extractHeadings(doc *goquery.Document) Headings {
+	var h Headings
+	doc.Find("body h1, body h2, body h3").Each(func(_ int, s *goquery.Selection) {
+		h.add(goquery.NodeName(s), visibleText(s))
+	})
+	return h
+}
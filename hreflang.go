@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- hreflang alternates -----
+
+// MaxAlternates bounds the translations kept per page; some sites list
+// every locale they have on every page.
+const MaxAlternates = 100
+
+// Alternate is a translated or regional version of a page, from
+// <link rel="alternate" hreflang="..." href="...">. Lang is the hreflang
+// value lowercased, e.g. "de", "en-gb" or "x-default".
+type Alternate struct {
+	Lang string `bson:"lang"`
+	URL  string `bson:"url"`
+}
+
+// alternateLink reads one <link> as an hreflang alternate.
+func alternateLink(rel, hreflang, href string, base *url.URL) (Alternate, bool) {
+	hreflang = strings.ToLower(strings.TrimSpace(hreflang))
+	if hreflang == "" || href == "" {
+		return Alternate{}, false
+	}
+	alt := false
+	for _, r := range strings.Fields(strings.ToLower(rel)) {
+		alt = alt || r == "alternate"
+	}
+	if !alt {
+		return Alternate{}, false
+	}
+	u, err := normalizeURL(base, href)
+	if err != nil {
+		return Alternate{}, false
+	}
+	return Alternate{Lang: safeUTF8(hreflang), URL: u.String()}, true
+}
+
+func extractAlternates(doc *goquery.Document, base *url.URL) []Alternate {
+	var alts []Alternate
+	doc.Find("link[hreflang]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if a, ok := alternateLink(s.AttrOr("rel", ""), s.AttrOr("hreflang", ""), s.AttrOr("href", ""), base); ok {
+			alts = append(alts, a)
+		}
+		return len(alts) < MaxAlternates
+	})
+	return alts
+}
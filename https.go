@@ -0,0 +1,100 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ----- HTTPS-only mode -----
+
+// httpsMode is HTTPS_ONLY: "skip" (or "true") drops http:// URLs,
+// "upgrade" rewrites them to https:// and crawls them only if the secure
+// fetch succeeds. Either way the transport refuses plain-HTTP requests, so
+// redirects can't downgrade a fetch.
+type httpsMode int
+
+const (
+	httpsAny httpsMode = iota
+	httpsSkip
+	httpsUpgrade
+)
+
+// errPlainHTTP is returned for http:// requests in HTTPS-only mode.
+var errPlainHTTP = errors.New("plain http refused in HTTPS_ONLY mode")
+
+func httpsModeFromEnv() (httpsMode, error) {
+	switch v := strings.ToLower(getEnv("HTTPS_ONLY", "")); v {
+	case "", "false", "off":
+		return httpsAny, nil
+	case "true", "skip":
+		return httpsSkip, nil
+	case "upgrade":
+		return httpsUpgrade, nil
+	default:
+		return httpsAny, fmt.Errorf("HTTPS_ONLY: unknown mode %q (want skip or upgrade)", v)
+	}
+}
+
+// apply returns rawURL as it may be crawled under m, or false to skip it.
+func (m httpsMode) apply(rawURL string) (string, bool) {
+	if m == httpsAny || !strings.HasPrefix(rawURL, "http:") {
+		return rawURL, true
+	}
+	if m == httpsSkip {
+		return "", false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", false
+	}
+	u.Scheme = "https"
+	if u.Port() == "80" {
+		u.Host = u.Hostname()
+	}
+	return u.String(), true
+}
+
+// tlsFailure describes a certificate that failed validation, as recorded
+// in crawl_errors.
+type tlsFailure struct {
+	Reason   string    `bson:"reason"` // unknown_authority, hostname, expired, invalid
+	Subject  string    `bson:"subject,omitempty"`
+	Issuer   string    `bson:"issuer,omitempty"`
+	DNSNames []string  `bson:"dns_names,omitempty"`
+	NotAfter time.Time `bson:"not_after,omitempty"`
+}
+
+// certFailure extracts certificate details from a TLS verification error.
+func certFailure(err error) (*tlsFailure, bool) {
+	var verr *tls.CertificateVerificationError
+	if !errors.As(err, &verr) {
+		return nil, false
+	}
+	tf := &tlsFailure{Reason: "invalid"}
+	var (
+		unknownAuth x509.UnknownAuthorityError
+		hostErr     x509.HostnameError
+		invalid     x509.CertificateInvalidError
+	)
+	switch {
+	case errors.As(err, &unknownAuth):
+		tf.Reason = "unknown_authority"
+	case errors.As(err, &hostErr):
+		tf.Reason = "hostname"
+	case errors.As(err, &invalid) && invalid.Reason == x509.Expired:
+		tf.Reason = "expired"
+	}
+	if len(verr.UnverifiedCertificates) > 0 {
+		leaf := verr.UnverifiedCertificates[0]
+		tf.Subject = leaf.Subject.String()
+		tf.Issuer = leaf.Issuer.String()
+		tf.DNSNames = leaf.DNSNames
+		tf.NotAfter = leaf.NotAfter
+	}
+	return tf, true
+}
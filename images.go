@@ -0,0 +1,102 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Images -----
+
+const (
+	MaxImages  = 50
+	MinImagePx = 50 // declared width or height below this is an icon or a tracking pixel
+)
+
+// ImageInfo is one content image on a page, for image search and result
+// thumbnails. Width and Height are the declared attributes, 0 if absent.
+type ImageInfo struct {
+	URL     string `bson:"url"`
+	Alt     string `bson:"alt,omitempty"`
+	Title   string `bson:"title,omitempty"`
+	Caption string `bson:"caption,omitempty"` // the enclosing <figure>'s <figcaption>
+	Width   int    `bson:"width,omitempty"`
+	Height  int    `bson:"height,omitempty"`
+}
+
+// lazySrcAttrs are where lazy-loading scripts keep the real source.
+var lazySrcAttrs = []string{"src", "data-src", "data-lazy-src", "data-original"}
+
+// newImage reads an <img> through attr. Inline data: images, tiny ones
+// and spacers are not significant.
+func newImage(attr func(string) string, base *url.URL) (ImageInfo, bool) {
+	src := ""
+	for _, a := range lazySrcAttrs {
+		if s := strings.TrimSpace(attr(a)); s != "" && !strings.HasPrefix(s, "data:") {
+			src = s
+			break
+		}
+	}
+	if src == "" || strings.Contains(src, "spacer") || strings.Contains(src, "pixel") {
+		return ImageInfo{}, false
+	}
+	img := ImageInfo{
+		URL:    resolveRef(base, src),
+		Alt:    cleanText(attr("alt")),
+		Title:  cleanText(attr("title")),
+		Width:  pixels(attr("width")),
+		Height: pixels(attr("height")),
+	}
+	if (img.Width > 0 && img.Width < MinImagePx) || (img.Height > 0 && img.Height < MinImagePx) {
+		return ImageInfo{}, false
+	}
+	img.URL, img.Alt, img.Title = safeUTF8(img.URL), safeUTF8(img.Alt), safeUTF8(img.Title)
+	return img, true
+}
+
+// pixels parses a width or height attribute such as "640" or "640px".
+func pixels(s string) int {
+	n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimSpace(s), "px"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// bestImage picks a thumbnail when the page names none: the largest image
+// by declared size, else the first that isn't a logo.
+func bestImage(images []ImageInfo) string {
+	best, area := "", 0
+	for _, img := range images {
+		if a := img.Width * img.Height; a > area {
+			best, area = img.URL, a
+		}
+	}
+	if best != "" {
+		return best
+	}
+	for _, img := range images {
+		if !strings.Contains(img.URL, "logo") {
+			return img.URL
+		}
+	}
+	return ""
+}
+
+func extractImages(doc *goquery.Document, base *url.URL) []ImageInfo {
+	var images []ImageInfo
+	doc.Find("body img").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		img, ok := newImage(func(a string) string { return s.AttrOr(a, "") }, base)
+		if !ok {
+			return true
+		}
+		if fig := s.Closest("figure"); fig.Length() > 0 {
+			img.Caption = safeUTF8(visibleText(fig.Find("figcaption").First()))
+		}
+		images = append(images, img)
+		return len(images) < MaxImages
+	})
+	return images
+}
@@ -0,0 +1,57 @@
+package index
+
+import "math"
+
+// BM25 holds the Okapi BM25 parameters. K1 is how quickly repeats of a
+// term stop adding to the score; B is how much a long document is
+// penalized against the average, from 0 (not at all) to 1, and FieldB
+// overrides it for some fields. Norm says what length is penalized.
+type BM25 struct {
+	K1     float64
+	B      float64
+	FieldB map[string]float64
+	Norm   string
+}
+
+// Length normalizations. NormField, the default, compares a field's
+// length to that field's average, so a short title weighs as much as a
+// short body on a long page. NormDocument compares the document's length
+// over all fields to the average, so a short landing page outscores a
+// long article with the same matches in every field. NormNone ignores
+// length, so long pages win on repeats alone.
+const (
+	NormField    = "field"
+	NormDocument = "document"
+	NormNone     = "none"
+)
+
+// Norms lists the length normalizations.
+var Norms = []string{NormField, NormDocument, NormNone}
+
+// b is the length penalty of field.
+func (p BM25) b(field string) float64 {
+	if b, ok := p.FieldB[field]; ok {
+		return b
+	}
+	return p.B
+}
+
+// DefaultBM25 is the usual choice of parameters, fine for web pages.
+var DefaultBM25 = BM25{K1: 1.2, B: 0.75}
+
+// idf weighs a term found in df of n documents; rarer terms weigh more.
+// The +1 keeps it positive for terms in most documents.
+func (BM25) idf(n, df int) float64 {
+	return math.Log(1 + (float64(n)-float64(df)+0.5)/(float64(df)+0.5))
+}
+
+// tf is the saturated, length-normalized weight of freq occurrences in
+// length terms, where an average avg terms are penalized by b.
+func (p BM25) tf(freq, length int, avg, b float64) float64 {
+	f := float64(freq)
+	norm := 1.0
+	if avg > 0 {
+		norm = 1 - b + b*float64(length)/avg
+	}
+	return f * (p.K1 + 1) / (f + p.K1*norm)
+}
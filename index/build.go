@@ -0,0 +1,174 @@
+package index
+
+import (
+	"errors"
+	"hash/fnv"
+	"slices"
+	"sync"
+)
+
+// builderQueue is how many documents wait for each Builder worker.
+const builderQueue = 64
+
+// Builder indexes documents for an index on several goroutines, for bulk
+// loads such as a rebuild, where analysis keeps one core busy. Documents
+// are shared out by URL, so every version of a page goes to the same
+// worker, and each worker fills a buffer of its own and writes it to a
+// segment when full. The buffers together hold at most the index's
+// MaxBuffered documents. Close adds the segments to the index; Compact
+// then merges them. The index mustn't be used until Close returns.
+type Builder struct {
+	ix      *Index
+	workers []*builderWorker
+	wg      sync.WaitGroup
+	max     int // documents per worker buffer
+
+	mu  sync.Mutex // guards names and err
+	err error
+}
+
+// builderWorker indexes its share of the documents into a private,
+// directory-less index and keeps the segments it writes.
+type builderWorker struct {
+	ix   *Index
+	docs chan Document
+	segs []*segRef
+}
+
+// Build starts a Builder with the given number of workers. The index
+// must have a directory; its buffer is flushed first, so the documents
+// built come after those already indexed.
+func (ix *Index) Build(workers int) (*Builder, error) {
+	if ix.dir == "" {
+		return nil, errors.New("index: build: index has no directory; Save it first")
+	}
+	if err := ix.Flush(); err != nil {
+		return nil, err
+	}
+	workers = max(workers, 1)
+	b := &Builder{ix: ix, max: max(ix.MaxBuffered/workers, 1)}
+	for range workers {
+		wix, err := New(ix.config)
+		if err != nil {
+			return nil, err
+		}
+		w := &builderWorker{ix: wix, docs: make(chan Document, builderQueue)}
+		b.workers = append(b.workers, w)
+		b.wg.Add(1)
+		go b.run(w)
+	}
+	return b, nil
+}
+
+// Add queues d for indexing. It returns the first error a worker ran
+// into, after which the remaining documents are dropped.
+func (b *Builder) Add(d Document) error {
+	if err := b.failed(); err != nil {
+		return err
+	}
+	h := fnv.New32a()
+	h.Write([]byte(d.URL))
+	b.workers[h.Sum32()%uint32(len(b.workers))].docs <- d
+	return nil
+}
+
+func (b *Builder) failed() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.err
+}
+
+func (b *Builder) fail(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.err == nil {
+		b.err = err
+	}
+}
+
+func (b *Builder) run(w *builderWorker) {
+	defer b.wg.Done()
+	for d := range w.docs {
+		if b.failed() != nil {
+			continue
+		}
+		w.ix.Add(d)
+		if _, buf := w.ix.buffer(); buf.numDocs() >= b.max {
+			b.flush(w)
+		}
+	}
+	b.flush(w)
+}
+
+// flush writes the worker's buffer to a segment in the index directory
+// and starts a new buffer.
+func (b *Builder) flush(w *builderWorker) {
+	r, buf := w.ix.buffer()
+	if b.failed() != nil || buf.numDocs() == len(r.deleted) {
+		return
+	}
+	b.mu.Lock()
+	name := b.ix.newSegmentName()
+	b.mu.Unlock()
+	seg, _, _, err := writeSegmentFile(b.ix.dir, name, []*segRef{r}, b.ix.config.Compress)
+	if err != nil {
+		b.fail(err)
+		return
+	}
+	w.segs = append(w.segs, &segRef{seg: seg, name: name, deleted: make(map[uint32]bool)})
+	w.ix.segs[len(w.ix.segs)-1] = &segRef{seg: newMemSegment(), deleted: make(map[uint32]bool)}
+}
+
+// Close waits for the queued documents to be indexed and adds the
+// workers' segments to the index, before its buffer. A document whose URL
+// was indexed before, by an earlier segment of the same worker or in the
+// index already, replaces the older copy, as with Add. The segments are
+// part of the saved index from the next Save.
+func (b *Builder) Close() error {
+	for _, w := range b.workers {
+		close(w.docs)
+	}
+	b.wg.Wait()
+	ix := b.ix
+	if b.err != nil {
+		for _, w := range b.workers {
+			for _, r := range w.segs {
+				r.seg.close()
+			}
+		}
+		return b.err
+	}
+	old := ix.segs[:len(ix.segs)-1]
+	buf := ix.segs[len(ix.segs)-1]
+	var added []*segRef
+	for _, w := range b.workers {
+		added = append(added, w.segs...)
+		ix.live += w.ix.live
+		for lang, n := range w.ix.langs {
+			ix.langs[lang] += n
+		}
+		for f, n := range w.ix.fieldLen {
+			ix.fieldLen[f] += n
+		}
+	}
+	ix.segs = append(slices.Concat(old, added), buf)
+	var base DocID
+	for _, r := range ix.segs {
+		r.base = base
+		base += DocID(r.seg.numDocs())
+	}
+	for _, w := range b.workers {
+		for i, r := range w.segs {
+			earlier := append(slices.Clone(old), w.segs[:i]...)
+			for n := range uint32(r.seg.numDocs()) {
+				url := r.seg.url(n)
+				for _, e := range earlier {
+					if m, ok := e.seg.lookup(url); ok {
+						ix.tombstone(e, m)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package index
+
+import (
+	"iter"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// A term dictionary lists a field's terms in order, so the terms with a
+// prefix are a range found by binary search: prefix queries, wildcards
+// and suggestions read that range rather than every term. Disk segments
+// store their term tables sorted; the buffer keeps a termDict.
+
+// dictTail is how many new terms termDict collects before sorting them
+// into the rest.
+const dictTail = 4096
+
+// termDict is the buffer's term dictionary for one field: a sorted run,
+// and the terms added since it was last merged, so adding a term doesn't
+// move the whole dictionary.
+type termDict struct {
+	sorted []string
+	tail   []string
+}
+
+func (d *termDict) add(term string) {
+	d.tail = append(d.tail, term)
+	if len(d.tail) >= dictTail {
+		slices.Sort(d.tail)
+		d.sorted = mergeSorted(d.sorted, d.tail)
+		d.tail = nil
+	}
+}
+
+// prefix yields the terms starting with prefix, in order.
+func (d *termDict) prefix(prefix string) iter.Seq[string] {
+	var recent []string
+	for _, t := range d.tail {
+		if strings.HasPrefix(t, prefix) {
+			recent = append(recent, t)
+		}
+	}
+	slices.Sort(recent)
+	return func(yield func(string) bool) {
+		i, _ := slices.BinarySearch(d.sorted, prefix)
+		for _, t := range recent {
+			for ; i < len(d.sorted) && d.sorted[i] < t && strings.HasPrefix(d.sorted[i], prefix); i++ {
+				if !yield(d.sorted[i]) {
+					return
+				}
+			}
+			if !yield(t) {
+				return
+			}
+		}
+		for ; i < len(d.sorted) && strings.HasPrefix(d.sorted[i], prefix); i++ {
+			if !yield(d.sorted[i]) {
+				return
+			}
+		}
+	}
+}
+
+// mergeSorted merges two sorted lists of distinct terms.
+func mergeSorted(a, b []string) []string {
+	out := make([]string, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		if a[0] < b[0] {
+			out, a = append(out, a[0]), a[1:]
+		} else {
+			out, b = append(out, b[0]), b[1:]
+		}
+	}
+	return append(append(out, a...), b...)
+}
+
+// Prefix yields the distinct terms of field that start with prefix, in
+// order. Like Terms, it counts terms left only in deleted documents until
+// their segments are rewritten.
+func (ix *Index) Prefix(field, prefix string) iter.Seq[string] {
+	return mergedTerms(ix.segs, field, prefix)
+}
+
+// MaxExpansions caps the terms a wildcard query word expands to.
+const MaxExpansions = 50
+
+// MinWildcardPrefix is how many characters a wildcard pattern needs before
+// its first wildcard. Patterns starting with one would have to be matched
+// against every term.
+const MinWildcardPrefix = 2
+
+// Expand lists up to limit terms of field matching pattern, in order: "*"
+// matches any run of characters and "?" any one. It reads only the terms
+// starting with the pattern's text before the first wildcard.
+func (ix *Index) Expand(field, pattern string, limit int) []string {
+	lit := pattern
+	if i := strings.IndexAny(pattern, "*?"); i >= 0 {
+		lit = pattern[:i]
+	}
+	var terms []string
+	for t := range ix.Prefix(field, lit) {
+		if len(terms) == limit {
+			break
+		}
+		if wildcard(pattern, t) {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// isWildcard reports whether a query word is a pattern Expand takes.
+func isWildcard(word string) bool {
+	i := strings.IndexAny(word, "*?")
+	return i >= 0 && utf8.RuneCountInString(word[:i]) >= MinWildcardPrefix
+}
+
+// wildcard matches s against pattern, "*" and "?" being wildcards.
+func wildcard(pattern, s string) bool {
+	// On a mismatch, retry from the last "*", letting it take one more
+	// character of s.
+	star, retry := -1, 0
+	for p, i := 0, 0; i < len(s) || p < len(pattern); {
+		if p < len(pattern) {
+			switch pattern[p] {
+			case '*':
+				star, retry = p, i
+				p++
+				continue
+			case '?':
+				if i < len(s) {
+					_, n := utf8.DecodeRuneInString(s[i:])
+					p, i = p+1, i+n
+					continue
+				}
+			default:
+				if i < len(s) && pattern[p] == s[i] {
+					p, i = p+1, i+1
+					continue
+				}
+			}
+		}
+		if star < 0 || retry >= len(s) {
+			return false
+		}
+		_, n := utf8.DecodeRuneInString(s[retry:])
+		retry += n
+		p, i = star+1, retry
+	}
+	return true
+}
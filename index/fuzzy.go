@@ -0,0 +1,204 @@
+package index
+
+import (
+	"cmp"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Each segment keeps a trigram index over its vocabulary, the distinct
+// terms of all its fields: for each three-character gram of a term padded
+// with "^" and "$", the terms containing it. A term within k edits of a
+// word of n characters shares at least n-4k of the word's grams, so
+// candidates are counted out of a few gram lists and only they are
+// compared character by character.
+
+// FuzzyMinLength is the shortest query word Similar corrects; shorter words
+// are a typo away from too many others. Words of FuzzyTwoEdits characters
+// or more may be two edits off, shorter ones one.
+const (
+	FuzzyMinLength = 4
+	FuzzyTwoEdits  = 8
+)
+
+// MaxFuzzy caps the terms a misspelt query word expands to.
+const MaxFuzzy = 10
+
+// grams lists the distinct padded trigrams of term.
+func grams(term string) []string {
+	r := []rune("^" + term + "$")
+	var gs []string
+	for i := 0; i+3 <= len(r); i++ {
+		if g := string(r[i : i+3]); !slices.Contains(gs, g) {
+			gs = append(gs, g)
+		}
+	}
+	return gs
+}
+
+// maxEdits is how many edits a word may be off by.
+func maxEdits(word string) int {
+	switch n := utf8.RuneCountInString(word); {
+	case n < FuzzyMinLength:
+		return 0
+	case n < FuzzyTwoEdits:
+		return 1
+	}
+	return 2
+}
+
+// editDistance counts the characters to insert, delete or replace, and
+// adjacent pairs to swap, to turn a into b; "golnag" is one edit from
+// "golang".
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2, prev, row := make([]int, len(rb)+1), make([]int, len(rb)+1), make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				row[j] = min(row[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, row = prev, row, prev2
+	}
+	return prev[len(rb)]
+}
+
+// fuzzyTerms lists the terms of s within k edits of word, other than word.
+func fuzzyTerms(s segment, word string, k int) map[string]int {
+	gs := grams(word)
+	counts := make(map[uint32]int)
+	for _, g := range gs {
+		for _, t := range s.gramList(g) {
+			counts[t]++
+		}
+	}
+	n := utf8.RuneCountInString(word)
+	found := make(map[string]int)
+	for t, c := range counts {
+		if c < n-4*k {
+			continue
+		}
+		term := s.vocab(t)
+		if abs(utf8.RuneCountInString(term)-n) > k || term == word {
+			continue
+		}
+		if d := editDistance(word, term); d <= k {
+			found[term] = d
+		}
+	}
+	return found
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// Similar lists up to MaxFuzzy indexed terms a typo or two away from
+// word, closest first: one edit for words of FuzzyMinLength characters or
+// more, two from FuzzyTwoEdits. The terms may be of any field.
+func (ix *Index) Similar(word string) []string {
+	k := maxEdits(word)
+	if k == 0 {
+		return nil
+	}
+	dist := make(map[string]int)
+	for _, r := range ix.segs {
+		maps.Copy(dist, fuzzyTerms(r.seg, word, k))
+	}
+	terms := slices.Sorted(maps.Keys(dist))
+	slices.SortStableFunc(terms, func(a, b string) int { return cmp.Compare(dist[a], dist[b]) })
+	return terms[:min(len(terms), MaxFuzzy)]
+}
+
+// correct expands each required query word that matches nothing to the
+// terms Similar finds for it, field by field.
+func (ix *Index) correct(words []clause) {
+	for _, c := range words {
+		if c.stop || ix.matchesAny(c) {
+			continue
+		}
+		for f, terms := range c.terms {
+			var fixed []string
+			for _, t := range terms {
+				fixed = append(fixed, ix.Similar(t)...)
+			}
+			c.terms[f] = append(terms, fixed...)
+		}
+	}
+}
+
+// matchesAny reports whether some document has one of c's terms.
+func (ix *Index) matchesAny(c clause) bool {
+	for f, terms := range c.terms {
+		for _, t := range terms {
+			if len(ix.Postings(f, t)) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Suggest completes text as typed into a search box to the titles of up
+// to limit documents, those with the most inlinks first: their titles
+// have every word of text, the last as the lowercased prefix of a term,
+// which the prefix of a stemmed word still is.
+func (ix *Index) Suggest(text string, limit int) []string {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) == 0 {
+		return nil
+	}
+	last := words[len(words)-1]
+	docs := ix.anyPostings(FieldTitle, ix.Expand(FieldTitle, last+"*", MaxExpansions))
+	a := ix.analyzers[FieldTitle][ix.analyzerLang("")]
+	for _, w := range words[:len(words)-1] {
+		for _, term := range a.Terms(w) {
+			docs = intersect(docs, ix.Postings(FieldTitle, term))
+		}
+	}
+	ids := make([]DocID, len(docs))
+	for i, p := range docs {
+		ids[i] = p.Doc
+	}
+	inlinks := func(id DocID) int64 {
+		r, n := ix.locate(id)
+		return r.seg.value(n, NumInlinks)
+	}
+	slices.SortStableFunc(ids, func(a, b DocID) int { return cmp.Compare(inlinks(b), inlinks(a)) })
+	var titles []string
+	for _, id := range ids {
+		if len(titles) == limit {
+			break
+		}
+		if t := ix.Doc(id).Title; t != "" && !slices.Contains(titles, t) {
+			titles = append(titles, t)
+		}
+	}
+	return titles
+}
+
+// intersect keeps the postings of a whose documents are in b.
+func intersect(a, b []Posting) []Posting {
+	var out []Posting
+	for _, p := range a {
+		if _, ok := find(b, p.Doc); ok {
+			out = append(out, p)
+		}
+	}
+	return out
+}
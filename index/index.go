@@ -0,0 +1,490 @@
+// Package index is the crawler's search index: an inverted index from
+// terms to the documents that contain them, built from stored pages and
+// saved to disk.
+package index
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/realutkarshh/mini-search-crawler/analysis"
+)
+
+// DocID numbers documents in the order they were added.
+type DocID uint32
+
+// Document is one page as handed to the index. Anchors is the anchor
+// text of links to the page from other pages, which often names it better
+// than it names itself. Lang is the page's ISO 639-1 language, "" if
+// unknown; it picks the stemmer and stopwords its text is analyzed with.
+// Crawled, Published, Words and Inlinks are kept as numeric fields to
+// filter and sort by; zero means unknown. Favicon and Image are stored to
+// render results with, not indexed.
+type Document struct {
+	URL      string
+	Title    string
+	Headings []string
+	Snippet  string
+	Anchors  []string
+	Body     string
+	Lang     string
+	Favicon  string
+	Image    string
+
+	Crawled   time.Time
+	Published time.Time
+	Words     int
+	Inlinks   int
+}
+
+// The fields of a Document, indexed separately so each can be analyzed
+// its own way and weighted by Index.Boosts.
+const (
+	FieldTitle    = "title"
+	FieldHeadings = "headings"
+	FieldSnippet  = "snippet"
+	FieldAnchors  = "anchors"
+	FieldBody     = "body"
+)
+
+// Fields lists the indexed fields.
+var Fields = []string{FieldTitle, FieldHeadings, FieldSnippet, FieldAnchors, FieldBody}
+
+// The numeric fields of a Document. Times are Unix seconds.
+const (
+	NumCrawled   = "crawled"
+	NumPublished = "published"
+	NumWords     = "words"
+	NumInlinks   = "inlinks"
+)
+
+// Numeric lists the numeric fields.
+var Numeric = []string{NumCrawled, NumPublished, NumWords, NumInlinks}
+
+// DefaultBoosts weighs a match in the title above one in a heading or a
+// link to the page, and those above a match somewhere in the body.
+var DefaultBoosts = map[string]float64{
+	FieldTitle:    3,
+	FieldHeadings: 2,
+	FieldSnippet:  1.5,
+	FieldAnchors:  2,
+	FieldBody:     1,
+}
+
+// PositionGap separates the values of a many-valued field, so a phrase
+// can't run from one heading into the next.
+const PositionGap = 100
+
+func (d Document) field(name string) []string {
+	switch name {
+	case FieldTitle:
+		return []string{d.Title}
+	case FieldHeadings:
+		return d.Headings
+	case FieldSnippet:
+		return []string{d.Snippet}
+	case FieldAnchors:
+		return d.Anchors
+	case FieldBody:
+		return []string{d.Body}
+	}
+	return nil
+}
+
+func (d Document) values() map[string]int64 {
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.Unix()
+	}
+	return map[string]int64{
+		NumCrawled:   unix(d.Crawled),
+		NumPublished: unix(d.Published),
+		NumWords:     int64(d.Words),
+		NumInlinks:   int64(d.Inlinks),
+	}
+}
+
+// DocInfo is what the index keeps about a document to show it in results:
+// its stored fields, never the indexed text, which is only read as terms.
+// Lengths counts its indexed terms per field, for length normalization;
+// Values holds its numeric fields.
+type DocInfo struct {
+	URL     string
+	Title   string
+	Snippet string
+	Favicon string
+	Image   string
+	Lang    string
+	Lengths map[string]int
+	Values  map[string]int64
+}
+
+// Posting records where a term occurs in Doc: the word positions, in
+// increasing order.
+type Posting struct {
+	Doc       DocID
+	Positions []uint32
+}
+
+// Freq is the number of times the term occurs.
+func (p Posting) Freq() int {
+	return len(p.Positions)
+}
+
+// Config picks the analyzers, as analysis.Parse specs: Analyzer for every
+// field that Fields doesn't give one of its own. Each spec is built once
+// per language analysis has a stemmer or stopwords for, so "stem" and
+// "stop" follow the document; Language is assumed for documents of
+// unknown language (default analysis.DefaultLang). Documents in other
+// languages get neither. Compress has segments written with their larger
+// posting lists S2-compressed. Shingles indexes the word pairs of
+// ShingleFields, for Search to favour documents with query words side by
+// side there.
+type Config struct {
+	Analyzer string            `json:"analyzer"`
+	Fields   map[string]string `json:"fields,omitempty"`
+	Language string            `json:"language,omitempty"`
+	Compress bool              `json:"compress,omitempty"`
+	Shingles bool              `json:"shingles,omitempty"`
+}
+
+// Index is an inverted index over a list of segments: immutable segment
+// files on disk, memory-mapped, and an in-memory buffer of documents added
+// since the last flush. Each field's terms map to posting lists ordered by
+// DocID. An Index is not safe for concurrent writes; reads may run
+// concurrently with each other.
+//
+// BM25 and Boosts set how Search ranks; they aren't saved, so they can be
+// tuned without reindexing. Fields missing from Boosts weigh nothing.
+// Fuzzy, on by default, lets a query word that matches nothing match the
+// terms a typo away from it instead; see Similar. Proximity, also on by
+// default, adds the bonus for query words close together, which reads the
+// positions of every match. Once the index has a directory, Add flushes
+// the buffer to a new segment every MaxBuffered documents, bounding the
+// memory building takes, and MergePolicy says when segments are merged;
+// see Compact.
+type Index struct {
+	BM25        BM25
+	Boosts      map[string]float64
+	Fuzzy       bool
+	Proximity   bool
+	MaxBuffered int
+	MergePolicy MergePolicy
+
+	config Config
+	// field -> analyzer language -> analyzer
+	analyzers map[string]map[string]*analysis.Analyzer
+
+	dir      string         // "" until saved or opened
+	segs     []*segRef      // oldest first; the last is the buffer
+	live     int            // documents not deleted
+	fieldLen map[string]int // field -> terms over live docs
+	langs    map[string]int // analyzer language -> live docs
+	nextSeg  uint64         // number of the next segment file
+	merging  string         // file of a Merge planned and not yet committed
+}
+
+// DefaultMaxBuffered is how many documents the buffer holds before Add
+// flushes it.
+const DefaultMaxBuffered = 10000
+
+// segRef is a segment as part of the index: where its documents start in
+// DocID order, and which of them have been deleted since it was written.
+type segRef struct {
+	seg     segment
+	name    string // file name in the index directory; "" for the buffer
+	base    DocID
+	deleted map[uint32]bool
+	dirty   bool // deletions not yet saved as tombstones
+}
+
+// New returns an empty index in memory; Save gives it a directory. The
+// config is saved with the index, so queries against it are analyzed the
+// same way after it is reopened.
+func New(cfg Config) (*Index, error) {
+	ix := &Index{
+		BM25:        DefaultBM25,
+		Boosts:      DefaultBoosts,
+		Fuzzy:       true,
+		Proximity:   true,
+		MaxBuffered: DefaultMaxBuffered,
+		MergePolicy: DefaultMergePolicy,
+		config:      cfg,
+		analyzers:   make(map[string]map[string]*analysis.Analyzer),
+		fieldLen:    make(map[string]int),
+		langs:       make(map[string]int),
+	}
+	for name := range cfg.Fields {
+		if !slices.Contains(Fields, name) {
+			return nil, fmt.Errorf("index: unknown field %q", name)
+		}
+	}
+	for _, f := range Fields {
+		spec, ok := cfg.Fields[f]
+		if !ok {
+			spec = cfg.Analyzer
+		}
+		ix.analyzers[f] = make(map[string]*analysis.Analyzer)
+		for _, lang := range append(analysis.Languages(), "") {
+			a, err := analysis.ParseLang(spec, lang)
+			if err != nil {
+				return nil, fmt.Errorf("index: %s: %w", f, err)
+			}
+			ix.analyzers[f][lang] = a
+		}
+	}
+	ix.segs = []*segRef{{seg: newMemSegment(), deleted: make(map[uint32]bool)}}
+	return ix, nil
+}
+
+// buffer is the in-memory segment new documents go to.
+func (ix *Index) buffer() (*segRef, *memSegment) {
+	r := ix.segs[len(ix.segs)-1]
+	return r, r.seg.(*memSegment)
+}
+
+// Add indexes d and returns its DocID. A document already indexed under
+// the same URL is deleted first, so a recrawled page replaces its old
+// version; the new one gets a new DocID.
+func (ix *Index) Add(d Document) (DocID, error) {
+	ix.Delete(d.URL)
+	info := DocInfo{URL: d.URL, Title: d.Title, Snippet: d.Snippet, Favicon: d.Favicon, Image: d.Image, Lang: d.Lang, Lengths: make(map[string]int), Values: d.values()}
+	lang := ix.analyzerLang(d.Lang)
+	positions := make(map[string]map[string][]uint32)
+	for _, f := range Fields {
+		terms := make(map[string][]uint32)
+		var pairs map[string][]uint32
+		if ix.config.Shingles && slices.Contains(ShingleFields, f) {
+			pairs = make(map[string][]uint32)
+			positions[ShingleField(f)] = pairs
+		}
+		length, offset := 0, 0
+		for _, value := range d.field(f) {
+			tokens := ix.analyzers[f][lang].Analyze(value)
+			if pairs != nil {
+				for _, s := range shingles(tokens) {
+					pos := uint32(offset + s.Position)
+					if p := pairs[s.Term]; len(p) == 0 || p[len(p)-1] != pos {
+						pairs[s.Term] = append(p, pos)
+					}
+				}
+			}
+			for i, t := range tokens {
+				pos := uint32(offset + t.Position)
+				if p := terms[t.Term]; len(p) == 0 || p[len(p)-1] != pos {
+					terms[t.Term] = append(p, pos)
+				}
+				// Synonyms share a position and count as one term.
+				if i == 0 || t.Position != tokens[i-1].Position {
+					length++
+				}
+			}
+			if len(tokens) > 0 {
+				offset += tokens[len(tokens)-1].Position + 1 + PositionGap
+			}
+		}
+		positions[f] = terms
+		info.Lengths[f] = length
+		ix.fieldLen[f] += length
+	}
+	r, buf := ix.buffer()
+	id := r.base + DocID(buf.add(info, positions))
+	ix.live++
+	ix.langs[lang]++
+	if ix.dir != "" && ix.MaxBuffered > 0 && buf.numDocs() >= ix.MaxBuffered {
+		if err := ix.Flush(); err != nil {
+			return id, err
+		}
+	}
+	return id, nil
+}
+
+// Delete removes the document indexed under url, and reports whether there
+// was one. The document is tombstoned: its postings stay in its segment,
+// skipped by queries, until a merge rewrites the segment.
+func (ix *Index) Delete(url string) bool {
+	for _, r := range slices.Backward(ix.segs) {
+		if n, ok := r.seg.lookup(url); ok && ix.tombstone(r, n) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeleteDoc is Delete by DocID.
+func (ix *Index) DeleteDoc(id DocID) bool {
+	if int(id) >= ix.numDocs() {
+		return false
+	}
+	r, n := ix.locate(id)
+	return ix.tombstone(r, n)
+}
+
+// DeleteFunc deletes every document whose URL del reports true for, as
+// when a whole site is taken out of search, and returns how many it
+// deleted. It looks at every document.
+func (ix *Index) DeleteFunc(del func(url string) bool) int {
+	deleted := 0
+	for _, r := range ix.segs {
+		for n := range uint32(r.seg.numDocs()) {
+			if !r.deleted[n] && del(r.seg.url(n)) && ix.tombstone(r, n) {
+				deleted++
+			}
+		}
+	}
+	return deleted
+}
+
+// tombstone marks document n of r deleted, unless it already is.
+func (ix *Index) tombstone(r *segRef, n uint32) bool {
+	if r.deleted[n] {
+		return false
+	}
+	r.deleted[n] = true
+	r.dirty = true
+	ix.live--
+	ix.langs[ix.analyzerLang(r.seg.lang(n))]--
+	for _, f := range Fields {
+		ix.fieldLen[f] -= r.seg.length(n, f)
+	}
+	return true
+}
+
+// numDocs counts DocIDs in use, deleted documents included.
+func (ix *Index) numDocs() int {
+	last := ix.segs[len(ix.segs)-1]
+	return int(last.base) + last.seg.numDocs()
+}
+
+// locate finds the segment holding id and id's number within it.
+func (ix *Index) locate(id DocID) (*segRef, uint32) {
+	i := sort.Search(len(ix.segs), func(i int) bool { return ix.segs[i].base > id }) - 1
+	r := ix.segs[i]
+	return r, uint32(id - r.base)
+}
+
+// find locates doc in postings, which are ordered by DocID.
+func find(postings []Posting, doc DocID) (int, bool) {
+	return slices.BinarySearchFunc(postings, doc, func(p Posting, d DocID) int {
+		return cmp.Compare(p.Doc, d)
+	})
+}
+
+// avgLen is the average length of field over all documents.
+func (ix *Index) avgLen(field string) float64 {
+	if ix.Len() == 0 {
+		return 0
+	}
+	return float64(ix.fieldLen[field]) / float64(ix.Len())
+}
+
+// length is the number of terms id has in field.
+func (ix *Index) length(id DocID, field string) int {
+	r, n := ix.locate(id)
+	return r.seg.length(n, field)
+}
+
+// tf weighs the matches of a term in field, by document and number of
+// occurrences, normalized for length as BM25.Norm says.
+func (ix *Index) tf(field string) func(id DocID, freq int) float64 {
+	b := ix.BM25.b(field)
+	switch ix.BM25.Norm {
+	case NormNone:
+		return func(_ DocID, freq int) float64 { return ix.BM25.tf(freq, 0, 0, b) }
+	case NormDocument:
+		avg := 0.0
+		for _, f := range Fields {
+			avg += ix.avgLen(f)
+		}
+		return func(id DocID, freq int) float64 {
+			length := 0
+			for _, f := range Fields {
+				length += ix.length(id, f)
+			}
+			return ix.BM25.tf(freq, length, avg, b)
+		}
+	}
+	avg := ix.avgLen(field)
+	return func(id DocID, freq int) float64 { return ix.BM25.tf(freq, ix.length(id, field), avg, b) }
+}
+
+// Postings returns term's posting list in field across all segments,
+// without deleted documents.
+func (ix *Index) Postings(field, term string) []Posting {
+	var list []Posting
+	for _, r := range ix.segs {
+		for _, p := range r.seg.postingList(field, term) {
+			if !r.deleted[uint32(p.Doc)] {
+				p.Doc += r.base
+				list = append(list, p)
+			}
+		}
+	}
+	return list
+}
+
+// Analyzer is the analyzer field is indexed with in documents in lang;
+// queries for those documents must use it too.
+func (ix *Index) Analyzer(field, lang string) *analysis.Analyzer {
+	return ix.analyzers[field][ix.analyzerLang(lang)]
+}
+
+// analyzerLang is the language whose analyzers a document in lang is
+// indexed with: lang itself if analysis has a stemmer or stopwords for
+// it, Config.Language if lang is "", and "" for the language-neutral
+// analyzers otherwise.
+func (ix *Index) analyzerLang(lang string) string {
+	if lang == "" {
+		lang = cmp.Or(ix.config.Language, analysis.DefaultLang)
+	}
+	if _, ok := ix.analyzers[FieldBody][lang]; ok {
+		return lang
+	}
+	return ""
+}
+
+// indexedLangs lists the analyzer languages of the live documents.
+func (ix *Index) indexedLangs() []string {
+	var langs []string
+	for lang, n := range ix.langs {
+		if n > 0 {
+			langs = append(langs, lang)
+		}
+	}
+	slices.Sort(langs)
+	return langs
+}
+
+// docLang is the analyzer language id was indexed with.
+func (ix *Index) docLang(id DocID) string {
+	r, n := ix.locate(id)
+	return ix.analyzerLang(r.seg.lang(n))
+}
+
+func (ix *Index) Doc(id DocID) DocInfo {
+	r, n := ix.locate(id)
+	return r.seg.doc(n)
+}
+
+// Len is the number of documents.
+func (ix *Index) Len() int {
+	return ix.live
+}
+
+// Terms is the number of distinct terms, counted per field. Terms whose
+// documents have all been deleted are counted until their segments are
+// rewritten.
+func (ix *Index) Terms() int {
+	n := 0
+	for _, f := range Fields {
+		for range mergedTerms(ix.segs, f, "") {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,99 @@
+package index
+
+import "iter"
+
+// memSegment holds the documents added since the last flush. It is the
+// one segment that changes; Flush writes it out as an immutable disk
+// segment and starts a new one.
+type memSegment struct {
+	docs     []DocInfo
+	byURL    map[string]uint32
+	postings map[string]map[string][]Posting // field -> term -> postings
+	dict     map[string]*termDict
+	vocabs   []string            // distinct terms of all fields, as added
+	vocabID  map[string]uint32   // term -> number in vocabs
+	grams    map[string][]uint32 // trigram -> terms containing it
+}
+
+func newMemSegment() *memSegment {
+	m := &memSegment{
+		byURL:    make(map[string]uint32),
+		postings: make(map[string]map[string][]Posting),
+		dict:     make(map[string]*termDict),
+		vocabID:  make(map[string]uint32),
+		grams:    make(map[string][]uint32),
+	}
+	for _, f := range postingFields {
+		m.postings[f] = make(map[string][]Posting)
+		m.dict[f] = &termDict{}
+	}
+	return m
+}
+
+// add appends a document whose terms have been analyzed into positions,
+// field -> term -> positions.
+func (m *memSegment) add(info DocInfo, positions map[string]map[string][]uint32) uint32 {
+	n := uint32(len(m.docs))
+	for f, terms := range positions {
+		postings := m.postings[f]
+		for t, pos := range terms {
+			if _, ok := postings[t]; !ok {
+				m.dict[f].add(t)
+			}
+			if _, ok := m.vocabID[t]; !ok && !isShingleField(f) {
+				id := uint32(len(m.vocabs))
+				m.vocabID[t] = id
+				m.vocabs = append(m.vocabs, t)
+				for _, g := range grams(t) {
+					m.grams[g] = append(m.grams[g], id)
+				}
+			}
+			postings[t] = append(postings[t], Posting{Doc: DocID(n), Positions: pos})
+		}
+	}
+	m.docs = append(m.docs, info)
+	m.byURL[info.URL] = n
+	return n
+}
+
+func (m *memSegment) numDocs() int { return len(m.docs) }
+
+func (m *memSegment) doc(n uint32) DocInfo { return m.docs[n] }
+
+func (m *memSegment) url(n uint32) string { return m.docs[n].URL }
+
+func (m *memSegment) lang(n uint32) string { return m.docs[n].Lang }
+
+func (m *memSegment) value(n uint32, field string) int64 { return m.docs[n].Values[field] }
+
+func (m *memSegment) vocab(i uint32) string { return m.vocabs[i] }
+
+func (m *memSegment) gramList(gram string) []uint32 { return m.grams[gram] }
+
+func (m *memSegment) length(n uint32, field string) int { return m.docs[n].Lengths[field] }
+
+func (m *memSegment) postingList(field, term string) []Posting { return m.postings[field][term] }
+
+// lookup finds the latest document added under url.
+func (m *memSegment) lookup(url string) (uint32, bool) {
+	n, ok := m.byURL[url]
+	return n, ok
+}
+
+func (m *memSegment) numTerms(field string) int { return len(m.postings[field]) }
+
+func (m *memSegment) postingCount(field string) (int, int64) {
+	n := 0
+	for _, list := range m.postings[field] {
+		n += len(list)
+	}
+	return n, 0
+}
+
+func (m *memSegment) size() int64 { return 0 }
+
+func (m *memSegment) terms(field, prefix string) iter.Seq[string] {
+	return m.dict[field].prefix(prefix)
+}
+
+func (m *memSegment) close() error { return nil }
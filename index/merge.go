@@ -0,0 +1,156 @@
+package index
+
+import (
+	"errors"
+	"maps"
+	"math"
+	"slices"
+)
+
+// MergePolicy decides which segments to merge. Every flush adds a small
+// segment, and queries read all of them, so without merging query time
+// grows with the number of flushes. Segments are grouped in tiers by
+// size, each Factor times the one below; Factor adjacent segments of one
+// tier are merged into one of the next. Segments over MaxDocs are left
+// alone, except that any segment with more than MaxDeleted of its
+// documents deleted is rewritten to drop them.
+type MergePolicy struct {
+	Factor     int
+	MaxDocs    int
+	MaxDeleted float64
+}
+
+// DefaultMergePolicy keeps at most about ten segments per tier.
+var DefaultMergePolicy = MergePolicy{Factor: 10, MaxDocs: 5_000_000, MaxDeleted: 0.3}
+
+// pick returns the adjacent disk segments to merge next, or nil.
+func (p MergePolicy) pick(segs []*segRef) []*segRef {
+	factor := max(p.Factor, 2)
+	tier := func(r *segRef) int {
+		live := max(r.seg.numDocs()-len(r.deleted), 1)
+		return int(math.Log(float64(live)) / math.Log(float64(factor)))
+	}
+	for i := 0; i+factor <= len(segs); i++ {
+		run := segs[i : i+factor]
+		docs := 0
+		same := true
+		for _, r := range run {
+			docs += r.seg.numDocs() - len(r.deleted)
+			same = same && tier(r) == tier(run[0])
+		}
+		if same && docs <= p.MaxDocs {
+			return run
+		}
+	}
+	for _, r := range segs {
+		if n := r.seg.numDocs(); n > 0 && float64(len(r.deleted))/float64(n) > p.MaxDeleted {
+			return []*segRef{r}
+		}
+	}
+	return nil
+}
+
+// Merge is a merge of adjacent segments into one, in three steps so that
+// the slow part can run without holding up the index: PlanMerge picks the
+// segments, Run writes the merged segment, and Commit swaps it in. Only
+// PlanMerge and Commit touch the Index; Run may go on alongside other
+// calls, other than Close.
+type Merge struct {
+	ix      *Index
+	name    string
+	refs    []*segRef
+	deleted []map[uint32]bool // refs' deletions as of planning
+
+	seg   *diskSegment
+	remap [][]int32
+}
+
+var errMergeStale = errors.New("index: merge: segments changed since it was planned")
+
+// PlanMerge returns the next merge MergePolicy calls for, or nil if there
+// is none or one is already under way.
+func (ix *Index) PlanMerge() *Merge {
+	if ix.dir == "" || ix.merging != "" {
+		return nil
+	}
+	refs := ix.MergePolicy.pick(ix.segs[:len(ix.segs)-1])
+	if refs == nil {
+		return nil
+	}
+	m := &Merge{ix: ix, name: ix.newSegmentName(), refs: slices.Clone(refs)}
+	for _, r := range refs {
+		m.deleted = append(m.deleted, maps.Clone(r.deleted))
+	}
+	ix.merging = m.name
+	return m
+}
+
+// Run writes the merged segment.
+func (m *Merge) Run() error {
+	snap := make([]*segRef, len(m.refs))
+	for i, r := range m.refs {
+		snap[i] = &segRef{seg: r.seg, deleted: m.deleted[i]}
+	}
+	seg, remap, _, err := writeSegmentFile(m.ix.dir, m.name, snap, m.ix.config.Compress)
+	m.seg, m.remap = seg, remap
+	return err
+}
+
+// Commit replaces the merged segments with the new one, carrying over
+// deletions made while it ran. After a failed Run it just abandons the
+// merge. The new segment is part of the saved index from the next Save.
+func (m *Merge) Commit() error {
+	ix := m.ix
+	ix.merging = ""
+	if m.seg == nil {
+		return nil
+	}
+	i := slices.Index(ix.segs, m.refs[0])
+	if i < 0 || i+len(m.refs) > len(ix.segs)-1 || !slices.Equal(ix.segs[i:i+len(m.refs)], m.refs) {
+		m.seg.close()
+		return errMergeStale
+	}
+	merged := &segRef{seg: m.seg, name: m.name, base: m.refs[0].base, deleted: make(map[uint32]bool)}
+	for j, r := range m.refs {
+		for n := range r.deleted {
+			if !m.deleted[j][n] {
+				merged.deleted[uint32(m.remap[j][n])] = true
+				merged.dirty = true
+			}
+		}
+	}
+	if merged.seg.numDocs() == 0 {
+		// Nothing survived; the empty file goes at the next Save.
+		ix.segs = slices.Delete(ix.segs, i, i+len(m.refs))
+		merged.seg.close()
+	} else {
+		ix.segs = slices.Replace(ix.segs, i, i+len(m.refs), merged)
+	}
+	base := m.refs[0].base
+	for _, r := range ix.segs[i:] {
+		r.base = base
+		base += DocID(r.seg.numDocs())
+	}
+	var errs []error
+	for _, r := range m.refs {
+		errs = append(errs, r.seg.close())
+	}
+	return errors.Join(errs...)
+}
+
+// Compact runs merges until MergePolicy calls for no more.
+func (ix *Index) Compact() error {
+	for {
+		m := ix.PlanMerge()
+		if m == nil {
+			return nil
+		}
+		err := m.Run()
+		if cerr := m.Commit(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package index
+
+import (
+	"slices"
+
+	"github.com/realutkarshh/mini-search-crawler/analysis"
+)
+
+// ProximityWeight scales the bonus for query words found near each other:
+// adjacent words add ProximityWeight, words n apart ProximityWeight/n.
+const ProximityWeight = 0.5
+
+// posting returns doc's posting in postings.
+func posting(postings []Posting, doc DocID) (Posting, bool) {
+	i, ok := find(postings, doc)
+	if !ok {
+		return Posting{}, false
+	}
+	return postings[i], true
+}
+
+// anyPostings merges the posting lists of terms in field, for a word
+// that matches as any of its synonyms.
+func (ix *Index) anyPostings(field string, terms []string) []Posting {
+	var list []Posting
+	for _, term := range terms {
+		list = union(list, ix.Postings(field, term))
+	}
+	return list
+}
+
+// union merges two posting lists, and the positions of documents in both.
+func union(a, b []Posting) []Posting {
+	if len(a) == 0 {
+		return b
+	}
+	list := make([]Posting, 0, len(a)+len(b))
+	for len(a) > 0 && len(b) > 0 {
+		switch {
+		case a[0].Doc < b[0].Doc:
+			list, a = append(list, a[0]), a[1:]
+		case a[0].Doc > b[0].Doc:
+			list, b = append(list, b[0]), b[1:]
+		default:
+			pos := slices.Concat(a[0].Positions, b[0].Positions)
+			slices.Sort(pos)
+			pos = slices.Compact(pos)
+			list = append(list, Posting{Doc: a[0].Doc, Positions: pos})
+			a, b = a[1:], b[1:]
+		}
+	}
+	return append(append(list, a...), b...)
+}
+
+// phraseMatches maps each document containing tokens as a phrase in field
+// to the number of times it does.
+func (ix *Index) phraseMatches(field string, tokens []analysis.Token) map[DocID]int {
+	// Tokens sharing a position are synonyms; each position is one word.
+	var offsets []int
+	var words [][]string
+	for _, t := range tokens {
+		if len(offsets) == 0 || t.Position != offsets[len(offsets)-1] {
+			offsets = append(offsets, t.Position)
+			words = append(words, nil)
+		}
+		words[len(words)-1] = append(words[len(words)-1], t.Term)
+	}
+	lists := make([][]Posting, len(words))
+	for i, terms := range words {
+		lists[i] = ix.anyPostings(field, terms)
+		if len(lists[i]) == 0 {
+			return nil
+		}
+	}
+	matches := make(map[DocID]int)
+	for _, first := range lists[0] {
+		found := [][]uint32{first.Positions}
+		for _, list := range lists[1:] {
+			p, ok := posting(list, first.Doc)
+			if !ok {
+				break
+			}
+			found = append(found, p.Positions)
+		}
+		if len(found) < len(lists) {
+			continue
+		}
+		n := 0
+	next:
+		for _, start := range first.Positions {
+			for i := 1; i < len(lists); i++ {
+				want := start + uint32(offsets[i]-offsets[0])
+				if _, ok := slices.BinarySearch(found[i], want); !ok {
+					continue next
+				}
+			}
+			n++
+		}
+		if n > 0 {
+			matches[first.Doc] = n
+		}
+	}
+	return matches
+}
+
+// distance is the least gap between a position in a and one in b, both
+// in increasing order.
+func distance(a, b []uint32) int {
+	best := -1
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		d := int(a[i]) - int(b[j])
+		if d < 0 {
+			d = -d
+			i++
+		} else {
+			j++
+		}
+		if best < 0 || d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+// proximity is the bonus for doc having consecutive query words close
+// together, in whichever field has them closest.
+func (ix *Index) proximity(doc DocID, words []clause) float64 {
+	bonus := 0.0
+	for i := 1; i < len(words); i++ {
+		best := -1
+		for _, f := range Fields {
+			a, ok1 := words[i-1].terms[f]
+			b, ok2 := words[i].terms[f]
+			if !ok1 || !ok2 {
+				continue
+			}
+			pa, ok1 := posting(ix.anyPostings(f, a), doc)
+			pb, ok2 := posting(ix.anyPostings(f, b), doc)
+			if !ok1 || !ok2 {
+				continue
+			}
+			if d := distance(pa.Positions, pb.Positions); d > 0 && (best < 0 || d < best) {
+				best = d
+			}
+		}
+		if best > 0 {
+			bonus += ProximityWeight / float64(best)
+		}
+	}
+	return bonus
+}
@@ -0,0 +1,125 @@
+package index
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/realutkarshh/mini-search-crawler/analysis"
+)
+
+// query is a parsed search: the loose words, and the quoted phrases that
+// must match word for word.
+type query struct {
+	words   []clause
+	phrases []phrase
+}
+
+// clause is one query word as each field's analyzer sees it: usually one
+// term per field, more with synonyms, any of which matches. Words a
+// field's analyzer drops have no terms in that field. A word that every
+// analyzer keeping it marks as a stopword is optional: it adds to the
+// score but needn't match.
+type clause struct {
+	terms map[string][]string // field -> terms
+	stop  bool
+}
+
+// phrase is a quoted phrase as each field's analyzer sees it. Positions
+// are relative to the phrase, keeping the gaps of dropped words, so
+// "state of the art" also matches "state-of-the-art" once stopwords are
+// gone from both. Synonyms share a position; any of them matches there.
+type phrase map[string][]analysis.Token // field -> tokens
+
+// parseQuery splits out the quoted phrases and analyzes the rest once per
+// field with its analyzer for lang, lining terms up by word position so a
+// word counts as matched in whichever field it is found. An unclosed
+// quote runs to the end. Loose words with wildcards ("crawl*", "colo?r")
+// aren't analyzed but lowercased and expanded to the terms they match, up
+// to MaxExpansions per field.
+func (ix *Index) parseQuery(text, lang string) query {
+	var q query
+	var loose strings.Builder
+	var patterns []string
+	for i := 0; text != ""; i++ {
+		part, rest, _ := strings.Cut(text, `"`)
+		if i%2 == 0 {
+			for _, w := range strings.Fields(part) {
+				if w = strings.TrimFunc(w, isPunct); isWildcard(w) {
+					patterns = append(patterns, strings.ToLower(w))
+					continue
+				}
+				loose.WriteString(w)
+				loose.WriteByte(' ')
+			}
+		} else if p := ix.parsePhrase(part, lang); p != nil {
+			q.phrases = append(q.phrases, p)
+		}
+		text = rest
+	}
+	q.words = ix.parseWords(loose.String(), lang)
+	if ix.Fuzzy {
+		ix.correct(q.words)
+	}
+	for _, pattern := range patterns {
+		c := clause{terms: make(map[string][]string)}
+		for _, f := range Fields {
+			if terms := ix.Expand(f, pattern, MaxExpansions); len(terms) > 0 {
+				c.terms[f] = terms
+			}
+		}
+		q.words = append(q.words, c)
+	}
+	return q
+}
+
+// isPunct is punctuation other than the wildcards.
+func isPunct(r rune) bool {
+	return unicode.IsPunct(r) && r != '*' && r != '?'
+}
+
+func (ix *Index) parsePhrase(text, lang string) phrase {
+	p := make(phrase)
+	for _, f := range Fields {
+		if tokens := ix.analyzers[f][lang].AnalyzeQuery(text); len(tokens) > 0 {
+			p[f] = tokens
+		}
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+func (ix *Index) parseWords(text, lang string) []clause {
+	byPos := make(map[int]*clause)
+	var order []int
+	for _, f := range Fields {
+		for _, t := range ix.analyzers[f][lang].AnalyzeQuery(text) {
+			c, ok := byPos[t.Position]
+			if !ok {
+				c = &clause{terms: make(map[string][]string), stop: true}
+				byPos[t.Position] = c
+				order = append(order, t.Position)
+			}
+			c.terms[f] = append(c.terms[f], t.Term)
+			c.stop = c.stop && t.Stop
+		}
+	}
+	slices.Sort(order)
+	clauses := make([]clause, 0, len(order))
+	required := 0
+	for _, pos := range order {
+		clauses = append(clauses, *byPos[pos])
+		if !byPos[pos].stop {
+			required++
+		}
+	}
+	if required == 0 {
+		// A query of nothing but stopwords ("the who") needs them all.
+		for i := range clauses {
+			clauses[i].stop = false
+		}
+	}
+	return clauses
+}
@@ -0,0 +1,197 @@
+package index
+
+import (
+	"cmp"
+	"fmt"
+	"maps"
+	"slices"
+)
+
+// Result is a matching document and its score.
+type Result struct {
+	DocInfo
+	Score float64
+}
+
+// Search returns up to limit documents matching every query word in some
+// field, stopwords aside, and every quoted phrase in some field, best
+// first. Scores are BM25 per field, weighted by Boosts and summed, with
+// phrases counting as terms, plus a bonus for query words that appear
+// close together, and with Config.Shingles for those side by side in the
+// title or headings. The documents of each language are searched with the
+// query analyzed by that language's analyzers, so it is stemmed and
+// stripped of stopwords the way they were.
+func (ix *Index) Search(text string, limit int) []Result {
+	return ix.SearchWith(text, limit, Options{})
+}
+
+// Options narrow down and order a search.
+type Options struct {
+	// Lang keeps documents in one language. Languages without a stemmer
+	// or stopwords of their own are searched together.
+	Lang string
+	// Ranges keeps documents within every range.
+	Ranges []Range
+	// Sort orders results by a numeric field, largest first, with score
+	// breaking ties; NumPublished lists the newest first. "" orders by
+	// score.
+	Sort string
+}
+
+// Range is an inclusive range of a numeric field. A Max of 0 leaves it
+// open above. Documents without a value, 0, are outside every range, as
+// are all documents for a field not in Numeric.
+type Range struct {
+	Field    string
+	Min, Max int64
+}
+
+func (r Range) contains(v int64) bool {
+	return v != 0 && v >= r.Min && (r.Max == 0 || v <= r.Max)
+}
+
+// SearchWith is Search narrowed down and ordered by opts.
+func (ix *Index) SearchWith(text string, limit int, opts Options) []Result {
+	langs, only := ix.indexedLangs(), false
+	if opts.Lang != "" {
+		langs, only = []string{ix.analyzerLang(opts.Lang)}, true
+	}
+	return ix.search(text, limit, langs, only, opts)
+}
+
+// search runs the query once for each set of langs whose analyzers read
+// it alike, over the documents in them, and ranks the documents found.
+// Unless only is set, the one set covering every language searches all
+// documents without checking their language.
+func (ix *Index) search(text string, limit int, langs []string, only bool, opts Options) []Result {
+	if ix.Len() == 0 {
+		return nil
+	}
+	type group struct {
+		q     query
+		langs map[string]bool
+	}
+	var groups []*group
+	byQuery := make(map[string]*group)
+	for _, lang := range langs {
+		q := ix.parseQuery(text, lang)
+		if len(q.words)+len(q.phrases) == 0 {
+			continue
+		}
+		key := fmt.Sprint(q)
+		g, ok := byQuery[key]
+		if !ok {
+			g = &group{q: q, langs: make(map[string]bool)}
+			byQuery[key] = g
+			groups = append(groups, g)
+		}
+		g.langs[lang] = true
+	}
+	inRanges := func(id DocID) bool {
+		r, n := ix.locate(id)
+		for _, rg := range opts.Ranges {
+			if !rg.contains(r.seg.value(n, rg.Field)) {
+				return false
+			}
+		}
+		return true
+	}
+	scores := make(map[DocID]float64)
+	for _, g := range groups {
+		in := func(id DocID) bool { return g.langs[ix.docLang(id)] && inRanges(id) }
+		if !only && len(g.langs) == len(langs) {
+			in = inRanges
+		}
+		maps.Copy(scores, ix.score(g.q, in))
+	}
+
+	// Hits are ranked on their scores and numeric fields alone; only the
+	// limit shown have their stored fields read.
+	ids := slices.Sorted(maps.Keys(scores)) // ties go to the earlier document
+	value := func(id DocID) int64 {
+		r, n := ix.locate(id)
+		return r.seg.value(n, opts.Sort)
+	}
+	slices.SortStableFunc(ids, func(a, b DocID) int {
+		if opts.Sort != "" {
+			if c := cmp.Compare(value(b), value(a)); c != 0 {
+				return c
+			}
+		}
+		return cmp.Compare(scores[b], scores[a])
+	})
+	ids = ids[:min(len(ids), limit)]
+	results := make([]Result, len(ids))
+	for i, id := range ids {
+		results[i] = Result{DocInfo: ix.Doc(id), Score: scores[id]}
+	}
+	return results
+}
+
+// score scores the documents matching q that in reports true for.
+func (ix *Index) score(q query, in func(DocID) bool) map[DocID]float64 {
+	scores := make(map[DocID]float64)
+	matched := make(map[DocID]int)
+	required := 0
+	for _, c := range q.words {
+		hit := make(map[DocID]bool)
+		for f, terms := range c.terms {
+			for _, term := range terms {
+				postings := ix.Postings(f, term)
+				if len(postings) == 0 {
+					continue
+				}
+				weight := ix.Boosts[f] * ix.BM25.idf(ix.Len(), len(postings))
+				tf := ix.tf(f)
+				for _, p := range postings {
+					scores[p.Doc] += weight * tf(p.Doc, p.Freq())
+					hit[p.Doc] = true
+				}
+			}
+		}
+		if c.stop {
+			continue
+		}
+		if len(hit) == 0 {
+			return nil // no document has every word
+		}
+		required++
+		for id := range hit {
+			matched[id]++
+		}
+	}
+	for _, p := range q.phrases {
+		hit := make(map[DocID]bool)
+		for f, tokens := range p {
+			matches := ix.phraseMatches(f, tokens)
+			if len(matches) == 0 {
+				continue
+			}
+			weight := ix.Boosts[f] * ix.BM25.idf(ix.Len(), len(matches))
+			tf := ix.tf(f)
+			for id, n := range matches {
+				scores[id] += weight * tf(id, n)
+				hit[id] = true
+			}
+		}
+		if len(hit) == 0 {
+			return nil
+		}
+		required++
+		for id := range hit {
+			matched[id]++
+		}
+	}
+
+	pairs := ix.shingleScores(q.words)
+	found := make(map[DocID]float64)
+	for id, n := range matched {
+		if n == required && in(id) {
+			found[id] = scores[id] + pairs[id]
+			if ix.Proximity {
+				found[id] += ix.proximity(id, q.words)
+			}
+		}
+	}
+	return found
+}
@@ -0,0 +1,752 @@
+package index
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"iter"
+	"maps"
+	"math"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+)
+
+// A segment is an immutable file of documents with their term dictionary
+// and postings, memory-mapped to serve queries; the index is a list of
+// them plus the in-memory buffer. Integers are little-endian:
+//
+//	header      "MSEG" version:u32
+//	doc data    per doc: url, title, snippet, favicon, image, each len:u32 + bytes
+//	doc table   per doc: offset of its data:u64
+//	lengths     per doc, per field: terms:u32
+//	urls        docs ordered by URL: doc:u32
+//	langs       names of the languages; per doc: language:u8
+//	values      names of the numeric fields; per doc, per numeric field: value:i64
+//	postings    per term, per doc: docgap:uvarint freq:uvarint positiongaps:uvarint...
+//	term text   all terms, back to back
+//	term tables per field, terms in order: text:u64 postings:u64 size:u32 len:u32 df:u32 enc:u32
+//	vocab       count:u32, the distinct terms of all fields in order: text:u64 len:u32
+//	gram lists  per gram, the terms containing it: vocabgap:uvarint...
+//	gram text   all grams, back to back
+//	gram table  count:u32, grams in order: text:u64 list:u64 len:u32 terms:u32
+//	field dir   per field, shingle fields too: len:u16 name terms:u32 table:u64
+//	footer      docs:u32 fields:u32 doctable:u64 lengths:u64 urls:u64 langs:u64 values:u64 vocab:u64 grams:u64 fielddir:u64 "MSEG"
+//
+// A list of names is count:u8, then per name len:u8 + bytes.
+//
+// Doc numbers and positions in a posting list are stored as the gap from
+// the one before, the first from 0, which keeps most of them to a byte.
+// Lists written with enc 1 are S2-compressed as a whole; size is the
+// number of bytes stored either way.
+//
+// Version 2 added the document languages, version 3 the posting encoding,
+// version 4 the numeric fields, version 5 the trigram index, version 6
+// the favicon and image.
+const (
+	segmentMagic   = "MSEG"
+	segmentVersion = 6
+	footerSize     = 4 + 4 + 8*8 + 4
+	termEntrySize  = 8 + 8 + 4 + 4 + 4 + 4
+	vocabEntrySize = 8 + 4
+	gramEntrySize  = 8 + 8 + 4 + 4
+)
+
+// Posting list encodings.
+const (
+	encVarint = 0
+	encS2     = 1
+)
+
+// MinCompressSize is the smallest encoded posting list compressed when
+// Config.Compress is set; shorter lists gain too little.
+const MinCompressSize = 512
+
+// segment is what the index reads from each of its segments, on disk or
+// in memory. Documents are numbered from 0 within a segment; postings
+// carry those local numbers.
+type segment interface {
+	numDocs() int
+	doc(n uint32) DocInfo
+	url(n uint32) string
+	lang(n uint32) string
+	value(n uint32, field string) int64
+	vocab(i uint32) string
+	gramList(gram string) []uint32
+	length(n uint32, field string) int
+	postingList(field, term string) []Posting
+	lookup(url string) (uint32, bool)
+	numTerms(field string) int
+	postingCount(field string) (postings int, size int64) // size 0 in memory
+	size() int64                                          // bytes on disk
+	terms(field, prefix string) iter.Seq[string]          // in order
+	close() error
+}
+
+var errCorrupt = errors.New("index: corrupt segment")
+
+type termTable struct {
+	off uint64
+	n   int
+}
+
+// diskSegment reads a segment file through a read-only memory map.
+type diskSegment struct {
+	data     []byte
+	unmap    func() error
+	docs     int
+	fields   []string
+	tables   map[string]termTable
+	docTable uint64
+	lengths  uint64
+	urls     uint64
+	langs    []string
+	langOf   uint64 // per doc, its index in langs
+	numeric  []string
+	values   uint64
+	vocabs   termTable // entries of vocabEntrySize
+	grams    termTable // entries of gramEntrySize
+}
+
+func openSegment(path string) (*diskSegment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	data, unmap, err := mapFile(f)
+	if err != nil {
+		return nil, err
+	}
+	s, err := parseSegment(data)
+	if err != nil {
+		unmap()
+		return nil, fmt.Errorf("%w: %s", err, path)
+	}
+	s.unmap = unmap
+	return s, nil
+}
+
+func parseSegment(data []byte) (*diskSegment, error) {
+	if len(data) < 8+footerSize || string(data[:4]) != segmentMagic || string(data[len(data)-4:]) != segmentMagic {
+		return nil, errCorrupt
+	}
+	if v := binary.LittleEndian.Uint32(data[4:]); v != segmentVersion {
+		return nil, fmt.Errorf("index: segment version %d, want %d", v, segmentVersion)
+	}
+	foot := data[len(data)-footerSize:]
+	s := &diskSegment{
+		data:     data,
+		docs:     int(binary.LittleEndian.Uint32(foot[0:])),
+		tables:   make(map[string]termTable),
+		docTable: binary.LittleEndian.Uint64(foot[8:]),
+		lengths:  binary.LittleEndian.Uint64(foot[16:]),
+		urls:     binary.LittleEndian.Uint64(foot[24:]),
+	}
+	nfields := int(binary.LittleEndian.Uint32(foot[4:]))
+	end := uint64(len(data) - footerSize)
+	var ok bool
+	if s.langs, s.langOf, ok = s.names(binary.LittleEndian.Uint64(foot[32:]), end); !ok || s.langOf+uint64(s.docs) > end {
+		return nil, errCorrupt
+	}
+	for n := range uint64(s.docs) {
+		if int(data[s.langOf+n]) >= len(s.langs) {
+			return nil, errCorrupt
+		}
+	}
+	if s.numeric, s.values, ok = s.names(binary.LittleEndian.Uint64(foot[40:]), end); !ok || s.values+8*uint64(s.docs*len(s.numeric)) > end {
+		return nil, errCorrupt
+	}
+	for i, t := range []*termTable{&s.vocabs, &s.grams} {
+		t.off = binary.LittleEndian.Uint64(foot[48+8*i:])
+		if t.off+4 > end {
+			return nil, errCorrupt
+		}
+		t.n = int(binary.LittleEndian.Uint32(data[t.off:]))
+		t.off += 4
+	}
+	if s.vocabs.off+uint64(s.vocabs.n)*vocabEntrySize > end || s.grams.off+uint64(s.grams.n)*gramEntrySize > end {
+		return nil, errCorrupt
+	}
+	off := binary.LittleEndian.Uint64(foot[64:])
+	for range nfields {
+		if off+2 > end {
+			return nil, errCorrupt
+		}
+		n := uint64(binary.LittleEndian.Uint16(data[off:]))
+		if off+2+n+12 > end {
+			return nil, errCorrupt
+		}
+		name := string(data[off+2 : off+2+n])
+		off += 2 + n
+		t := termTable{n: int(binary.LittleEndian.Uint32(data[off:])), off: binary.LittleEndian.Uint64(data[off+4:])}
+		if t.off+uint64(t.n)*termEntrySize > end {
+			return nil, errCorrupt
+		}
+		s.fields = append(s.fields, name)
+		s.tables[name] = t
+		off += 12
+	}
+	if s.docTable+8*uint64(s.docs) > end || s.lengths+4*uint64(s.docs*nfields) > end || s.urls+4*uint64(s.docs) > end {
+		return nil, errCorrupt
+	}
+	return s, nil
+}
+
+// names reads a list of names at off, before end, and returns it with the
+// offset after it.
+func (s *diskSegment) names(off, end uint64) ([]string, uint64, bool) {
+	if off >= end {
+		return nil, 0, false
+	}
+	count := int(s.data[off])
+	off++
+	names := make([]string, 0, count)
+	for range count {
+		if off >= end || off+1+uint64(s.data[off]) > end {
+			return nil, 0, false
+		}
+		n := uint64(s.data[off])
+		names = append(names, string(s.data[off+1:off+1+n]))
+		off += 1 + n
+	}
+	return names, off, true
+}
+
+func (s *diskSegment) u32(off uint64) uint32 { return binary.LittleEndian.Uint32(s.data[off:]) }
+
+func (s *diskSegment) u64(off uint64) uint64 { return binary.LittleEndian.Uint64(s.data[off:]) }
+
+// str reads a length-prefixed string at off and returns it with the
+// offset after it.
+func (s *diskSegment) str(off uint64) (string, uint64) {
+	n := uint64(s.u32(off))
+	return string(s.data[off+4 : off+4+n]), off + 4 + n
+}
+
+func (s *diskSegment) numDocs() int { return s.docs }
+
+func (s *diskSegment) url(n uint32) string {
+	u, _ := s.str(s.u64(s.docTable + 8*uint64(n)))
+	return u
+}
+
+func (s *diskSegment) lang(n uint32) string {
+	return s.langs[s.data[s.langOf+uint64(n)]]
+}
+
+func (s *diskSegment) doc(n uint32) DocInfo {
+	var d DocInfo
+	off := s.u64(s.docTable + 8*uint64(n))
+	d.URL, off = s.str(off)
+	d.Title, off = s.str(off)
+	d.Snippet, off = s.str(off)
+	d.Favicon, off = s.str(off)
+	d.Image, _ = s.str(off)
+	d.Lang = s.lang(n)
+	d.Values = make(map[string]int64, len(s.numeric))
+	for _, f := range s.numeric {
+		d.Values[f] = s.value(n, f)
+	}
+	d.Lengths = make(map[string]int, len(s.fields))
+	for _, f := range s.fields {
+		d.Lengths[f] = s.length(n, f)
+	}
+	return d
+}
+
+func (s *diskSegment) length(n uint32, field string) int {
+	i := slices.Index(s.fields, field)
+	if i < 0 {
+		return 0
+	}
+	return int(s.u32(s.lengths + 4*(uint64(n)*uint64(len(s.fields))+uint64(i))))
+}
+
+func (s *diskSegment) value(n uint32, field string) int64 {
+	i := slices.Index(s.numeric, field)
+	if i < 0 {
+		return 0
+	}
+	return int64(s.u64(s.values + 8*(uint64(n)*uint64(len(s.numeric))+uint64(i))))
+}
+
+func (s *diskSegment) vocab(i uint32) string {
+	e := s.vocabs.off + uint64(i)*vocabEntrySize
+	text, n := s.u64(e), uint64(s.u32(e+8))
+	return string(s.data[text : text+n])
+}
+
+func (s *diskSegment) gramAt(i int) string {
+	e := s.grams.off + uint64(i)*gramEntrySize
+	text, n := s.u64(e), uint64(s.u32(e+16))
+	return string(s.data[text : text+n])
+}
+
+// gramList decodes the vocabulary numbers of the terms containing gram;
+// like postingList, it reads a damaged list as empty.
+func (s *diskSegment) gramList(gram string) []uint32 {
+	i := sort.Search(s.grams.n, func(i int) bool { return s.gramAt(i) >= gram })
+	if i == s.grams.n || s.gramAt(i) != gram {
+		return nil
+	}
+	e := s.grams.off + uint64(i)*gramEntrySize
+	b := s.data[s.u64(e+8):]
+	list := make([]uint32, s.u32(e+20))
+	t := uint64(0)
+	for j := range list {
+		gap, n := binary.Uvarint(b)
+		if n <= 0 {
+			return nil
+		}
+		t += gap
+		if t >= uint64(s.vocabs.n) {
+			return nil
+		}
+		list[j], b = uint32(t), b[n:]
+	}
+	return list
+}
+
+func (s *diskSegment) lookup(url string) (uint32, bool) {
+	i := sort.Search(s.docs, func(i int) bool { return s.url(s.u32(s.urls+4*uint64(i))) >= url })
+	if i < s.docs {
+		if n := s.u32(s.urls + 4*uint64(i)); s.url(n) == url {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// termEntry is where a term's postings are stored and how.
+type termEntry struct {
+	postings uint64
+	size     uint64
+	df       int
+	enc      uint32
+}
+
+// termAt reads entry i of a term table: the term and its postings.
+func (s *diskSegment) termAt(t termTable, i int) (string, termEntry) {
+	e := t.off + uint64(i)*termEntrySize
+	text, n := s.u64(e), uint64(s.u32(e+20))
+	return string(s.data[text : text+n]), termEntry{
+		postings: s.u64(e + 8),
+		size:     uint64(s.u32(e + 16)),
+		df:       int(s.u32(e + 24)),
+		enc:      s.u32(e + 28),
+	}
+}
+
+// postingList decodes term's postings. A list that doesn't decode, which
+// only a damaged file has, reads as empty.
+func (s *diskSegment) postingList(field, term string) []Posting {
+	t, ok := s.tables[field]
+	if !ok {
+		return nil
+	}
+	i := sort.Search(t.n, func(i int) bool { w, _ := s.termAt(t, i); return w >= term })
+	if i == t.n {
+		return nil
+	}
+	w, e := s.termAt(t, i)
+	if w != term || e.postings+e.size > uint64(len(s.data)) {
+		return nil
+	}
+	b := s.data[e.postings : e.postings+e.size]
+	if e.enc == encS2 {
+		var err error
+		if b, err = s2.Decode(nil, b); err != nil {
+			return nil
+		}
+	}
+	list, err := decodePostings(b, e.df)
+	if err != nil {
+		return nil
+	}
+	return list
+}
+
+func (s *diskSegment) numTerms(field string) int { return s.tables[field].n }
+
+func (s *diskSegment) postingCount(field string) (int, int64) {
+	t := s.tables[field]
+	n, size := 0, int64(0)
+	for i := range t.n {
+		_, e := s.termAt(t, i)
+		n += e.df
+		size += int64(e.size)
+	}
+	return n, size
+}
+
+func (s *diskSegment) size() int64 { return int64(len(s.data)) }
+
+func (s *diskSegment) terms(field, prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		t := s.tables[field]
+		i := sort.Search(t.n, func(i int) bool { w, _ := s.termAt(t, i); return w >= prefix })
+		for ; i < t.n; i++ {
+			w, _ := s.termAt(t, i)
+			if !strings.HasPrefix(w, prefix) || !yield(w) {
+				return
+			}
+		}
+	}
+}
+
+func (s *diskSegment) close() error {
+	if s.unmap == nil {
+		return nil
+	}
+	return s.unmap()
+}
+
+// segmentWriter tracks the offset reached in the file being written.
+type segmentWriter struct {
+	w   *bufio.Writer
+	off uint64
+	buf [8]byte
+}
+
+func (w *segmentWriter) bytes(b []byte) {
+	w.w.Write(b)
+	w.off += uint64(len(b))
+}
+
+func (w *segmentWriter) u8(v uint8) {
+	w.buf[0] = v
+	w.bytes(w.buf[:1])
+}
+
+func (w *segmentWriter) u16(v uint16) {
+	binary.LittleEndian.PutUint16(w.buf[:], v)
+	w.bytes(w.buf[:2])
+}
+
+func (w *segmentWriter) u32(v uint32) {
+	binary.LittleEndian.PutUint32(w.buf[:], v)
+	w.bytes(w.buf[:4])
+}
+
+func (w *segmentWriter) u64(v uint64) {
+	binary.LittleEndian.PutUint64(w.buf[:], v)
+	w.bytes(w.buf[:8])
+}
+
+// names writes a list of names, each at most 255 bytes.
+func (w *segmentWriter) names(names []string) {
+	w.u8(uint8(len(names)))
+	for _, s := range names {
+		w.u8(uint8(len(s)))
+		w.bytes([]byte(s))
+	}
+}
+
+func (w *segmentWriter) uvarint(v uint64) {
+	w.bytes(binary.AppendUvarint(w.buf[:0], v))
+}
+
+func (w *segmentWriter) str(s string) {
+	w.u32(uint32(len(s)))
+	w.bytes([]byte(s))
+}
+
+// encodePostings appends list to b in the segment encoding.
+func encodePostings(b []byte, list []Posting) []byte {
+	prev := DocID(0)
+	for _, p := range list {
+		b = binary.AppendUvarint(b, uint64(p.Doc-prev))
+		b = binary.AppendUvarint(b, uint64(len(p.Positions)))
+		last := uint32(0)
+		for _, pos := range p.Positions {
+			b = binary.AppendUvarint(b, uint64(pos-last))
+			last = pos
+		}
+		prev = p.Doc
+	}
+	return b
+}
+
+// decodePostings reads df postings encoded by encodePostings.
+func decodePostings(b []byte, df int) ([]Posting, error) {
+	next := func() uint32 {
+		v, n := binary.Uvarint(b)
+		if n <= 0 || v > math.MaxUint32 {
+			b = nil
+			return 0
+		}
+		b = b[n:]
+		return uint32(v)
+	}
+	list := make([]Posting, df)
+	doc := DocID(0)
+	for j := range list {
+		doc += DocID(next())
+		freq := next()
+		if b == nil || uint64(freq) > uint64(len(b)) {
+			return nil, errCorrupt
+		}
+		pos := make([]uint32, freq)
+		last := uint32(0)
+		for k := range pos {
+			last += next()
+			pos[k] = last
+		}
+		if b == nil {
+			return nil, errCorrupt
+		}
+		list[j] = Posting{Doc: doc, Positions: pos}
+	}
+	return list, nil
+}
+
+// writeSegment writes the live documents of segs, in order, to a new
+// segment file at path. Deleted documents and terms left without postings
+// are dropped, so writing one segment compacts it and writing several
+// merges them; posting lists of at least MinCompressSize bytes are
+// compressed if compress is set and it makes them smaller. It returns each
+// old document's number in the new segment, -1 for those dropped, and how
+// many were written.
+func writeSegment(path string, segs []*segRef, compress bool) (remap [][]int32, n int, err error) {
+	remap = make([][]int32, len(segs))
+	var docs []DocInfo
+	for i, r := range segs {
+		remap[i] = make([]int32, r.seg.numDocs())
+		for n := range remap[i] {
+			if r.deleted[uint32(n)] {
+				remap[i][n] = -1
+				continue
+			}
+			remap[i][n] = int32(len(docs))
+			docs = append(docs, r.seg.doc(uint32(n)))
+		}
+	}
+
+	var langList []string
+	for _, d := range docs {
+		if !slices.Contains(langList, d.Lang) {
+			langList = append(langList, d.Lang)
+		}
+	}
+	if len(langList) > math.MaxUint8 || slices.ContainsFunc(langList, func(l string) bool { return len(l) > math.MaxUint8 }) {
+		return nil, 0, errors.New("index: too many or too long document languages for one segment")
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	w := &segmentWriter{w: bufio.NewWriter(f)}
+	w.bytes([]byte(segmentMagic))
+	w.u32(segmentVersion)
+
+	docOffsets := make([]uint64, len(docs))
+	for i, d := range docs {
+		docOffsets[i] = w.off
+		w.str(d.URL)
+		w.str(d.Title)
+		w.str(d.Snippet)
+		w.str(d.Favicon)
+		w.str(d.Image)
+	}
+	docTable := w.off
+	for _, off := range docOffsets {
+		w.u64(off)
+	}
+	lengths := w.off
+	for _, d := range docs {
+		for _, f := range postingFields {
+			w.u32(uint32(d.Lengths[f]))
+		}
+	}
+	urls := w.off
+	byURL := make([]uint32, len(docs))
+	for i := range byURL {
+		byURL[i] = uint32(i)
+	}
+	slices.SortFunc(byURL, func(a, b uint32) int { return cmp.Compare(docs[a].URL, docs[b].URL) })
+	for _, n := range byURL {
+		w.u32(n)
+	}
+	langs := w.off
+	w.names(langList)
+	for _, d := range docs {
+		w.u8(uint8(slices.Index(langList, d.Lang)))
+	}
+	values := w.off
+	w.names(Numeric)
+	for _, d := range docs {
+		for _, f := range Numeric {
+			w.u64(uint64(d.Values[f]))
+		}
+	}
+
+	type entry struct {
+		term string
+		termEntry
+	}
+	entries := make(map[string][]entry)
+	var list []Posting
+	var buf, packed []byte
+	for _, f := range postingFields {
+		for term := range mergedTerms(segs, f, "") {
+			list = list[:0]
+			for i, r := range segs {
+				for _, p := range r.seg.postingList(f, term) {
+					if n := remap[i][p.Doc]; n >= 0 {
+						list = append(list, Posting{Doc: DocID(n), Positions: p.Positions})
+					}
+				}
+			}
+			if len(list) == 0 {
+				continue
+			}
+			buf = encodePostings(buf[:0], list)
+			e := entry{term, termEntry{postings: w.off, df: len(list), enc: encVarint}}
+			data := buf
+			if compress && len(buf) >= MinCompressSize {
+				if packed = s2.Encode(packed[:cap(packed)], buf); len(packed) < len(buf) {
+					data, e.enc = packed, encS2
+				}
+			}
+			e.size = uint64(len(data))
+			w.bytes(data)
+			entries[f] = append(entries[f], e)
+		}
+	}
+	text := make(map[string][]uint64)
+	for _, f := range postingFields {
+		for _, e := range entries[f] {
+			text[f] = append(text[f], w.off)
+			w.bytes([]byte(e.term))
+		}
+	}
+	tables := make(map[string]uint64)
+	for _, f := range postingFields {
+		tables[f] = w.off
+		for i, e := range entries[f] {
+			w.u64(text[f][i])
+			w.u64(e.postings)
+			w.u32(uint32(e.size))
+			w.u32(uint32(len(e.term)))
+			w.u32(uint32(e.df))
+			w.u32(e.enc)
+		}
+	}
+	vocabText := make(map[string]uint64) // the vocabulary points into the term text
+	for _, f := range Fields {
+		for i, e := range entries[f] {
+			if _, ok := vocabText[e.term]; !ok {
+				vocabText[e.term] = text[f][i]
+			}
+		}
+	}
+	terms := slices.Sorted(maps.Keys(vocabText))
+	vocab := w.off
+	w.u32(uint32(len(terms)))
+	for _, t := range terms {
+		w.u64(vocabText[t])
+		w.u32(uint32(len(t)))
+	}
+	gramTerms := make(map[string][]uint32)
+	for i, t := range terms {
+		for _, g := range grams(t) {
+			gramTerms[g] = append(gramTerms[g], uint32(i))
+		}
+	}
+	gramNames := slices.Sorted(maps.Keys(gramTerms))
+	gramLists := make([]uint64, len(gramNames))
+	for i, g := range gramNames {
+		gramLists[i] = w.off
+		prev := uint32(0)
+		for _, t := range gramTerms[g] {
+			w.uvarint(uint64(t - prev))
+			prev = t
+		}
+	}
+	gramText := make([]uint64, len(gramNames))
+	for i, g := range gramNames {
+		gramText[i] = w.off
+		w.bytes([]byte(g))
+	}
+	gramTable := w.off
+	w.u32(uint32(len(gramNames)))
+	for i, g := range gramNames {
+		w.u64(gramText[i])
+		w.u64(gramLists[i])
+		w.u32(uint32(len(g)))
+		w.u32(uint32(len(gramTerms[g])))
+	}
+	fieldDir := w.off
+	for _, f := range postingFields {
+		w.u16(uint16(len(f)))
+		w.bytes([]byte(f))
+		w.u32(uint32(len(entries[f])))
+		w.u64(tables[f])
+	}
+	w.u32(uint32(len(docs)))
+	w.u32(uint32(len(postingFields)))
+	w.u64(docTable)
+	w.u64(lengths)
+	w.u64(urls)
+	w.u64(langs)
+	w.u64(values)
+	w.u64(vocab)
+	w.u64(gramTable)
+	w.u64(fieldDir)
+	w.bytes([]byte(segmentMagic))
+	if err := w.w.Flush(); err != nil {
+		return nil, 0, err
+	}
+	if err := f.Sync(); err != nil {
+		return nil, 0, err
+	}
+	return remap, len(docs), f.Close()
+}
+
+// mergedTerms yields the distinct terms of field starting with prefix
+// across segs, in order.
+func mergedTerms(segs []*segRef, field, prefix string) iter.Seq[string] {
+	return func(yield func(string) bool) {
+		type head struct {
+			term string
+			next func() (string, bool)
+		}
+		var heads []head
+		for _, r := range segs {
+			next, stop := iter.Pull(r.seg.terms(field, prefix))
+			defer stop()
+			if t, ok := next(); ok {
+				heads = append(heads, head{t, next})
+			}
+		}
+		for len(heads) > 0 {
+			least := heads[0].term
+			for _, h := range heads[1:] {
+				least = min(least, h.term)
+			}
+			if !yield(least) {
+				return
+			}
+			live := heads[:0]
+			for _, h := range heads {
+				if h.term == least {
+					t, ok := h.next()
+					if !ok {
+						continue
+					}
+					h.term = t
+				}
+				live = append(live, h)
+			}
+			heads = live
+		}
+	}
+}
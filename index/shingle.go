@@ -0,0 +1,100 @@
+package index
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/realutkarshh/mini-search-crawler/analysis"
+)
+
+// With Config.Shingles set, the title and headings also have every pair
+// of consecutive terms indexed, "web crawler" as one term of a field of
+// its own. A query's consecutive words are then looked up as pairs, and
+// documents with them side by side get a bonus without their positions
+// being read; stopwords dropped between two words don't separate them.
+
+// ShingleFields are the fields whose word pairs Config.Shingles indexes.
+var ShingleFields = []string{FieldTitle, FieldHeadings}
+
+// ShingleWeight scales the bonus for a query word pair found in a field,
+// which is weighted like a term by the pair's rarity and the field's
+// boost.
+const ShingleWeight = 0.5
+
+// maxShinglePairs bounds the pairs looked up for two query words that
+// expand to several terms each, as wildcards do.
+const maxShinglePairs = 16
+
+// shingleSuffix names the field holding a field's word pairs.
+const shingleSuffix = ".shingles"
+
+// ShingleField is the field holding the word pairs of field f, as Stats
+// reports it.
+func ShingleField(f string) string { return f + shingleSuffix }
+
+func isShingleField(f string) bool { return strings.HasSuffix(f, shingleSuffix) }
+
+// postingFields are the fields segments hold posting lists for: Fields,
+// then the shingle fields.
+var postingFields = func() []string {
+	fs := slices.Clone(Fields)
+	for _, f := range ShingleFields {
+		fs = append(fs, ShingleField(f))
+	}
+	return fs
+}()
+
+// shingles pairs each position's tokens with the next position's, at the
+// first one's position. Synonyms make several pairs.
+func shingles(tokens []analysis.Token) []analysis.Token {
+	var out []analysis.Token
+	var prev, cur []analysis.Token
+	flush := func() {
+		for _, a := range prev {
+			for _, b := range cur {
+				out = append(out, analysis.Token{Term: a.Term + " " + b.Term, Position: a.Position})
+			}
+		}
+		prev, cur = cur, nil
+	}
+	for i, t := range tokens {
+		if i > 0 && t.Position != tokens[i-1].Position {
+			flush()
+		}
+		cur = append(cur, t)
+	}
+	flush()
+	return out
+}
+
+// shingleScores is the bonus of each document with consecutive query
+// words as a pair in a shingle field.
+func (ix *Index) shingleScores(words []clause) map[DocID]float64 {
+	if !ix.config.Shingles {
+		return nil
+	}
+	scores := make(map[DocID]float64)
+	for i := 1; i < len(words); i++ {
+		for _, f := range ShingleFields {
+			a, b := words[i-1].terms[f], words[i].terms[f]
+			if len(a) == 0 || len(b) == 0 || len(a)*len(b) > maxShinglePairs {
+				continue
+			}
+			var pairs []string
+			for _, x := range a {
+				for _, y := range b {
+					pairs = append(pairs, x+" "+y)
+				}
+			}
+			list := ix.anyPostings(ShingleField(f), pairs)
+			if len(list) == 0 {
+				continue
+			}
+			weight := ShingleWeight * ix.Boosts[f] * ix.BM25.idf(ix.Len(), len(list))
+			for _, p := range list {
+				scores[p.Doc] += weight
+			}
+		}
+	}
+	return scores
+}
@@ -0,0 +1,158 @@
+package index
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// A snapshot is a copy of a saved index as a set of named files: its
+// segments, their tombstones and, written last, the manifest. The names
+// are those of an index directory, so a snapshot copied to a directory is
+// an index that Open reads; one without a manifest is incomplete.
+
+// Snapshot hands put the files of the index as last saved or opened.
+// Segments are copied from their mappings and tombstones and the manifest
+// from memory, so the copy is consistent even while another process saves
+// a newer index to the same directory. An index with changes not yet
+// saved can't be snapshot.
+func (ix *Index) Snapshot(put func(name string, r io.Reader, size int64) error) error {
+	if ix.dir == "" {
+		return errors.New("index: snapshot: index has no directory; Save it first")
+	}
+	m := manifest{Config: ix.config}
+	for _, r := range ix.segs {
+		if r.name == "" {
+			if r.seg.numDocs() > 0 {
+				return errors.New("index: snapshot: index has unsaved documents; Save it first")
+			}
+			continue
+		}
+		seg, ok := r.seg.(*diskSegment)
+		if !ok || r.dirty {
+			return errors.New("index: snapshot: index has unsaved deletions; Save it first")
+		}
+		if err := put(r.name, bytes.NewReader(seg.data), int64(len(seg.data))); err != nil {
+			return fmt.Errorf("index: snapshot: %s: %w", r.name, err)
+		}
+		if len(r.deleted) > 0 {
+			data := encodeTombstones(r.deleted)
+			if err := put(tombstoneName(r.name), bytes.NewReader(data), int64(len(data))); err != nil {
+				return fmt.Errorf("index: snapshot: %s: %w", tombstoneName(r.name), err)
+			}
+		}
+		m.Segments = append(m.Segments, manifestSegment{Name: r.name, Docs: r.seg.numDocs(), Deleted: len(r.deleted)})
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := put(ManifestFile, bytes.NewReader(data), int64(len(data))); err != nil {
+		return fmt.Errorf("index: snapshot: %s: %w", ManifestFile, err)
+	}
+	return nil
+}
+
+// Restore replaces the index saved in dir with the snapshot get reads
+// files of, and returns how many documents it holds. Each segment is
+// checked against the manifest once copied. Segments are numbered after
+// those already in dir and the manifest is renamed over the old one last,
+// so readers see the old index or the new one whole, as with Save.
+func Restore(dir string, get func(name string) (io.ReadCloser, error)) (int, error) {
+	rc, err := get(ManifestFile)
+	if err != nil {
+		return 0, fmt.Errorf("index: restore: %s: %w", ManifestFile, err)
+	}
+	var m manifest
+	err = json.NewDecoder(rc).Decode(&m)
+	rc.Close()
+	if err != nil {
+		return 0, fmt.Errorf("index: restore: %s: %w", ManifestFile, err)
+	}
+	ix, err := New(m.Config)
+	if err != nil {
+		return 0, fmt.Errorf("index: restore: %w", err)
+	}
+	if err := ix.setDir(dir); err != nil {
+		return 0, err
+	}
+	restored := manifest{Config: m.Config}
+	listed := make(map[string]bool)
+	docs := 0
+	for _, ms := range m.Segments {
+		name := ix.newSegmentName()
+		if err := restoreSegment(dir, name, ms, get); err != nil {
+			return 0, fmt.Errorf("index: restore: %s: %w", ms.Name, err)
+		}
+		restored.Segments = append(restored.Segments, manifestSegment{Name: name, Docs: ms.Docs, Deleted: ms.Deleted})
+		listed[name] = true
+		docs += ms.Docs - ms.Deleted
+	}
+	if err := writeManifest(dir, restored); err != nil {
+		return 0, fmt.Errorf("index: restore: %w", err)
+	}
+	return docs, ix.removeUnlisted(listed)
+}
+
+// restoreSegment copies the segment ms, and its tombstones if it has any,
+// from get to dir under name.
+func restoreSegment(dir, name string, ms manifestSegment, get func(string) (io.ReadCloser, error)) error {
+	path := filepath.Join(dir, name)
+	if err := copyFile(path, ms.Name, get); err != nil {
+		return err
+	}
+	seg, err := openSegment(path)
+	if err != nil {
+		return err
+	}
+	docs := seg.numDocs()
+	seg.close()
+	if docs != ms.Docs {
+		return fmt.Errorf("has %d documents, manifest says %d", docs, ms.Docs)
+	}
+	if ms.Deleted == 0 {
+		return nil
+	}
+	path = filepath.Join(dir, tombstoneName(name))
+	if err := copyFile(path, tombstoneName(ms.Name), get); err != nil {
+		return err
+	}
+	deleted, err := readTombstones(path, docs)
+	if err != nil {
+		return err
+	}
+	if len(deleted) != ms.Deleted {
+		return fmt.Errorf("has %d tombstones, manifest says %d", len(deleted), ms.Deleted)
+	}
+	return nil
+}
+
+// copyFile writes the file get reads under name to path, by way of a
+// temporary file.
+func copyFile(path, name string, get func(string) (io.ReadCloser, error)) error {
+	rc, err := get(name)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, rc)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
@@ -0,0 +1,72 @@
+package index
+
+// Stats describes what an index holds and how it is stored, for planning
+// capacity and checking that a crawl made it into the index. Postings and
+// Terms include what deleted documents left behind until their segments
+// are rewritten; Deleted says how much of that there is. The totals take
+// in the shingle fields.
+type Stats struct {
+	Docs     int   // live documents
+	Deleted  int   // deleted documents still in segments
+	Segments int   // segment files
+	Buffered int   // live documents not yet flushed to a segment
+	Terms    int   // distinct terms, counted per field
+	Postings int   // term-document pairs
+	Bytes    int64 // size of the segment files
+	Fields   map[string]FieldStats
+	Langs    map[string]int // analyzer language -> live documents
+}
+
+// FieldStats is Stats for one field, or the pairs of a ShingleField.
+// Tokens counts the terms of the live documents, repeats included; Bytes
+// is the size of the posting lists on disk.
+type FieldStats struct {
+	Terms    int
+	Postings int
+	Tokens   int
+	Bytes    int64
+}
+
+// DeletedRatio is the share of the documents in segments that are
+// deleted, space Compact gets back.
+func (s Stats) DeletedRatio() float64 {
+	if s.Docs+s.Deleted == 0 {
+		return 0
+	}
+	return float64(s.Deleted) / float64(s.Docs+s.Deleted)
+}
+
+// Stats reads the index's statistics. Counting terms and postings reads
+// every segment's term tables.
+func (ix *Index) Stats() Stats {
+	s := Stats{
+		Docs:     ix.live,
+		Segments: len(ix.segs) - 1,
+		Fields:   make(map[string]FieldStats),
+		Langs:    make(map[string]int),
+	}
+	for _, r := range ix.segs {
+		s.Deleted += len(r.deleted)
+		s.Bytes += r.seg.size()
+	}
+	buf := ix.segs[len(ix.segs)-1]
+	s.Buffered = buf.seg.numDocs() - len(buf.deleted)
+	for _, f := range postingFields {
+		fs := FieldStats{Tokens: ix.fieldLen[f]}
+		for range mergedTerms(ix.segs, f, "") {
+			fs.Terms++
+		}
+		for _, r := range ix.segs {
+			n, size := r.seg.postingCount(f)
+			fs.Postings += n
+			fs.Bytes += size
+		}
+		s.Terms += fs.Terms
+		s.Postings += fs.Postings
+		s.Fields[f] = fs
+	}
+	for _, lang := range ix.indexedLangs() {
+		s.Langs[lang] = ix.langs[lang]
+	}
+	return s
+}
@@ -0,0 +1,278 @@
+package index
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ManifestFile lists the segments making up the index saved in a
+// directory. Segment files not listed in it are left over from before
+// the last save and are removed by the next one.
+const ManifestFile = "manifest.json"
+
+// segmentExt ends segment file names, which are otherwise numbers.
+const segmentExt = ".seg"
+
+type manifest struct {
+	Config   Config            `json:"config"`
+	Segments []manifestSegment `json:"segments"`
+}
+
+type manifestSegment struct {
+	Name    string `json:"name"`
+	Docs    int    `json:"docs"`
+	Deleted int    `json:"deleted,omitempty"`
+}
+
+// Open loads the index saved in dir. Segments are mapped, not read, so
+// opening is quick however large the index is.
+func Open(dir string) (*Index, error) {
+	// A save may replace segments between reading the manifest and
+	// opening them; the new manifest names the new ones.
+	for attempt := 0; ; attempt++ {
+		ix, err := open(dir)
+		if errors.Is(err, errSegmentGone) && attempt < 3 {
+			continue
+		}
+		return ix, err
+	}
+}
+
+var errSegmentGone = errors.New("index: segment removed while opening")
+
+func open(dir string) (*Index, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("index: open: %w", err)
+	}
+	ix, err := New(m.Config)
+	if err != nil {
+		return nil, fmt.Errorf("index: open: %w", err)
+	}
+	ix.segs = ix.segs[:0]
+	var base DocID
+	for _, ms := range m.Segments {
+		seg, err := openSegment(filepath.Join(dir, ms.Name))
+		if errors.Is(err, fs.ErrNotExist) {
+			ix.Close()
+			return nil, errSegmentGone
+		}
+		if err != nil {
+			ix.Close()
+			return nil, fmt.Errorf("index: open: %w", err)
+		}
+		r := &segRef{seg: seg, name: ms.Name, base: base}
+		ix.segs = append(ix.segs, r)
+		if seg.numDocs() != ms.Docs {
+			ix.Close()
+			return nil, fmt.Errorf("index: open: %s has %d documents, manifest says %d", ms.Name, seg.numDocs(), ms.Docs)
+		}
+		// A save that stopped between writing tombstones and the manifest
+		// leaves the tombstones ahead of it; they are kept.
+		if r.deleted, err = readTombstones(filepath.Join(dir, tombstoneName(ms.Name)), seg.numDocs()); err != nil {
+			ix.Close()
+			return nil, fmt.Errorf("index: open: %s: %w", tombstoneName(ms.Name), err)
+		}
+		if len(r.deleted) < ms.Deleted {
+			ix.Close()
+			return nil, fmt.Errorf("index: open: %s has %d tombstones, manifest says %d", ms.Name, len(r.deleted), ms.Deleted)
+		}
+		for n := range uint32(seg.numDocs()) {
+			if r.deleted[n] {
+				continue
+			}
+			ix.live++
+			ix.langs[ix.analyzerLang(seg.lang(n))]++
+			for _, f := range Fields {
+				ix.fieldLen[f] += seg.length(n, f)
+			}
+		}
+		base += DocID(seg.numDocs())
+	}
+	ix.segs = append(ix.segs, &segRef{seg: newMemSegment(), base: base, deleted: make(map[uint32]bool)})
+	if err := ix.setDir(dir); err != nil {
+		ix.Close()
+		return nil, err
+	}
+	return ix, nil
+}
+
+// Create returns an empty index that will be saved in dir. Any index
+// already there stays in place, and readable, until Save replaces it.
+func Create(dir string, cfg Config) (*Index, error) {
+	ix, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := ix.setDir(dir); err != nil {
+		return nil, err
+	}
+	return ix, nil
+}
+
+// setDir makes dir the index's directory, numbering new segments after
+// any already there.
+func (ix *Index) setDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if n, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64); err == nil && strings.HasSuffix(e.Name(), segmentExt) {
+			ix.nextSeg = max(ix.nextSeg, n+1)
+		}
+	}
+	ix.dir = dir
+	return nil
+}
+
+// Flush writes the buffered documents to a new segment file. They aren't
+// part of the saved index until Save lists the segment in the manifest.
+func (ix *Index) Flush() error {
+	if ix.dir == "" {
+		return errors.New("index: flush: index has no directory; Save it first")
+	}
+	r, buf := ix.buffer()
+	if buf.numDocs() == len(r.deleted) {
+		ix.segs[len(ix.segs)-1] = &segRef{seg: newMemSegment(), base: r.base, deleted: make(map[uint32]bool)}
+		return nil
+	}
+	name := ix.newSegmentName()
+	seg, _, n, err := writeSegmentFile(ix.dir, name, []*segRef{r}, ix.config.Compress)
+	if err != nil {
+		return err
+	}
+	ix.segs[len(ix.segs)-1] = &segRef{seg: seg, name: name, base: r.base, deleted: make(map[uint32]bool)}
+	ix.segs = append(ix.segs, &segRef{seg: newMemSegment(), base: r.base + DocID(n), deleted: make(map[uint32]bool)})
+	return nil
+}
+
+func (ix *Index) newSegmentName() string {
+	name := fmt.Sprintf("%08d%s", ix.nextSeg, segmentExt)
+	ix.nextSeg++
+	return name
+}
+
+// writeSegmentFile writes segs as one segment file called name in dir and
+// opens it; see writeSegment.
+func writeSegmentFile(dir, name string, segs []*segRef, compress bool) (*diskSegment, [][]int32, int, error) {
+	path := filepath.Join(dir, name)
+	tmp := path + ".tmp"
+	remap, n, err := writeSegment(tmp, segs, compress)
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return nil, nil, 0, fmt.Errorf("index: write segment: %w", err)
+	}
+	seg, err := openSegment(path)
+	if err != nil {
+		return nil, nil, 0, fmt.Errorf("index: %w", err)
+	}
+	return seg, remap, n, nil
+}
+
+// Save flushes the buffer and writes the manifest to dir, replacing any
+// index saved there. The manifest is written beside the old one and
+// renamed over it, so readers see either index whole. An index that was
+// opened or saved before can only be saved to the same directory.
+func (ix *Index) Save(dir string) error {
+	if ix.dir == "" {
+		if err := ix.setDir(dir); err != nil {
+			return err
+		}
+	} else if filepath.Clean(dir) != filepath.Clean(ix.dir) {
+		return fmt.Errorf("index: save: index belongs in %s, not %s", ix.dir, dir)
+	}
+	if err := ix.Flush(); err != nil {
+		return err
+	}
+	m := manifest{Config: ix.config}
+	listed := make(map[string]bool)
+	for _, r := range ix.segs[:len(ix.segs)-1] {
+		if r.dirty {
+			if err := writeTombstones(filepath.Join(ix.dir, tombstoneName(r.name)), r.deleted); err != nil {
+				return fmt.Errorf("index: save: %w", err)
+			}
+			r.dirty = false
+		}
+		m.Segments = append(m.Segments, manifestSegment{Name: r.name, Docs: r.seg.numDocs(), Deleted: len(r.deleted)})
+		listed[r.name] = true
+	}
+	if err := writeManifest(ix.dir, m); err != nil {
+		return fmt.Errorf("index: save: %w", err)
+	}
+	return ix.removeUnlisted(listed)
+}
+
+// writeManifest writes m beside the manifest in dir and renames it over
+// that one.
+func writeManifest(dir string, m manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ManifestFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, ManifestFile))
+}
+
+// removeUnlisted deletes segment files the manifest doesn't name, other
+// than one a merge is writing, and their tombstones. Readers that still
+// have one mapped keep reading it until they close.
+func (ix *Index) removeUnlisted(listed map[string]bool) error {
+	entries, err := os.ReadDir(ix.dir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		var seg string
+		switch name := e.Name(); {
+		case strings.HasSuffix(name, segmentExt):
+			seg = name
+		case strings.HasSuffix(name, tombstoneExt):
+			seg = strings.TrimSuffix(name, tombstoneExt) + segmentExt
+		default:
+			continue
+		}
+		if !listed[seg] && seg != ix.merging {
+			if err := os.Remove(filepath.Join(ix.dir, e.Name())); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Close unmaps the index's segments. The index can't be used afterwards.
+func (ix *Index) Close() error {
+	var errs []error
+	for _, r := range ix.segs {
+		errs = append(errs, r.seg.close())
+	}
+	return errors.Join(errs...)
+}
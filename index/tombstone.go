@@ -0,0 +1,75 @@
+package index
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// A segment's deletions are kept in a tombstone file beside it, named
+// after it with tombstoneExt: the deleted document numbers, in order, as
+// little-endian u32s. Queries skip tombstoned documents, and merging
+// drops them for good. Save rewrites the files of segments with new
+// deletions before the manifest; the manifest records how many each
+// should hold.
+const tombstoneExt = ".del"
+
+func tombstoneName(segment string) string {
+	return strings.TrimSuffix(segment, segmentExt) + tombstoneExt
+}
+
+// encodeTombstones is the tombstone file holding deleted.
+func encodeTombstones(deleted map[uint32]bool) []byte {
+	data := make([]byte, 0, 4*len(deleted))
+	for _, n := range slices.Sorted(maps.Keys(deleted)) {
+		data = binary.LittleEndian.AppendUint32(data, n)
+	}
+	return data
+}
+
+// writeTombstones replaces the tombstone file at path.
+func writeTombstones(path string, deleted map[uint32]bool) error {
+	data := encodeTombstones(deleted)
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// readTombstones reads the tombstone file at path; a missing file means
+// no deletions.
+func readTombstones(path string, docs int) (map[uint32]bool, error) {
+	deleted := make(map[uint32]bool)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return deleted, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%4 != 0 {
+		return nil, errCorrupt
+	}
+	for i := 0; i < len(data); i += 4 {
+		n := binary.LittleEndian.Uint32(data[i:])
+		if int(n) >= docs {
+			return nil, errCorrupt
+		}
+		deleted[n] = true
+	}
+	return deleted, nil
+}
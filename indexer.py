@@ -35,6 +35,13 @@ STOPWORDS = {
 
 TOKEN_RE = re.compile(r"[a-zA-Z0-9]+")
 
+# Heading terms count this many extra times on top of their occurrence in
+# the body text.
+HEADING_WEIGHTS = {"h1": 3, "h2": 2, "h3": 1}
+
+# Likewise for the page's tags and keywords.
+TAG_WEIGHT = 2
+
 def tokenize(text: str):
     text = (text or "").lower()
     tokens = TOKEN_RE.findall(text)
@@ -51,6 +58,11 @@ def build_index():
     "url": 1,
     "title": 1,
     "text": 1,
+    "main_text": 1,
+    "headings": 1,
+    "tags": 1,
+    "breadcrumbs": 1,
+    "last_changed": 1,
     "snippet": 1,
     "favicon": 1,
     "site_name": 1,
@@ -90,9 +102,10 @@ def build_index():
             # Prefer stored title; fallback to url if empty
             title = page.get("title") or url
 
-            # Prefer full text for indexing. If missing, try snippet.
+            # Prefer main content for indexing, then full text (pages
+            # crawled before main_text existed), then snippet.
             # Ensure we have a string to tokenize.
-            text = page.get("text")
+            text = page.get("main_text") or page.get("text")
             if text is None:
                 text = page.get("snippet", "")
             if text is None:
@@ -107,6 +120,13 @@ def build_index():
             if not tokens:
                 continue
 
+            headings = page.get("headings") or {}
+            for level, weight in HEADING_WEIGHTS.items():
+                for heading in headings.get(level) or []:
+                    tokens.extend(tokenize(heading) * weight)
+            for tag in page.get("tags") or []:
+                tokens.extend(tokenize(tag) * TAG_WEIGHT)
+
             doc_lengths[doc_id] = len(tokens)
             # store snippet preferentially: page.snippet else first 300 chars of text
             snippet = page.get("snippet")
@@ -119,7 +139,10 @@ def build_index():
                 "snippet": snippet,
                 "favicon": page.get("favicon", ""),
                 "site_name": page.get("site_name", ""),
-                "image": page.get("image", "")
+                "image": page.get("image", ""),
+                "tags": page.get("tags") or [],
+                "breadcrumbs": page.get("breadcrumbs") or [],
+                "last_changed": page.get("last_changed")
             }
 
 
@@ -172,7 +195,10 @@ def build_index():
             "snippet": meta["snippet"],
             "favicon": meta.get("favicon", ""),
             "site_name": meta.get("site_name", ""),
-            "image": meta.get("image", "")
+            "image": meta.get("image", ""),
+            "tags": meta.get("tags", []),
+            "breadcrumbs": meta.get("breadcrumbs", []),
+            "last_changed": meta.get("last_changed")  # freshness signal for ranking
         })
 
     if docs_bulk:
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/language"
+)
+
+// ----- Language detection -----
+
+// MinDetectLetters is the least text the trigram classifier will judge;
+// shorter pages get no language rather than a guess.
+const MinDetectLetters = 60
+
+// pageLanguage returns the page's ISO 639-1 language ("en", "de", ...)
+// from, in order, the <html lang> attribute, a single-valued
+// Content-Language header, and the text itself. It returns "" when none
+// of them is conclusive.
+func pageLanguage(htmlLang, contentLanguage, text string) string {
+	if l := baseLanguage(htmlLang); l != "" {
+		return l
+	}
+	if !strings.Contains(contentLanguage, ",") {
+		if l := baseLanguage(contentLanguage); l != "" {
+			return l
+		}
+	}
+	return detectLanguage(text)
+}
+
+// baseLanguage reduces a BCP 47 tag such as "en-US" or "pt_BR" to its
+// language subtag; "", "und", "x-default" and unknown tags give "".
+func baseLanguage(tag string) string {
+	t, err := language.Parse(strings.TrimSpace(tag))
+	if err != nil {
+		return ""
+	}
+	base, conf := t.Base()
+	if conf < language.High {
+		return ""
+	}
+	return base.String()
+}
+
+// scriptLanguages maps scripts used by essentially one language (or where
+// one dominates the web) to it.
+var scriptLanguages = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"}, {unicode.Katakana, "ja"}, {unicode.Hangul, "ko"},
+	{unicode.Han, "zh"}, {unicode.Cyrillic, "ru"}, {unicode.Arabic, "ar"},
+	{unicode.Greek, "el"}, {unicode.Hebrew, "he"}, {unicode.Devanagari, "hi"},
+	{unicode.Thai, "th"},
+}
+
+// trigramProfiles are the most frequent letter trigrams of each language,
+// most frequent first, with "_" standing for a word boundary.
+var trigramProfiles = map[string][]string{
+	"en": {"_th", "the", "he_", "and", "_an", "nd_", "_of", "of_", "_to", "ing", "ng_", "_in", "to_", "ion", "is_", "_is", "ed_", "_a_", "in_", "tio", "ent", "er_", "hat", "_ha", "re_", "_co", "for", "es_", "_wa", "as_"},
+	"de": {"en_", "er_", "_de", "der", "ie_", "ein", "ich", "sch", "_di", "die", "und", "_un", "nd_", "che", "den", "in_", "te_", "ch_", "_ei", "cht", "gen", "ine", "_zu", "ung", "es_", "ist", "das", "_da", "_ge", "ter"},
+	"fr": {"es_", "_de", "de_", "ent", "_le", "le_", "nt_", "la_", "_la", "ion", "les", "_et", "et_", "re_", "on_", "des", "_pa", "que", "_qu", "ue_", "ne_", "men", "_co", "our", "tio", "_en", "ait", "_un", "une", "par"},
+	"es": {"_de", "de_", "os_", "_la", "la_", "el_", "_el", "es_", "ión", "ent", "en_", "_en", "as_", "que", "_qu", "ue_", "do_", "_lo", "los", "ado", "_co", "ar_", "nte", "con", "_y_", "er_", "res", "ien", "_es", "por"},
+	"it": {"_di", "di_", "to_", "la_", "_la", "ell", "_de", "re_", "che", "ion", "one", "_ch", "ne_", "del", "_co", "_in", "lla", "le_", "ent", "per", "_pe", "ato", "ta_", "zio", "ia_", "_il", "il_", "no_", "_un", "are"},
+	"pt": {"_de", "de_", "os_", "ão_", "do_", "_qu", "que", "ue_", "as_", "_co", "es_", "ent", "da_", "ção", "_a_", "em_", "_da", "nte", "com", "_do", "_se", "ra_", "men", "ado", "par", "_pa", "res", "_um", "uma", "não"},
+	"nl": {"en_", "_de", "de_", "et_", "an_", "van", "_va", "_he", "het", "een", "_ee", "er_", "ij_", "aar", "oor", "in_", "_in", "_en", "ing", "ver", "_ve", "te_", "sch", "ijk", "lij", "den", "nde", "ook", "_ni", "iet"},
+	"sv": {"en_", "_oc", "och", "ch_", "att", "_at", "er_", "_de", "för", "_fö", "ar_", "ing", "det", "_so", "som", "om_", "an_", "är_", "_är", "de_", "tt_", "_en", "et_", "lig", "and", "med", "_me", "_av", "av_", "ill"},
+}
+
+// detectLanguage classifies text by script, or for Latin text by how well
+// its trigrams match each profile, earlier profile entries weighing more.
+func detectLanguage(text string) string {
+	letters := 0
+	scripts := make(map[string]int)
+	var b strings.Builder
+	for _, r := range strings.ToLower(text) {
+		if !unicode.IsLetter(r) {
+			b.WriteByte('_')
+			continue
+		}
+		letters++
+		if unicode.Is(unicode.Latin, r) {
+			b.WriteRune(r)
+			continue
+		}
+		b.WriteByte('_')
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scripts[s.lang]++
+				break
+			}
+		}
+	}
+	// A CJK character carries about a word's worth of text.
+	if letters+3*(scripts["ja"]+scripts["zh"]+scripts["ko"]) < MinDetectLetters {
+		return ""
+	}
+
+	// Japanese text mixes Han with kana.
+	if scripts["ja"] > 0 && scripts["ja"]+scripts["zh"] > letters/2 {
+		return "ja"
+	}
+	for lang, n := range scripts {
+		if n > letters/2 {
+			return lang
+		}
+	}
+
+	counts := make(map[string]int)
+	runes := []rune(strings.Join(strings.FieldsFunc(b.String(), func(r rune) bool { return r == '_' }), "_"))
+	runes = append(append([]rune{'_'}, runes...), '_')
+	for i := 0; i+3 <= len(runes); i++ {
+		counts[string(runes[i:i+3])]++
+	}
+
+	best, bestScore, second := "", 0.0, 0.0
+	for lang, profile := range trigramProfiles {
+		score := 0.0
+		for rank, tri := range profile {
+			score += float64(counts[tri]) * float64(len(profile)-rank)
+		}
+		if score > bestScore {
+			best, bestScore, second = lang, score, bestScore
+		} else if score > second {
+			second = score
+		}
+	}
+	// Demand a clear winner; close calls are usually mixed or unlisted
+	// languages.
+	if bestScore == 0 || bestScore < 1.1*second {
+		return ""
+	}
+	return best
+}
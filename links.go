@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// ----- Links -----
+
+// MaxAnchorChars cuts off anchors that wrap whole teasers.
+const MaxAnchorChars = 200
+
+// MaxLinkContextChars bounds the text kept around a link. Links with
+// fewer than MinLinkContextChars of other text around them, as in menus
+// and link lists, get no context.
+const (
+	MaxLinkContextChars = 300
+	MinLinkContextChars = 20
+)
+
+// Link is an outgoing anchor, resolved against the page and normalized.
+// AnchorText falls back to the alt text of a linked image; Context is the
+// text around the link in its paragraph or list item, which often
+// describes the target better than the anchor does. Internal is set for
+// links to the page's own host.
+type Link struct {
+	URL        string `bson:"url"`
+	AnchorText string `bson:"anchor_text,omitempty"`
+	Context    string `bson:"context,omitempty"`
+	Rel        string `bson:"rel,omitempty"`
+	Internal   bool   `bson:"internal"`
+}
+
+// newLink builds a Link from an <a>'s attributes and collapsed text. URL
+// is empty when href isn't an http(s) link (mailto:, javascript:, "#");
+// dedupeLinks drops those.
+func newLink(href, rel, anchor string, base *url.URL) Link {
+	l := Link{
+		AnchorText: safeUTF8(truncateRunes(anchor, MaxAnchorChars)),
+		Rel:        strings.TrimSpace(rel),
+	}
+	if u, err := normalizeURL(base, href); err == nil {
+		l.URL = safeUTF8(u.String())
+		l.Internal = u.Hostname() == base.Hostname()
+	}
+	return l
+}
+
+// dedupeLinks keeps one Link per URL, the first, taking its anchor text
+// and context from a later copy if it has none (an icon link followed by
+// a text link to the same place). Repeated navigation and footer links
+// would otherwise each be stored and enqueued, and counted as separate
+// inlinks.
+func dedupeLinks(links []Link) []Link {
+	seen := make(map[string]int, len(links))
+	out := links[:0]
+	for _, l := range links {
+		if l.URL == "" {
+			continue
+		}
+		if i, ok := seen[l.URL]; ok {
+			if out[i].AnchorText == "" {
+				out[i].AnchorText = l.AnchorText
+			}
+			if out[i].Context == "" {
+				out[i].Context = l.Context
+			}
+			continue
+		}
+		seen[l.URL] = len(out)
+		out = append(out, l)
+	}
+	return out
+}
+
+type linkPolicy int
+
+const (
+	linkFollow linkPolicy = iota // store and enqueue
+	linkRecord                   // store in the link graph, don't enqueue
+	linkSkip                     // drop entirely
+)
+
+// linkPolicies maps rel tokens (nofollow, ugc, sponsored) to a policy.
+type linkPolicies map[string]linkPolicy
+
+// linkPoliciesFromEnv reads LINK_POLICY_NOFOLLOW, LINK_POLICY_UGC and
+// LINK_POLICY_SPONSORED; each is follow, record or skip (default record).
+func linkPoliciesFromEnv() linkPolicies {
+	p := linkPolicies{}
+	for _, rel := range []string{"nofollow", "ugc", "sponsored"} {
+		switch strings.ToLower(getEnv("LINK_POLICY_"+strings.ToUpper(rel), "record")) {
+		case "follow":
+			p[rel] = linkFollow
+		case "skip":
+			p[rel] = linkSkip
+		default:
+			p[rel] = linkRecord
+		}
+	}
+	return p
+}
+
+// forRel applies the strictest policy among the tokens of a rel attribute.
+func (p linkPolicies) forRel(rel string) linkPolicy {
+	policy := linkFollow
+	for _, tok := range strings.Fields(strings.ToLower(rel)) {
+		if lp, ok := p[tok]; ok && lp > policy {
+			policy = lp
+		}
+	}
+	return policy
+}
+
+// applyLinkPolicies drops skipped links and returns those to enqueue.
+func applyLinkPolicies(p linkPolicies, links []Link) (kept, follow []Link) {
+	for _, l := range links {
+		switch p.forRel(l.Rel) {
+		case linkFollow:
+			kept = append(kept, l)
+			follow = append(follow, l)
+		case linkRecord:
+			kept = append(kept, l)
+		}
+	}
+	return kept, follow
+}
+
+// linkContext cuts the text around anchor out of block, the cleaned text
+// of the block element containing the link: all of it if short enough,
+// else a window of MaxLinkContextChars centered on the anchor and trimmed
+// to whole words.
+func linkContext(block, anchor string) string {
+	if anchor == "" || len(block)-len(anchor) < MinLinkContextChars || len(anchor) >= MaxLinkContextChars {
+		return ""
+	}
+	if len(block) <= MaxLinkContextChars {
+		return safeUTF8(block)
+	}
+	i := strings.Index(block, anchor)
+	if i < 0 {
+		return ""
+	}
+	half := (MaxLinkContextChars - len(anchor)) / 2
+	start, end := max(i-half, 0), min(i+len(anchor)+half, len(block))
+	if start > 0 {
+		if sp := strings.IndexByte(block[start:i], ' '); sp >= 0 {
+			start += sp + 1
+		} else {
+			start = i
+		}
+	}
+	if end < len(block) {
+		if sp := strings.LastIndexByte(block[i+len(anchor):end], ' '); sp >= 0 {
+			end = i + len(anchor) + sp
+		} else {
+			end = i + len(anchor)
+		}
+	}
+	return safeUTF8(block[start:end])
+}
+
+// blockTexts caches the text of block elements for linkContext; the links
+// of a list or paragraph share one.
+type blockTexts map[*html.Node]string
+
+// context is linkContext for the <a> a, read from its nearest block-level
+// ancestor.
+func (b blockTexts) context(a *html.Node, anchor string) string {
+	for p := a.Parent; p != nil; p = p.Parent {
+		if p.Type != html.ElementNode || !blockTags[p.Data] && p.Data != "body" {
+			continue
+		}
+		text, ok := b[p]
+		if !ok {
+			text = nodesText(p)
+			b[p] = text
+		}
+		return linkContext(text, anchor)
+	}
+	return ""
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/realutkarshh/mini-search-crawler/index"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Indexing as pages are crawled -----
+
+// liveIndex keeps the search index in INDEX_DIR current during a crawl:
+// each stored page is indexed straight after upsertPage, replacing its
+// previous version, and the index is saved at checkpoints and when the
+// run ends. In the background, segments are merged every
+// INDEX_MERGE_EVERY, so the many small ones a crawl flushes don't slow
+// queries down.
+//
+// With SEARCH_BACKEND=bleve or sqlite, pages go to the Bleve or SQLite
+// index instead, which writes each change through and merges on its own.
+type liveIndex struct {
+	dir  string
+	stop chan struct{}
+	done chan struct{}
+
+	mu      sync.Mutex
+	ix      *index.Index
+	ext     externalIndex // in place of ix
+	pending int           // changes since the last save
+}
+
+// externalIndex is a search backend the crawler writes pages to itself.
+type externalIndex interface {
+	Add(d index.Document) error
+	Delete(url string) error
+	Close() error
+}
+
+// DefaultMergeEvery is how often the live index looks for segments to
+// merge.
+const DefaultMergeEvery = time.Minute
+
+// openLiveIndex loads the index in dir, or starts an empty one with the
+// configured analyzers if there is none yet.
+func openLiveIndex(dir string) (*liveIndex, error) {
+	var ext externalIndex
+	var err error
+	switch {
+	case bleveBackend():
+		dir = bleveDir()
+		ext, err = openBleve(dir, true, false)
+	case sqliteBackend():
+		dir = sqlitePath()
+		ext, err = openFTS(dir, false)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ext != nil {
+		l := &liveIndex{dir: dir, ext: ext, stop: make(chan struct{}), done: make(chan struct{})}
+		close(l.done)
+		return l, nil
+	}
+	ix, err := index.Open(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		ix, err = index.Create(dir, indexConfig())
+	}
+	if err != nil {
+		return nil, err
+	}
+	l := &liveIndex{dir: dir, ix: ix, stop: make(chan struct{}), done: make(chan struct{})}
+	go l.compact(getEnvDuration("INDEX_MERGE_EVERY", DefaultMergeEvery))
+	return l, nil
+}
+
+// compact runs the merges the index's merge policy calls for, one at a
+// time, until close. A merge is written without holding the lock, so
+// indexing goes on meanwhile.
+func (l *liveIndex) compact(every time.Duration) {
+	defer close(l.done)
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-tick.C:
+		}
+		for {
+			l.mu.Lock()
+			m := l.ix.PlanMerge()
+			l.mu.Unlock()
+			if m == nil {
+				break
+			}
+			err := m.Run()
+			l.mu.Lock()
+			if cerr := m.Commit(); err == nil {
+				err = cerr
+			}
+			l.pending++
+			l.mu.Unlock()
+			if err != nil {
+				log.Printf("index: merge: %v", err)
+				break
+			}
+		}
+	}
+}
+
+// update indexes p with the links to it from the stored pages. The
+// anchors and inlink count are those of the moment; links found later
+// reach the index when p is recrawled or the index rebuilt.
+func (l *liveIndex) update(ctx context.Context, store Store, p Page) {
+	in, err := store.LinksTo(ctx, p.URL)
+	if err != nil {
+		log.Printf("index: links to %s: %v", p.URL, err)
+	}
+	doc := indexDocument(p, in)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ext != nil {
+		err = l.ext.Add(doc)
+	} else {
+		_, err = l.ix.Add(doc)
+	}
+	if err != nil {
+		log.Printf("index: %s: %v", p.URL, err)
+	}
+	l.pending++
+}
+
+// remove drops pageURL from the index, as when a page turns noindex or
+// into a duplicate.
+func (l *liveIndex) remove(pageURL string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ext != nil {
+		if err := l.ext.Delete(pageURL); err != nil {
+			log.Printf("index: %s: %v", pageURL, err)
+		}
+		return
+	}
+	if l.ix.Delete(pageURL) {
+		l.pending++
+	}
+}
+
+// save writes the index if it changed since the last save.
+func (l *liveIndex) save() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.pending == 0 || l.ext != nil {
+		return nil
+	}
+	if err := l.ix.Save(l.dir); err != nil {
+		return err
+	}
+	log.Printf("index: %d changes saved, %d documents", l.pending, l.ix.Len())
+	l.pending = 0
+	return nil
+}
+
+// close stops merging, waiting for a merge under way, and closes the
+// index.
+func (l *liveIndex) close() error {
+	close(l.stop)
+	<-l.done
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.ext != nil {
+		return l.ext.Close()
+	}
+	return l.ix.Close()
+}
+
+// linksTo is inboundLinks for one page: the distinct anchor texts of
+// links to pageURL from other stored pages, up to MaxAnchors, and how
+// many pages those are.
+func linksTo(ctx context.Context, col *mongo.Collection, pageURL string) (inbound, error) {
+	filter := bson.M{"links.url": pageURL, "url": bson.M{"$ne": pageURL}}
+	pages, err := col.CountDocuments(ctx, filter)
+	if err != nil {
+		return inbound{}, err
+	}
+	cur, err := col.Find(ctx, filter, options.Find().SetProjection(bson.M{"links.$": 1}).SetLimit(MaxAnchors))
+	if err != nil {
+		return inbound{pages: int(pages)}, err
+	}
+	defer cur.Close(ctx)
+
+	in := inbound{pages: int(pages)}
+	seen := make(map[string]bool)
+	for cur.Next(ctx) {
+		var p Page
+		if err := cur.Decode(&p); err != nil {
+			continue
+		}
+		for _, l := range p.Links {
+			if key := strings.ToLower(l.AnchorText); l.URL == pageURL && key != "" && !seen[key] {
+				seen[key] = true
+				in.anchors = append(in.anchors, l.AnchorText)
+			}
+		}
+	}
+	return in, cur.Err()
+}
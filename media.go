@@ -0,0 +1,179 @@
+package main
+
+import (
+	"cmp"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Video and audio -----
+
+const MaxMedia = 20
+
+// MediaItem is a video or audio on a page. Embedded players from known
+// hosts are recorded by Provider and ID rather than player URL. Duration is
+// in seconds, 0 if not declared.
+type MediaItem struct {
+	Kind     string `bson:"kind"` // "video" or "audio"
+	URL      string `bson:"url,omitempty"`
+	Type     string `bson:"type,omitempty"`     // MIME type, if declared
+	Provider string `bson:"provider,omitempty"` // "youtube", "vimeo"
+	ID       string `bson:"id,omitempty"`       // the provider's video ID
+	Duration int    `bson:"duration,omitempty"`
+	Width    int    `bson:"width,omitempty"`
+	Height   int    `bson:"height,omitempty"`
+	Poster   string `bson:"poster,omitempty"`
+}
+
+var (
+	youtubeRE = regexp.MustCompile(`(?:youtube(?:-nocookie)?\.com/(?:embed|v|shorts)/|youtu\.be/)([A-Za-z0-9_-]{11})`)
+	vimeoRE   = regexp.MustCompile(`player\.vimeo\.com/video/(\d+)`)
+	isoDurRE  = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+)
+
+// embeddedVideo recognizes YouTube and Vimeo player URLs.
+func embeddedVideo(src string) (provider, id string, ok bool) {
+	if m := youtubeRE.FindStringSubmatch(src); m != nil {
+		return "youtube", m[1], true
+	}
+	if m := vimeoRE.FindStringSubmatch(src); m != nil {
+		return "vimeo", m[1], true
+	}
+	return "", "", false
+}
+
+// parseDuration reads an ISO 8601 duration (PT4M13S, as schema.org uses)
+// or a plain number of seconds (as video:duration does).
+func parseDuration(s string) int {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.ParseFloat(s, 64); err == nil && n > 0 {
+		return int(n)
+	}
+	m := isoDurRE.FindStringSubmatch(strings.ToUpper(s))
+	if m == nil {
+		return 0
+	}
+	secs := 0.0
+	for i, unit := range []float64{86400, 3600, 60, 1} {
+		if v, err := strconv.ParseFloat(m[i+1], 64); err == nil {
+			secs += v * unit
+		}
+	}
+	return int(secs)
+}
+
+// extractMedia collects og:video and og:audio, <video> and <audio>
+// elements with their <source>s, YouTube and Vimeo iframes, and durations
+// from video:duration or JSON-LD VideoObject. Each file or embedded video
+// is listed once; later mentions fill in what earlier ones left out.
+func extractMedia(doc *goquery.Document, base *url.URL) []MediaItem {
+	var media []MediaItem
+	seen := make(map[string]int)
+	add := func(m MediaItem) {
+		if provider, id, ok := embeddedVideo(m.URL); ok {
+			m.Provider, m.ID, m.URL = provider, id, ""
+		}
+		key := m.URL
+		if m.ID != "" {
+			key = m.Provider + ":" + m.ID
+		}
+		if i, ok := seen[key]; ok {
+			cur := &media[i]
+			cur.Type = cmp.Or(cur.Type, m.Type)
+			cur.Duration = cmp.Or(cur.Duration, m.Duration)
+			cur.Width, cur.Height = cmp.Or(cur.Width, m.Width), cmp.Or(cur.Height, m.Height)
+			cur.Poster = cmp.Or(cur.Poster, m.Poster)
+			return
+		}
+		if key == "" || len(media) >= MaxMedia {
+			return
+		}
+		seen[key] = len(media)
+		m.URL, m.Type = safeUTF8(m.URL), safeUTF8(m.Type)
+		media = append(media, m)
+	}
+	meta := func(prop string) string {
+		return doc.Find(`meta[property="`+prop+`"]`).First().AttrOr("content", "")
+	}
+
+	for _, kind := range []string{"video", "audio"} {
+		src := meta("og:" + kind + ":secure_url")
+		if src == "" {
+			src = meta("og:" + kind)
+		}
+		if src == "" {
+			src = meta("og:" + kind + ":url")
+		}
+		if src != "" {
+			add(MediaItem{
+				Kind:     kind,
+				URL:      resolveRef(base, src),
+				Type:     meta("og:" + kind + ":type"),
+				Width:    pixels(meta("og:" + kind + ":width")),
+				Height:   pixels(meta("og:" + kind + ":height")),
+				Duration: parseDuration(meta(kind + ":duration")),
+			})
+		}
+	}
+
+	doc.Find("video, audio").Each(func(_ int, s *goquery.Selection) {
+		item := MediaItem{
+			Kind:   goquery.NodeName(s),
+			Width:  pixels(s.AttrOr("width", "")),
+			Height: pixels(s.AttrOr("height", "")),
+		}
+		if poster := s.AttrOr("poster", ""); poster != "" {
+			item.Poster = resolveRef(base, poster)
+		}
+		if src := s.AttrOr("src", ""); src != "" {
+			item.URL = resolveRef(base, src)
+			add(item)
+		}
+		s.Find("source[src]").Each(func(_ int, src *goquery.Selection) {
+			source := item
+			source.URL = resolveRef(base, src.AttrOr("src", ""))
+			source.Type = src.AttrOr("type", "")
+			add(source)
+		})
+	})
+
+	doc.Find("iframe[src], embed[src]").Each(func(_ int, s *goquery.Selection) {
+		src := resolveRef(base, s.AttrOr("src", ""))
+		if _, _, ok := embeddedVideo(src); ok {
+			add(MediaItem{Kind: "video", URL: src, Width: pixels(s.AttrOr("width", "")), Height: pixels(s.AttrOr("height", ""))})
+		}
+	})
+
+	// JSON-LD VideoObjects carry the duration, and the only URL for
+	// players that are inserted by script.
+	for _, node := range jsonLD(doc) {
+		for _, typ := range ldTypes(node["@type"]) {
+			if typ != "VideoObject" && typ != "AudioObject" {
+				continue
+			}
+			kind := "video"
+			if typ == "AudioObject" {
+				kind = "audio"
+			}
+			item := MediaItem{
+				Kind:     kind,
+				Type:     ldString(node["encodingFormat"]),
+				Duration: parseDuration(ldString(node["duration"])),
+			}
+			if poster := ldURL(node["thumbnailUrl"]); poster != "" {
+				item.Poster = resolveRef(base, poster)
+			}
+			for _, src := range []string{ldURL(node["contentUrl"]), ldURL(node["embedUrl"])} {
+				if src != "" {
+					item.URL = resolveRef(base, src)
+					add(item)
+				}
+			}
+		}
+	}
+	return media
+}
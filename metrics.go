@@ -0,0 +1,46 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// ----- Content metrics -----
+
+// ReadingWPM is the adult silent reading speed reading time is based on.
+const ReadingWPM = 238
+
+// countWords counts whitespace-separated words, and CJK characters one
+// each, since those scripts don't space words apart.
+func countWords(text string) int {
+	n := 0
+	for _, f := range strings.Fields(text) {
+		cjk, other := 0, false
+		for _, r := range f {
+			if unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul) {
+				cjk++
+			} else if unicode.IsLetter(r) || unicode.IsDigit(r) {
+				other = true
+			}
+		}
+		n += cjk
+		if other {
+			n++
+		}
+	}
+	return n
+}
+
+// setMetrics fills the page's size and quality signals: word count and
+// reading time of the main text, and the share of the HTML that is text.
+// htmlBytes is the size of the decoded HTML, 0 for other documents.
+func (p *Page) setMetrics(htmlBytes int) {
+	p.WordCount = countWords(p.MainText)
+	if p.WordCount > 0 {
+		p.ReadingMinutes = int(math.Ceil(float64(p.WordCount) / ReadingWPM))
+	}
+	if htmlBytes > 0 {
+		p.TextRatio = math.Round(float64(len(p.Text))/float64(htmlBytes)*1000) / 1000
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// ----- Microdata and RDFa -----
+
+// markupSyntax describes one attribute-based annotation syntax: which
+// elements start an item, what their type is, and which properties an
+// element sets.
+type markupSyntax struct {
+	source string
+	top    string // selector for items that aren't a property of another item
+	scope  func(n *html.Node) bool
+	types  func(n *html.Node) string
+	props  func(n *html.Node) string
+	value  func(n *html.Node) any
+}
+
+var microdataSyntax = markupSyntax{
+	source: "microdata",
+	top:    "[itemscope]:not([itemprop])",
+	scope:  func(n *html.Node) bool { _, ok := nodeAttr(n, "itemscope"); return ok },
+	types:  func(n *html.Node) string { t, _ := nodeAttr(n, "itemtype"); return t },
+	props:  func(n *html.Node) string { p, _ := nodeAttr(n, "itemprop"); return p },
+	value:  microdataValue,
+}
+
+// rdfaSyntax covers RDFa Lite (typeof/property), the subset schema.org
+// documents; prefixes are dropped by schemaTerm rather than resolved.
+var rdfaSyntax = markupSyntax{
+	source: "rdfa",
+	top:    "[typeof]:not([property])",
+	scope:  func(n *html.Node) bool { _, ok := nodeAttr(n, "typeof"); return ok },
+	types:  func(n *html.Node) string { t, _ := nodeAttr(n, "typeof"); return t },
+	props:  func(n *html.Node) string { p, _ := nodeAttr(n, "property"); return p },
+	value:  rdfaValue,
+}
+
+// markupNodes decodes doc's items in syntax into the same shape as JSON-LD
+// nodes ("@type" plus properties), so StructuredData.add handles all three.
+func markupNodes(doc *goquery.Document, syntax markupSyntax) []map[string]any {
+	var nodes []map[string]any
+	doc.Find(syntax.top).Each(func(_ int, s *goquery.Selection) {
+		nodes = append(nodes, syntax.item(s.Get(0)))
+	})
+	return nodes
+}
+
+func (m markupSyntax) item(n *html.Node) map[string]any {
+	node := make(map[string]any)
+	if types := strings.Fields(m.types(n)); len(types) > 0 {
+		list := make([]any, len(types))
+		for i, t := range types {
+			list[i] = t
+		}
+		node["@type"] = list
+	}
+	m.collect(n, node)
+	return node
+}
+
+// collect adds the properties set below n to node, descending into nested
+// items only to read them as property values.
+func (m markupSyntax) collect(n *html.Node, node map[string]any) {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.ElementNode {
+			continue
+		}
+		names := strings.Fields(m.props(c))
+		if len(names) > 0 {
+			var v any
+			if m.scope(c) {
+				v = m.item(c)
+			} else {
+				v = m.value(c)
+			}
+			for _, name := range names {
+				addProp(node, schemaTerm(name), v)
+			}
+		}
+		if !m.scope(c) {
+			m.collect(c, node)
+		}
+	}
+}
+
+// addProp sets a property, turning repeats into a list as JSON-LD would.
+func addProp(node map[string]any, name string, v any) {
+	switch cur := node[name].(type) {
+	case nil:
+		node[name] = v
+	case []any:
+		node[name] = append(cur, v)
+	default:
+		node[name] = []any{cur, v}
+	}
+}
+
+// microdataValue is an element's property value per the HTML spec: an
+// attribute for URL-, time- and value-carrying elements, else its text.
+func microdataValue(n *html.Node) any {
+	attr := ""
+	switch n.Data {
+	case "meta":
+		attr = "content"
+	case "a", "area", "link":
+		attr = "href"
+	case "img", "audio", "video", "source", "track", "iframe", "embed":
+		attr = "src"
+	case "object":
+		attr = "data"
+	case "time":
+		attr = "datetime"
+	case "data", "meter":
+		attr = "value"
+	}
+	if v, ok := nodeAttr(n, attr); ok {
+		return v
+	}
+	return nodesText(n)
+}
+
+// rdfaValue prefers content, then the link or time attributes, then text.
+func rdfaValue(n *html.Node) any {
+	for _, attr := range []string{"content", "href", "src", "resource", "datetime"} {
+		if v, ok := nodeAttr(n, attr); ok {
+			return v
+		}
+	}
+	return nodesText(n)
+}
+
+func nodeAttr(n *html.Node, key string) (string, bool) {
+	if key == "" {
+		return "", false
+	}
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/realutkarshh/mini-search-crawler/analysis"
+	"github.com/realutkarshh/mini-search-crawler/index"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Mongo text search -----
+
+// MongoTextIndex names the text index on the pages collection. Mongo
+// allows one per collection.
+const MongoTextIndex = "page_text"
+
+// mongoTextWeights weigh the indexed page fields like index.DefaultBoosts
+// weigh title, snippet and body, doubled as Mongo wants integers.
+var mongoTextWeights = bson.D{
+	{Key: "title", Value: 6},
+	{Key: "snippet", Value: 3},
+	{Key: "text", Value: 2},
+}
+
+// mongoNumeric maps the index's numeric fields to the page fields they
+// come from. Inlinks are counted only when the index is built.
+var mongoNumeric = map[string]string{
+	index.NumCrawled:   "crawl_time",
+	index.NumPublished: "published_at",
+	index.NumWords:     "word_count",
+}
+
+// mongoText searches the pages collection through its text index, for
+// deployments that would rather not keep an index of their own. Mongo
+// matches pages with any of the query's words, ranked by its text score,
+// where the index wants them all; quoted phrases must appear as written
+// and "-word" leaves pages out. Wildcards are dropped. Every page is
+// stemmed in INDEX_LANGUAGE: pages' own languages name languages Mongo
+// may not know, failing their writes, so the index doesn't follow them.
+type mongoText struct {
+	client *mongo.Client
+	pages  *mongo.Collection
+}
+
+// openMongoText connects to MONGO_URI and makes sure the text index is
+// there. Creating it on a large collection takes a while the first time.
+func openMongoText(ctx context.Context) (*mongoText, error) {
+	client, db, err := connectMongo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	pages := db.Collection("pages")
+	if err := ensureTextIndex(ctx, pages); err != nil {
+		client.Disconnect(context.Background())
+		return nil, err
+	}
+	return &mongoText{client: client, pages: pages}, nil
+}
+
+// ensureTextIndex creates the text index on pages unless it exists.
+// Changing the weights or language needs the old one dropped first.
+func ensureTextIndex(ctx context.Context, pages *mongo.Collection) error {
+	keys := bson.D{}
+	for _, w := range mongoTextWeights {
+		keys = append(keys, bson.E{Key: w.Key, Value: "text"})
+	}
+	opts := options.Index().
+		SetName(MongoTextIndex).
+		SetWeights(mongoTextWeights).
+		SetDefaultLanguage(getEnv("INDEX_LANGUAGE", analysis.DefaultLang)).
+		SetLanguageOverride("text_language") // a field pages don't have
+	if _, err := pages.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts}); err != nil {
+		return fmt.Errorf("search: text index: %w", err)
+	}
+	return nil
+}
+
+// mongoSearchBackend reports whether SEARCH_BACKEND picks mongoText, whose
+// index every start then creates, so crawls keep it up to date.
+func mongoSearchBackend() bool {
+	return strings.EqualFold(getEnv("SEARCH_BACKEND", "index"), "mongo")
+}
+
+// textQuery turns a query into $text search syntax, which shares its
+// quoting but has no wildcards.
+func textQuery(q string) string {
+	parts := strings.Split(q, `"`)
+	for i := 0; i < len(parts); i += 2 {
+		parts[i] = strings.NewReplacer("*", "", "?", "").Replace(parts[i])
+	}
+	if len(parts)%2 == 0 {
+		parts = append(parts, "") // close an unclosed quote
+	}
+	return strings.Join(parts, `"`)
+}
+
+// textFilter is the filter for a $text search narrowed down by opts.
+// Ranges of fields pages don't store are an error.
+func textFilter(q string, opts index.Options) (bson.M, error) {
+	filter := bson.M{"$text": bson.M{"$search": textQuery(q)}}
+	if opts.Lang != "" {
+		filter["lang"] = opts.Lang
+	}
+	for _, r := range opts.Ranges {
+		field, ok := mongoNumeric[r.Field]
+		if !ok {
+			return nil, fmt.Errorf("search: the mongo backend can't filter by %s", r.Field)
+		}
+		value := func(v int64) any {
+			if field == "word_count" {
+				return v
+			}
+			return time.Unix(v, 0).UTC()
+		}
+		// As in the index, a page without a value is outside every range.
+		cond := bson.M{"$gt": value(0)}
+		if r.Min > 0 {
+			cond = bson.M{"$gte": value(r.Min)}
+		}
+		if r.Max != 0 {
+			cond["$lte"] = value(r.Max)
+		}
+		filter[field] = cond
+	}
+	return filter, nil
+}
+
+// textPage is what a search reads of a page.
+type textPage struct {
+	URL         string    `bson:"url"`
+	Title       string    `bson:"title"`
+	Snippet     string    `bson:"snippet"`
+	Favicon     string    `bson:"favicon"`
+	Image       string    `bson:"image"`
+	Lang        string    `bson:"lang"`
+	CrawlTime   time.Time `bson:"crawl_time"`
+	PublishedAt time.Time `bson:"published_at"`
+	WordCount   int       `bson:"word_count"`
+	Score       float64   `bson:"score"`
+}
+
+func (s *mongoText) Search(ctx context.Context, query string, limit int, opts index.Options) ([]index.Result, error) {
+	filter, err := textFilter(query, opts)
+	if err != nil {
+		return nil, err
+	}
+	score := bson.M{"$meta": "textScore"}
+	sort := bson.D{{Key: "score", Value: score}}
+	if opts.Sort != "" {
+		field, ok := mongoNumeric[opts.Sort]
+		if !ok {
+			return nil, fmt.Errorf("search: the mongo backend can't sort by %s", opts.Sort)
+		}
+		sort = append(bson.D{{Key: field, Value: -1}}, sort...)
+	}
+	find := options.Find().
+		SetProjection(bson.M{"url": 1, "title": 1, "snippet": 1, "favicon": 1, "image": 1, "lang": 1, "crawl_time": 1, "published_at": 1, "word_count": 1, "score": score}).
+		SetSort(sort).
+		SetLimit(int64(limit))
+	cur, err := s.pages.Find(ctx, filter, find)
+	if err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	var pages []textPage
+	if err := cur.All(ctx, &pages); err != nil {
+		return nil, fmt.Errorf("search: %w", err)
+	}
+	results := make([]index.Result, len(pages))
+	for i, p := range pages {
+		results[i] = index.Result{
+			DocInfo: index.DocInfo{
+				URL:     p.URL,
+				Title:   p.Title,
+				Snippet: p.Snippet,
+				Favicon: p.Favicon,
+				Image:   p.Image,
+				Lang:    p.Lang,
+				Values: map[string]int64{
+					index.NumCrawled:   unixOrZero(p.CrawlTime),
+					index.NumPublished: unixOrZero(p.PublishedAt),
+					index.NumWords:     int64(p.WordCount),
+				},
+			},
+			Score: p.Score,
+		}
+	}
+	return results, nil
+}
+
+func (s *mongoText) Close() error { return s.client.Disconnect(context.Background()) }
+
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
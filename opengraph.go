@@ -0,0 +1,82 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Open Graph and Twitter Cards -----
+
+// SocialCard is a page's Open Graph and Twitter Card metadata, as the page
+// states it, so result cards can be rendered without refetching. Fields the
+// page doesn't set are empty; Twitter fields are not filled in from their
+// Open Graph counterparts.
+type SocialCard struct {
+	Type        string `bson:"type,omitempty"` // og:type: website, article, video.movie, ...
+	Title       string `bson:"title,omitempty"`
+	Description string `bson:"description,omitempty"`
+	URL         string `bson:"url,omitempty"`
+	SiteName    string `bson:"site_name,omitempty"`
+	Locale      string `bson:"locale,omitempty"`
+	Image       string `bson:"image,omitempty"`
+	ImageAlt    string `bson:"image_alt,omitempty"`
+	ImageWidth  int    `bson:"image_width,omitempty"`
+	ImageHeight int    `bson:"image_height,omitempty"`
+
+	TwitterCard        string `bson:"twitter_card,omitempty"` // summary, summary_large_image, player, app
+	TwitterSite        string `bson:"twitter_site,omitempty"`
+	TwitterCreator     string `bson:"twitter_creator,omitempty"`
+	TwitterTitle       string `bson:"twitter_title,omitempty"`
+	TwitterDescription string `bson:"twitter_description,omitempty"`
+	TwitterImage       string `bson:"twitter_image,omitempty"`
+}
+
+// extractSocialCard reads og:* (by property, or by name as some sites
+// write them) and twitter:* meta tags; the first of each wins, and URLs are
+// made absolute against base. It returns nil if the page has neither.
+func extractSocialCard(doc *goquery.Document, base *url.URL) *SocialCard {
+	var card SocialCard
+	fields := map[string]*string{
+		"og:type": &card.Type, "og:title": &card.Title, "og:description": &card.Description,
+		"og:url": &card.URL, "og:site_name": &card.SiteName, "og:locale": &card.Locale,
+		"og:image": &card.Image, "og:image:secure_url": &card.Image, "og:image:alt": &card.ImageAlt,
+		"twitter:card": &card.TwitterCard, "twitter:site": &card.TwitterSite,
+		"twitter:creator": &card.TwitterCreator, "twitter:title": &card.TwitterTitle,
+		"twitter:description": &card.TwitterDescription, "twitter:image": &card.TwitterImage,
+		"twitter:image:src": &card.TwitterImage,
+	}
+	found := false
+	doc.Find("meta[property], meta[name]").Each(func(_ int, s *goquery.Selection) {
+		key := strings.ToLower(strings.TrimSpace(s.AttrOr("property", s.AttrOr("name", ""))))
+		content := safeUTF8(cleanText(s.AttrOr("content", "")))
+		if content == "" {
+			return
+		}
+		switch key {
+		case "og:image:width":
+			if card.ImageWidth == 0 {
+				card.ImageWidth = pixels(content)
+			}
+		case "og:image:height":
+			if card.ImageHeight == 0 {
+				card.ImageHeight = pixels(content)
+			}
+		default:
+			if dst, ok := fields[key]; ok && *dst == "" {
+				*dst = content
+			} else if !ok {
+				return
+			}
+		}
+		found = true
+	})
+	if !found {
+		return nil
+	}
+	card.URL = resolveRef(base, card.URL)
+	card.Image = resolveRef(base, card.Image)
+	card.TwitterImage = resolveRef(base, card.TwitterImage)
+	return &card
+}
@@ -0,0 +1,517 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// ----- HTML parsing -----
+
+// htmlParser picks how fetched HTML is turned into a Page. HTML_PARSER=dom
+// (the default) builds a goquery document; HTML_PARSER=stream runs a single
+// tokenizer pass with no DOM in memory, which keeps large pages and many
+// workers cheap. Stream mode fills only the fields a search result needs:
+// title, snippet, favicon, image, text, main text, headings, links,
+// images, hreflang alternates and the restriction check. Article dates and
+// author, structured data, Open Graph, tags, media and breadcrumbs need
+// arbitrary selectors and stay empty: recrawling with it removes those the
+// DOM parser stored, and sorting or filtering by publication date passes
+// such pages over.
+type htmlParser string
+
+const (
+	parseDOM    htmlParser = "dom"
+	parseStream htmlParser = "stream"
+)
+
+func parserFromEnv() htmlParser {
+	if strings.EqualFold(getEnv("HTML_PARSER", ""), string(parseStream)) {
+		return parseStream
+	}
+	return parseDOM
+}
+
+// extraction is one parsed page: the Page to store plus the signals the
+// crawler acts on before storing it.
+type extraction struct {
+	Page      Page
+	Robots    []string // <meta name="robots"> and <meta name="basicsearchbot"> values
+	Canonical string   // normalized rel=canonical, or ""
+	Refresh   *url.URL // prompt meta-refresh target, or nil
+	HTMLLang  string   // <html lang>, as written
+}
+
+// extract parses res with p, or as its document type if it isn't HTML.
+// base is res.FinalURL, parsed.
+func (p htmlParser) extract(res *fetchResult, base *url.URL) (*extraction, error) {
+	var ex *extraction
+	switch {
+	case res.Type == "application/pdf":
+		var err error
+		if ex, err = pdfExtract(res.FinalURL, res.Body, base); err != nil {
+			return nil, err
+		}
+	case !isHTML(res.Type):
+		ex = textExtract(res.FinalURL, res.Body, base, isMarkdown(res.Type, base))
+	case p == parseStream:
+		ex = streamExtract(res.FinalURL, res.Body, base)
+	default:
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(res.Body))
+		if err != nil {
+			return nil, err
+		}
+		ex = domExtract(res.FinalURL, doc, base)
+	}
+	ex.Page.ContentType = res.Type
+	ex.Page.Links = dedupeLinks(ex.Page.Links)
+	ex.Page.Lang = pageLanguage(ex.HTMLLang, res.Meta.ContentLanguage, ex.Page.MainText)
+	if isHTML(res.Type) {
+		ex.Page.setMetrics(len(res.Body))
+	} else {
+		ex.Page.setMetrics(0)
+	}
+	return ex, nil
+}
+
+func domExtract(u string, doc *goquery.Document, base *url.URL) *extraction {
+	html := doc.Find("html").First()
+	ex := &extraction{
+		Page:      extractPage(u, doc),
+		Canonical: canonicalLink(doc, base),
+		Refresh:   metaRefresh(doc, base),
+		HTMLLang:  html.AttrOr("lang", html.AttrOr("xml:lang", "")),
+	}
+	doc.Find("meta[name]").Each(func(i int, s *goquery.Selection) {
+		if isRobotsMeta(s.AttrOr("name", "")) {
+			ex.Robots = append(ex.Robots, s.AttrOr("content", ""))
+		}
+	})
+	return ex
+}
+
+func isRobotsMeta(name string) bool {
+	name = strings.ToLower(name)
+	return name == "robots" || name == strings.ToLower(CrawlerName)
+}
+
+// streamExtract is extractPage, refresh and directive detection in one
+// pass over the token stream, for the fields htmlParser lists. Where the
+// DOM path takes the first matching element, so does this; body text
+// leaves out <head> and, like visibleText, hiddenTags, with whitespace
+// collapsed. Without a tree to score, main text is the body text outside
+// boilerplate elements.
+func streamExtract(u string, body []byte, base *url.URL) *extraction {
+	ex := &extraction{}
+	var (
+		title, desc, ogDesc      string
+		siteName, ogImg, twImg   string
+		favicon                  string
+		haveTitle, haveDesc      bool
+		haveOGDesc, haveSiteName bool
+		haveOGImg, haveTwImg     bool
+		haveRefresh              bool
+		paras                    []string // main-content paragraphs, for the snippet
+
+		text     strings.Builder
+		main     strings.Builder
+		open     []openElem       // unclosed elements, innermost last
+		boiler   int              // open boilerplate elements
+		cur      *strings.Builder // open <p> outside boilerplate
+		heading  *strings.Builder // open h1-h3
+		hTag     string
+		headings Headings
+		alts     []Alternate
+		images   []ImageInfo
+		figure   []int            // indexes into images of those in the open <figure>
+		caption  *strings.Builder // open <figcaption>
+		figCap   string           // the open <figure>'s caption, once read
+		inHead   bool
+		inTitle  bool
+		skip     int // depth inside hiddenTags
+		ldJSON   bool
+		declared bool
+		marked   bool
+		password bool
+		links    []Link
+		anchor   *strings.Builder // text of the open <a href>
+		block    strings.Builder  // text since the last block boundary
+		pending  []int            // indexes into links of those in block
+		alt      string           // first image alt inside it
+		aTitle   string
+		href     string
+		rel      string
+	)
+
+	endLink := func() {
+		links = append(links, streamLink(href, rel, anchor, cmp.Or(alt, aTitle), base))
+		pending = append(pending, len(links)-1)
+	}
+	// endBlock gives the links read since the last block boundary their
+	// context, as extractPage reads it from their block element.
+	endBlock := func() {
+		if len(pending) > 0 {
+			text := cleanText(block.String())
+			for _, i := range pending {
+				links[i].Context = linkContext(text, links[i].AnchorText)
+			}
+			pending = pending[:0]
+		}
+		block.Reset()
+	}
+
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+
+		switch tt {
+		case html.TextToken:
+			switch {
+			case inTitle:
+				if !haveTitle {
+					title += tok.Data
+				}
+			case ldJSON:
+				declared = declared || paywallFree.MatchString(tok.Data)
+			case skip > 0 || inHead:
+			default:
+				text.WriteString(tok.Data)
+				block.WriteString(tok.Data)
+				if heading != nil {
+					heading.WriteString(tok.Data)
+				}
+				if anchor != nil {
+					anchor.WriteString(tok.Data)
+				}
+				if caption != nil {
+					caption.WriteString(tok.Data)
+				}
+				if boiler == 0 {
+					main.WriteString(tok.Data)
+					if cur != nil {
+						cur.WriteString(tok.Data)
+					}
+				}
+			}
+
+		case html.EndTagToken:
+			if blockTags[tok.Data] {
+				space(&text, &main, cur, heading)
+				endBlock()
+			}
+			// Pop up to the matching element, closing any left unclosed.
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i].tag != tok.Data {
+					continue
+				}
+				for _, e := range open[i:] {
+					if e.boiler {
+						boiler--
+					}
+				}
+				open = open[:i]
+				break
+			}
+			switch tok.Data {
+			case "head":
+				inHead = false
+			case "title":
+				if inTitle {
+					inTitle, haveTitle = false, true
+				}
+			case "script", "style", "noscript", "template", "iframe":
+				if skip > 0 {
+					skip--
+				}
+				ldJSON = false
+			case "p":
+				paras, cur = closePara(paras, cur)
+			case "a":
+				if anchor != nil {
+					endLink()
+					anchor = nil
+				}
+			case "figcaption":
+				if caption != nil {
+					figCap = safeUTF8(cleanText(caption.String()))
+					for _, i := range figure {
+						if images[i].Caption == "" {
+							images[i].Caption = figCap
+						}
+					}
+					caption = nil
+				}
+			case "figure":
+				figure = nil
+			case "h1", "h2", "h3":
+				if heading != nil && tok.Data == hTag {
+					headings.add(hTag, cleanText(heading.String()))
+					heading = nil
+				}
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			attr := func(key string) (string, bool) {
+				for _, a := range tok.Attr {
+					if a.Key == key {
+						return a.Val, true
+					}
+				}
+				return "", false
+			}
+			class, _ := attr("class")
+			id, _ := attr("id")
+			marked = marked || paywallMarked(class, id)
+			opens := tt == html.StartTagToken && !voidElements[tok.Data]
+			if blockTags[tok.Data] {
+				space(&text, &main, cur, heading)
+				endBlock()
+			}
+			if opens {
+				e := openElem{tag: tok.Data, boiler: boilerplate(tok.Data, class, id)}
+				if e.boiler {
+					boiler++
+				}
+				open = append(open, e)
+			}
+
+			switch tok.Data {
+			case "html":
+				if ex.HTMLLang == "" {
+					lang, ok := attr("lang")
+					if !ok {
+						lang, _ = attr("xml:lang")
+					}
+					ex.HTMLLang = lang
+				}
+			case "head":
+				inHead = opens
+			case "body":
+				inHead = false
+			case "title":
+				inTitle = opens && !haveTitle
+			case "script":
+				typ, _ := attr("type")
+				if opens {
+					skip++
+					ldJSON = typ == "application/ld+json"
+				}
+			case "style", "noscript", "template", "iframe":
+				if opens {
+					skip++
+				}
+			case "p":
+				paras, cur = closePara(paras, cur)
+				if opens && boiler == 0 {
+					cur = &strings.Builder{}
+				}
+			case "h1", "h2", "h3":
+				if opens && !inHead {
+					heading, hTag = &strings.Builder{}, tok.Data
+				}
+			case "input":
+				if typ, _ := attr("type"); strings.EqualFold(typ, "password") {
+					password = true
+				}
+			case "a":
+				if anchor != nil {
+					// <a> doesn't nest; a new one closes the last.
+					endLink()
+					anchor = nil
+				}
+				if h, ok := attr("href"); ok && opens {
+					rel, _ = attr("rel")
+					aTitle, _ = attr("title")
+					anchor, href, alt = &strings.Builder{}, h, ""
+				}
+			case "img":
+				if a, ok := attr("alt"); ok && anchor != nil && alt == "" {
+					alt = a
+				}
+				if len(images) < MaxImages && !inHead {
+					get := func(a string) string { v, _ := attr(a); return v }
+					if info, ok := newImage(get, base); ok {
+						if figure != nil {
+							info.Caption = figCap
+							figure = append(figure, len(images))
+						}
+						images = append(images, info)
+					}
+				}
+			case "figure":
+				if opens {
+					figure, figCap = []int{}, ""
+				}
+			case "figcaption":
+				if opens {
+					caption = &strings.Builder{}
+				}
+			case "link":
+				rel, _ := attr("rel")
+				href, hasHref := attr("href")
+				if strings.Contains(strings.ToLower(rel), "icon") {
+					favicon = href
+				}
+				if hreflang, ok := attr("hreflang"); ok && len(alts) < MaxAlternates {
+					if a, ok := alternateLink(rel, hreflang, href, base); ok {
+						alts = append(alts, a)
+					}
+				}
+				if hasHref && ex.Canonical == "" {
+					for _, r := range strings.Fields(strings.ToLower(rel)) {
+						if r == "canonical" {
+							if cu, err := normalizeURL(base, href); err == nil {
+								ex.Canonical = cu.String()
+							}
+						}
+					}
+				}
+			case "meta":
+				content, hasContent := attr("content")
+				if name, ok := attr("name"); ok {
+					switch {
+					case name == "description" && !haveDesc:
+						desc, haveDesc = content, hasContent
+					case name == "twitter:image" && !haveTwImg:
+						twImg, haveTwImg = content, hasContent
+					case isRobotsMeta(name):
+						ex.Robots = append(ex.Robots, content)
+					}
+				}
+				switch prop, _ := attr("property"); {
+				case prop == "og:description" && !haveOGDesc:
+					ogDesc, haveOGDesc = content, hasContent
+				case prop == "og:site_name" && !haveSiteName:
+					siteName, haveSiteName = content, hasContent
+				case prop == "og:image" && !haveOGImg:
+					ogImg, haveOGImg = content, hasContent
+				}
+				if equiv, ok := attr("http-equiv"); ok && !haveRefresh &&
+					strings.EqualFold(strings.TrimSpace(equiv), "refresh") {
+					haveRefresh = true
+					ex.Refresh = refreshTarget(content, base)
+				}
+			}
+		}
+	}
+	paras, _ = closePara(paras, cur)
+	if anchor != nil {
+		endLink()
+	}
+	endBlock()
+
+	bodyText := cleanText(text.String())
+	mainTxt := cleanText(main.String())
+	snippet := cleanText(desc)
+	if snippet == "" {
+		snippet = cleanText(ogDesc)
+	}
+	if snippet == "" {
+		if len(paras) == 0 {
+			paras = []string{mainTxt}
+		}
+		snippet = summarize(paras)
+	}
+
+	if siteName = cleanText(siteName); siteName == "" {
+		siteName = base.Hostname()
+	}
+	img := ogImg
+	if img == "" {
+		img = twImg
+	}
+	if img == "" {
+		img = bestImage(images)
+	}
+
+	bodyText = truncateRunes(bodyText, MaxTextChars)
+	reason := classifyRestriction(declared, marked, password, bodyText)
+	ex.Page = Page{
+		URL:              u,
+		Title:            cleanText(title),
+		Snippet:          snippet,
+		Favicon:          resolveRef(base, favicon),
+		SiteName:         siteName,
+		Image:            resolveRef(base, img),
+		Text:             bodyText,
+		MainText:         truncateRunes(mainTxt, MaxTextChars),
+		Headings:         headings,
+		Alternates:       alts,
+		Images:           images,
+		Links:            links,
+		CrawlTime:        time.Now().UTC(),
+		Restricted:       reason != "",
+		RestrictedReason: reason,
+	}
+	return ex
+}
+
+type openElem struct {
+	tag    string
+	boiler bool
+}
+
+// voidElements never have an end tag.
+var voidElements = map[string]bool{
+	"area": true, "base": true, "br": true, "col": true, "embed": true, "hr": true,
+	"img": true, "input": true, "link": true, "meta": true, "source": true, "track": true, "wbr": true,
+}
+
+// streamLink finishes an <a>; fallback (image alt, else title) stands in
+// for an empty anchor, as in extractPage.
+func streamLink(href, rel string, anchor *strings.Builder, fallback string, base *url.URL) Link {
+	text := cleanText(anchor.String())
+	if text == "" {
+		text = cleanText(fallback)
+	}
+	return newLink(href, rel, text, base)
+}
+
+// space separates words across a block element boundary.
+func space(bs ...*strings.Builder) {
+	for _, b := range bs {
+		if b != nil {
+			b.WriteByte(' ')
+		}
+	}
+}
+
+// closePara ends the open paragraph cur, adding it to paras.
+func closePara(paras []string, cur *strings.Builder) ([]string, *strings.Builder) {
+	if cur == nil {
+		return paras, nil
+	}
+	if txt := cleanText(cur.String()); txt != "" && len(paras) < MaxSnippetCandidates {
+		paras = append(paras, txt)
+	}
+	return paras, nil
+}
+
+func truncateRunes(s string, n int) string {
+	if runes := []rune(s); len(runes) > n {
+		return string(runes[:n])
+	}
+	return s
+}
+
+// resolveRef makes ref absolute against base, leaving it as-is if it
+// doesn't parse.
+func resolveRef(base *url.URL, ref string) string {
+	if ref == "" {
+		return ""
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return base.ResolveReference(r).String()
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/ledongthuc/pdf"
+)
+
+// ----- PDF documents -----
+
+// pdfExtract builds a Page from a PDF: text from every page (up to
+// MaxTextChars), and title, author, subject, keywords and dates from the
+// document info. The PDF parser panics on some malformed files, which is
+// turned into an error.
+func pdfExtract(u string, body []byte, base *url.URL) (ex *extraction, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			ex, err = nil, fmt.Errorf("pdf: %v", r)
+		}
+	}()
+	r, err := pdf.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		return nil, fmt.Errorf("pdf: %w", err)
+	}
+
+	var text strings.Builder
+	fonts := make(map[string]*pdf.Font)
+	for i := 1; i <= r.NumPage() && text.Len() < 4*MaxTextChars; i++ {
+		p := r.Page(i)
+		if p.V.IsNull() {
+			continue
+		}
+		for _, name := range p.Fonts() {
+			if _, ok := fonts[name]; !ok {
+				f := p.Font(name)
+				fonts[name] = &f
+			}
+		}
+		// A page that fails to decode loses its text, not the document.
+		if t, err := p.GetPlainText(fonts); err == nil {
+			text.WriteString(t)
+			text.WriteByte('\n')
+		}
+	}
+	raw := text.String()
+	full := truncateRunes(cleanText(raw), MaxTextChars)
+
+	info := r.Trailer().Key("Info")
+	title := cleanText(info.Key("Title").Text())
+	if title == "" {
+		title = firstLine(raw)
+	}
+	if title == "" {
+		title = path.Base(base.Path)
+	}
+	snippet := cleanText(info.Key("Subject").Text())
+	if snippet == "" {
+		snippet = summarize([]string{full})
+	}
+
+	var tags []string
+	for _, k := range strings.FieldsFunc(info.Key("Keywords").Text(), func(r rune) bool { return r == ',' || r == ';' }) {
+		if k = strings.ToLower(cleanText(k)); k != "" && len(tags) < MaxTags {
+			tags = append(tags, k)
+		}
+	}
+	published, _ := pdfDate(info.Key("CreationDate").Text())
+	modified, _ := pdfDate(info.Key("ModDate").Text())
+
+	return &extraction{Page: Page{
+		URL:         u,
+		Title:       safeUTF8(title),
+		Snippet:     safeUTF8(snippet),
+		SiteName:    base.Hostname(),
+		Text:        safeUTF8(full),
+		MainText:    safeUTF8(full),
+		Tags:        tags,
+		CrawlTime:   time.Now().UTC(),
+		PublishedAt: published,
+		ModifiedAt:  modified,
+		Author:      safeUTF8(cleanText(info.Key("Author").Text())),
+	}}, nil
+}
+
+// firstLine is the first non-blank line of s, if short enough for a title.
+func firstLine(s string) string {
+	for _, line := range strings.Split(s, "\n") {
+		if line = cleanText(line); line != "" {
+			if len([]rune(line)) > MaxHeadingChars {
+				return ""
+			}
+			return line
+		}
+	}
+	return ""
+}
+
+// pdfDate parses the PDF date format, D:YYYYMMDDHHmmSSOHH'mm', of which
+// everything after the year is optional.
+func pdfDate(s string) (time.Time, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "D:")
+	s = strings.ReplaceAll(strings.TrimSuffix(s, "'"), "'", "")
+	for _, layout := range []string{"20060102150405Z0700", "20060102150405Z07", "20060102150405", "200601021504", "20060102", "200601", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			if t.Year() < MinPublishYear || t.After(time.Now().Add(24*time.Hour)) {
+				return time.Time{}, false
+			}
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
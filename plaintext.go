@@ -0,0 +1,147 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ----- Plain text and Markdown -----
+
+var (
+	mdHeadingRE = regexp.MustCompile(`^(#{1,6})\s+(.*?)\s*#*\s*$`)
+	mdSetextRE  = regexp.MustCompile(`^(=+|-+)\s*$`)
+	mdImageRE   = regexp.MustCompile(`!\[([^\]]*)\]\([^)]*\)`)
+	mdLinkRE    = regexp.MustCompile(`\[([^\]]*)\]\(\s*<?([^)\s>]+)>?(?:\s+"[^"]*")?\s*\)`)
+	mdAutoRE    = regexp.MustCompile(`<(https?://[^>\s]+)>`)
+	mdMarkRE    = regexp.MustCompile("(\\*\\*|__|\\*|`+|~~)")
+)
+
+// isMarkdown reports whether a text document is Markdown: by type, or for
+// text/plain by a .md or .markdown extension, which is how most servers
+// send READMEs.
+func isMarkdown(mediaType string, u *url.URL) bool {
+	switch mediaType {
+	case "text/markdown", "text/x-markdown":
+		return true
+	case "text/plain":
+		ext := strings.ToLower(path.Ext(u.Path))
+		return ext == ".md" || ext == ".markdown"
+	}
+	return false
+}
+
+// textExtract builds a Page from a plain text or Markdown document. In
+// Markdown, ATX (#) and setext (underlined) headings become Headings and the
+// title, links are kept with their text, and inline markup is dropped from
+// the stored text. Plain text takes its title from the first line.
+func textExtract(u string, body []byte, base *url.URL, markdown bool) *extraction {
+	var (
+		title     string
+		paras     []string
+		headings  Headings
+		links     []Link
+		lines     []string
+		paragraph []string
+	)
+	endParagraph := func() {
+		if p := cleanText(strings.Join(paragraph, " ")); p != "" {
+			paras = append(paras, p)
+		}
+		paragraph = paragraph[:0]
+	}
+	heading := func(level int, text string) {
+		if title == "" && level == 1 {
+			title = text
+		}
+		if level <= 3 {
+			headings.add("h"+string(rune('0'+level)), text)
+		}
+	}
+
+	src := strings.Split(strings.ReplaceAll(string(body), "\r\n", "\n"), "\n")
+	fenced := false
+	for i, line := range src {
+		if markdown {
+			if t := strings.TrimSpace(line); strings.HasPrefix(t, "```") || strings.HasPrefix(t, "~~~") {
+				fenced = !fenced
+				endParagraph()
+				continue
+			}
+			if !fenced {
+				if m := mdHeadingRE.FindStringSubmatch(line); m != nil {
+					endParagraph()
+					text := mdInline(m[2], base, &links)
+					heading(len(m[1]), text)
+					lines = append(lines, text)
+					continue
+				}
+				if i+1 < len(src) && strings.TrimSpace(line) != "" && mdSetextRE.MatchString(src[i+1]) && !strings.HasPrefix(strings.TrimSpace(line), "-") {
+					endParagraph()
+					text := mdInline(line, base, &links)
+					level := 1
+					if strings.HasPrefix(strings.TrimSpace(src[i+1]), "-") {
+						level = 2
+					}
+					heading(level, text)
+					lines = append(lines, text)
+					continue
+				}
+				if mdSetextRE.MatchString(line) {
+					continue // underline of the heading above, or a rule
+				}
+				line = mdInline(strings.TrimLeft(strings.TrimSpace(line), ">*-+ "), base, &links)
+			}
+		}
+		if strings.TrimSpace(line) == "" {
+			endParagraph()
+			continue
+		}
+		paragraph = append(paragraph, line)
+		lines = append(lines, line)
+	}
+	endParagraph()
+
+	raw := strings.Join(lines, "\n")
+	text := truncateRunes(cleanText(raw), MaxTextChars)
+	if title == "" && len(headings.H2) > 0 {
+		title = headings.H2[0]
+	}
+	if title == "" {
+		title = firstLine(raw)
+	}
+	if title == "" {
+		title = path.Base(base.Path)
+	}
+	snippet := summarize(paras)
+
+	return &extraction{Page: Page{
+		URL:       u,
+		Title:     safeUTF8(title),
+		Snippet:   safeUTF8(snippet),
+		SiteName:  base.Hostname(),
+		Text:      safeUTF8(text),
+		MainText:  safeUTF8(text),
+		Headings:  headings,
+		Links:     links,
+		CrawlTime: time.Now().UTC(),
+	}}
+}
+
+// mdInline reduces a line of Markdown to its text, appending its links:
+// images become their alt text, links their text, and emphasis and code
+// markers are removed.
+func mdInline(line string, base *url.URL, links *[]Link) string {
+	line = mdImageRE.ReplaceAllString(line, "$1")
+	for _, m := range mdLinkRE.FindAllStringSubmatch(line, -1) {
+		*links = append(*links, newLink(m[2], "", cleanText(m[1]), base))
+	}
+	line = mdLinkRE.ReplaceAllString(line, "$1")
+	for _, m := range mdAutoRE.FindAllStringSubmatch(line, -1) {
+		*links = append(*links, newLink(m[1], "", "", base))
+	}
+	line = mdAutoRE.ReplaceAllString(line, "$1")
+	return cleanText(mdMarkRE.ReplaceAllString(line, ""))
+}
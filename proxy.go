@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ----- Proxies -----
+
+// MaxProxyFailures consecutive failures (see proxyFailure) take a proxy out
+// of rotation.
+const MaxProxyFailures = 5
+
+type proxyCtxKey struct{}
+
+// proxyPool rotates requests across the proxies in PROXY_LIST_FILE
+// (http://, https://, socks5:// URLs, one per line). Without a list the
+// standard HTTP_PROXY / HTTPS_PROXY / NO_PROXY variables apply; those also
+// accept socks5:// URLs.
+type proxyPool struct {
+	proxies []*url.URL
+	rotate  bool
+
+	mu       sync.Mutex
+	next     int
+	failures []int
+}
+
+func loadProxyPool() (*proxyPool, error) {
+	path := getEnv("PROXY_LIST_FILE", "")
+	if path == "" {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("proxy list: %w", err)
+	}
+	defer f.Close()
+
+	pool := &proxyPool{rotate: getEnvBool("PROXY_ROTATE", true)}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("proxy list: bad proxy %q", line)
+		}
+		pool.proxies = append(pool.proxies, u)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("proxy list: %w", err)
+	}
+	if len(pool.proxies) == 0 {
+		return nil, fmt.Errorf("proxy list %s is empty", path)
+	}
+	pool.failures = make([]int, len(pool.proxies))
+	return pool, nil
+}
+
+// pick returns the index of the proxy to use for the next request.
+// Without rotation the first healthy proxy is always used.
+func (p *proxyPool) pick() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := len(p.proxies)
+	start := 0
+	if p.rotate {
+		start = p.next
+		p.next = (p.next + 1) % n
+	}
+	for i := 0; i < n; i++ {
+		idx := (start + i) % n
+		if p.failures[idx] < MaxProxyFailures {
+			return idx
+		}
+	}
+	// Everything is failing; keep going rather than stall the crawl.
+	return start
+}
+
+// proxyFailure is the error report counts against a proxy for one round
+// trip: a transport error, which covers a failed dial or CONNECT, a 407,
+// or a 502 or 504 to a plain-HTTP request, which the proxy sends when it
+// can't reach the site. Through a CONNECT tunnel every status is the
+// site's own, and any response from the site is a success for the proxy.
+func proxyFailure(req *http.Request, resp *http.Response, err error) error {
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusProxyAuthRequired:
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		if req.URL.Scheme != "http" {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return fmt.Errorf("response %s", resp.Status)
+}
+
+func (p *proxyPool) report(idx int, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err == nil {
+		p.failures[idx] = 0
+		return
+	}
+	p.failures[idx]++
+	if p.failures[idx] == MaxProxyFailures {
+		log.Printf("proxy: %s disabled after %d failures: %v", p.proxies[idx].Redacted(), MaxProxyFailures, err)
+	}
+}
+
+// withProxy stores the chosen proxy on the request context so the
+// transport's Proxy func and the failure accounting agree.
+func (p *proxyPool) withProxy(req *http.Request) (*http.Request, int) {
+	idx := p.pick()
+	return req.WithContext(context.WithValue(req.Context(), proxyCtxKey{}, idx)), idx
+}
+
+// proxyFunc is installed as http.Transport.Proxy.
+func (p *proxyPool) proxyFunc(req *http.Request) (*url.URL, error) {
+	if p == nil {
+		return http.ProxyFromEnvironment(req)
+	}
+	idx, ok := req.Context().Value(proxyCtxKey{}).(int)
+	if !ok {
+		idx = p.pick()
+	}
+	return p.proxies[idx], nil
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// ----- Per-host rate limiting -----
+
+// politeness is the request spacing for one host: at least Delay between
+// requests, plus up to Jitter (a fraction of Delay) at random so workers
+// sharing a host don't fall into lockstep.
+type politeness struct {
+	Delay  time.Duration
+	Jitter float64
+}
+
+// hostLimiter is a token bucket of size one per hostname: each host gets
+// at most one request per interval, while different hosts never wait on
+// each other. Intervals come from the host's profile, or the default.
+type hostLimiter struct {
+	def     politeness
+	profile func(host string) (politeness, bool) // per-domain overrides
+
+	mu     sync.Mutex
+	next   map[string]time.Time
+	floors map[string]time.Duration // per-host minimums (Crawl-delay)
+}
+
+func newHostLimiter(def politeness, profile func(host string) (politeness, bool)) *hostLimiter {
+	return &hostLimiter{
+		def:     def,
+		profile: profile,
+		next:    make(map[string]time.Time),
+		floors:  make(map[string]time.Duration),
+	}
+}
+
+// SetMinInterval slows host down to at least d between requests, whatever
+// its profile says.
+func (l *hostLimiter) SetMinInterval(host string, d time.Duration) {
+	l.mu.Lock()
+	l.floors[host] = d
+	l.mu.Unlock()
+}
+
+// interval is the spacing before host's next request, jitter included.
+func (l *hostLimiter) interval(host string) time.Duration {
+	p := l.def
+	if l.profile != nil {
+		if hp, ok := l.profile(host); ok {
+			p = hp
+		}
+	}
+	d := max(p.Delay, l.floors[host])
+	if p.Jitter > 0 {
+		d += time.Duration(rand.Float64() * p.Jitter * float64(d))
+	}
+	return d
+}
+
+// Wait reserves the next slot for host and sleeps until it arrives.
+func (l *hostLimiter) Wait(ctx context.Context, host string) error {
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval(host))
+	l.mu.Unlock()
+
+	delay := time.Until(slot)
+	if delay <= 0 {
+		return nil
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
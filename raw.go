@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Raw bodies -----
+
+// MaxRawBytes is the largest compressed body stored, leaving headroom
+// under Mongo's 16 MB document limit.
+const MaxRawBytes = 15 * 1024 * 1024
+
+// rawPage is a fetched body kept in raw_pages (STORE_RAW=true) so newer
+// extraction code can be run over past crawls without refetching. It is
+// keyed like the pages document it was extracted into; the fetch URL and
+// headers extraction reads are in that document's response field.
+type rawPage struct {
+	URL         string    `bson:"_id"`
+	FetchedAt   time.Time `bson:"fetched_at"`
+	ContentType string    `bson:"content_type"`
+	Size        int       `bson:"size"` // uncompressed
+	Body        []byte    `bson:"body"` // zstd; text already transcoded to UTF-8
+}
+
+// Encoders and decoders are safe for concurrent EncodeAll and DecodeAll.
+var (
+	rawEncoder, _ = zstd.NewWriter(nil)
+	rawDecoder, _ = zstd.NewReader(nil)
+)
+
+// newRawPage compresses res's body for pageURL.
+func newRawPage(pageURL string, res *fetchResult) (rawPage, error) {
+	body := rawEncoder.EncodeAll(res.Body, nil)
+	if len(body) > MaxRawBytes {
+		return rawPage{}, fmt.Errorf("raw: %s: %d compressed bytes, not stored", pageURL, len(body))
+	}
+	return rawPage{
+		URL:         pageURL,
+		FetchedAt:   time.Now().UTC(),
+		ContentType: res.Type,
+		Size:        len(res.Body),
+		Body:        body,
+	}, nil
+}
+
+// storeRaw saves res's body for pageURL, replacing the previous fetch.
+func storeRaw(ctx context.Context, col *mongo.Collection, pageURL string, res *fetchResult) error {
+	raw, err := newRawPage(pageURL, res)
+	if err != nil {
+		return err
+	}
+	_, err = col.ReplaceOne(ctx, bson.M{"_id": pageURL}, raw, options.Replace().SetUpsert(true))
+	return err
+}
+
+// decode returns the uncompressed body.
+func (r *rawPage) decode() ([]byte, error) {
+	return rawDecoder.DecodeAll(r.Body, make([]byte, 0, r.Size))
+}
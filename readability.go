@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// ----- Main content extraction -----
+
+// MinMainChars is the shortest main text accepted from the best-scoring
+// container; below it the page is probably not an article, and main_text
+// falls back to the body minus boilerplate.
+const MinMainChars = 250
+
+// boilerplateTags never hold a page's main content. <form> isn't one:
+// ASP.NET WebForms pages wrap the whole body in one.
+var boilerplateTags = map[string]bool{
+	"nav": true, "header": true, "footer": true, "aside": true, "menu": true,
+}
+
+var (
+	unlikelyRE = regexp.MustCompile(`(?i)cookie|consent|banner|menu|navbar|nav-|footer|sidebar|comment|share|social|related|promo|breadcrumb|newsletter|popup|modal|advert|sponsor|^ad-|-ad$`)
+	likelyRE   = regexp.MustCompile(`(?i)article|content|main|post|entry|story|body`)
+)
+
+// boilerplate reports whether an element is navigation, chrome or an ad,
+// judged by its tag and its class and id.
+func boilerplate(tag, class, id string) bool {
+	if boilerplateTags[tag] {
+		return true
+	}
+	hint := class + " " + id
+	return unlikelyRE.MatchString(hint) && !likelyRE.MatchString(hint)
+}
+
+// mainContent picks the element holding doc's article text, readability
+// style: boilerplate is dropped, every paragraph scores its parent for its
+// length and commas (and its grandparent half as much), and the best
+// container wins once discounted for link density. When no container
+// holds MinMainChars of text, the body minus boilerplate is used. It works
+// on a copy of the body, so doc is not modified.
+func mainContent(doc *goquery.Document) (*goquery.Selection, string) {
+	body := doc.Find("body").First().Clone()
+	body.Find("*").FilterFunction(func(_ int, s *goquery.Selection) bool {
+		return boilerplate(goquery.NodeName(s), s.AttrOr("class", ""), s.AttrOr("id", ""))
+	}).Remove()
+
+	scores := make(map[*html.Node]float64)
+	body.Find("p, pre, td, blockquote").Each(func(_ int, s *goquery.Selection) {
+		txt := visibleText(s)
+		if len(txt) < 25 {
+			return
+		}
+		score := 1 + float64(strings.Count(txt, ",")) + min(float64(len(txt))/100, 3)
+		if parent := s.Parent(); parent.Length() > 0 {
+			scores[parent.Get(0)] += score
+			if grand := parent.Parent(); grand.Length() > 0 {
+				scores[grand.Get(0)] += score / 2
+			}
+		}
+	})
+
+	// Walk in document order so ties go to the earlier container.
+	var best *goquery.Selection
+	bestScore := 0.0
+	body.Find("*").Each(func(_ int, s *goquery.Selection) {
+		score, ok := scores[s.Get(0)]
+		if !ok {
+			return
+		}
+		if score *= 1 - linkDensity(s); score > bestScore {
+			best, bestScore = s, score
+		}
+	})
+	if best != nil {
+		if txt := visibleText(best); len([]rune(txt)) >= MinMainChars {
+			return best, txt
+		}
+	}
+	return body, visibleText(body)
+}
+
+// linkDensity is the share of s's text that sits inside links.
+func linkDensity(s *goquery.Selection) float64 {
+	total := len(visibleText(s))
+	if total == 0 {
+		return 1
+	}
+	linked := 0
+	s.Find("a").Each(func(_ int, a *goquery.Selection) {
+		linked += len(visibleText(a))
+	})
+	return float64(linked) / float64(total)
+}
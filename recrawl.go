@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Adaptive recrawl -----
+
+// Revisit intervals halve when a page is seen to change and grow by half
+// when it is not, so busy pages converge on hourly visits and static ones
+// on monthly.
+const (
+	InitialRecrawlInterval = 24 * time.Hour
+	MinRecrawlInterval     = time.Hour
+	MaxRecrawlInterval     = 30 * 24 * time.Hour
+)
+
+// contentHash fingerprints extracted text, ignoring case and whitespace.
+func contentHash(text string) string {
+	sum := sha256.Sum256([]byte(strings.Join(strings.Fields(strings.ToLower(text)), " ")))
+	return hex.EncodeToString(sum[:])
+}
+
+// recrawlSchedule is stored on the page and updated on every visit.
+type recrawlSchedule struct {
+	Interval  time.Duration
+	NextCrawl time.Time
+	Checks    int // visits after the first
+	Changes   int // visits that found different content
+}
+
+// nextSchedule adapts prev's interval to whether the content changed.
+func nextSchedule(prev *pageMeta, changed bool) recrawlSchedule {
+	if prev == nil {
+		return recrawlSchedule{
+			Interval:  InitialRecrawlInterval,
+			NextCrawl: time.Now().UTC().Add(InitialRecrawlInterval),
+		}
+	}
+
+	s := recrawlSchedule{
+		Interval: prev.RecrawlInterval,
+		Checks:   prev.Checks + 1,
+		Changes:  prev.Changes,
+	}
+	if s.Interval <= 0 {
+		s.Interval = InitialRecrawlInterval
+	}
+	if changed {
+		s.Changes++
+		s.Interval /= 2
+	} else {
+		s.Interval += s.Interval / 2
+	}
+	s.Interval = min(max(s.Interval, MinRecrawlInterval), MaxRecrawlInterval)
+	s.NextCrawl = time.Now().UTC().Add(s.Interval)
+	return s
+}
+
+// touched is what a 304 revisit under s sets on the stored page: content
+// unchanged, only crawl_time and the recrawl schedule move.
+func (s recrawlSchedule) touched() bson.M {
+	return bson.M{
+		"crawl_time":       time.Now().UTC(),
+		"recrawl_interval": s.Interval,
+		"next_crawl":       s.NextCrawl,
+		"checks":           s.Checks,
+		"changes":          s.Changes,
+		"changed":          false,
+		"change_ratio":     0.0,
+	}
+}
+
+func (s recrawlSchedule) apply(p *Page) {
+	p.RecrawlInterval = s.Interval
+	p.NextCrawl = s.NextCrawl
+	p.Checks = s.Checks
+	p.Changes = s.Changes
+}
+
+// dueForRecrawl lists stored pages whose next_crawl has passed, oldest first.
+func dueForRecrawl(ctx context.Context, col *mongo.Collection, limit int) ([]string, error) {
+	opts := options.Find().
+		SetSort(bson.M{"next_crawl": 1}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"url": 1})
+	cur, err := col.Find(ctx, bson.M{"next_crawl": bson.M{"$lte": time.Now().UTC()}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close(ctx)
+
+	var urls []string
+	for cur.Next(ctx) {
+		var doc struct {
+			URL string `bson:"url"`
+		}
+		if err := cur.Decode(&doc); err == nil {
+			urls = append(urls, doc.URL)
+		}
+	}
+	return urls, cur.Err()
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+)
+
+// ----- Re-extraction -----
+
+// runReextract rebuilds pages from the bodies in raw_pages with the
+// current extraction code, or just the page stored under only. What the
+// fetch and the crawl history decided is kept: the URL and aliases,
+// validators, recrawl schedule, change history, near-duplicate verdict,
+// response metadata and the checked favicon. Everything extracted from the
+// body is replaced, and the fingerprints are recomputed so the next crawl
+// compares like with like. Robots directives and canonicals are not
+// re-applied; a page that was stored stays stored under its key.
+func runReextract(ctx context.Context, store Store, parser htmlParser, only string) error {
+	var done, failed int
+	err := store.EachRaw(ctx, only, func(raw *rawPage) error {
+		if err := reextractPage(ctx, store, parser, raw); err != nil {
+			log.Printf("reextract: %s: %v", raw.URL, err)
+			failed++
+			return nil
+		}
+		if done++; done%1000 == 0 {
+			log.Printf("reextract: %d pages", done)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("reextract: %d pages updated, %d failed", done, failed)
+	if only != "" && done+failed == 0 {
+		return fmt.Errorf("reextract: no raw body stored for %s", only)
+	}
+	return nil
+}
+
+func reextractPage(ctx context.Context, store Store, parser htmlParser, raw *rawPage) error {
+	stored, err := store.Page(ctx, raw.URL)
+	if err != nil {
+		return err
+	}
+	if stored == nil {
+		return errors.New("page no longer stored")
+	}
+	body, err := raw.decode()
+	if err != nil {
+		return err
+	}
+	res := &fetchResult{
+		Body:     body,
+		Type:     raw.ContentType,
+		FinalURL: stored.Response.FinalURL,
+		Meta:     stored.Response,
+	}
+	if res.FinalURL == "" {
+		res.FinalURL = stored.URL
+	}
+	base, err := url.Parse(res.FinalURL)
+	if err != nil {
+		return err
+	}
+	ex, err := parser.extract(res, base)
+	if err != nil {
+		return err
+	}
+
+	page := ex.Page
+	page.URL = stored.URL
+	page.Favicon = stored.Favicon
+	page.CrawlTime = stored.CrawlTime
+	page.ETag, page.LastModified = stored.ETag, stored.LastModified
+	page.NearDuplicateOf = stored.NearDuplicateOf
+	page.RecrawlInterval, page.NextCrawl = stored.RecrawlInterval, stored.NextCrawl
+	page.Checks, page.Changes = stored.Checks, stored.Changes
+	page.Changed, page.ChangeRatio, page.LastChanged = stored.Changed, stored.ChangeRatio, stored.LastChanged
+	page.Response = stored.Response
+	page.ContentHash = contentHash(page.Text)
+	page.MainHash, page.MainSketch = contentHash(page.MainText), minHashSketch(page.MainText)
+	if fp, ok := simHash(page.Text); ok {
+		page.SimHash = int64(fp)
+	}
+	return store.UpsertPage(ctx, page)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Meta refresh -----
+
+const (
+	MaxRefreshHops  = 3
+	MaxRefreshDelay = 10 // seconds; slower refreshes are treated as reloads
+)
+
+// metaRefresh returns the target of a prompt <meta http-equiv="refresh">
+// redirect on doc, resolved against base.
+func metaRefresh(doc *goquery.Document, base *url.URL) *url.URL {
+	var target *url.URL
+	doc.Find("meta[http-equiv]").EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		if !strings.EqualFold(strings.TrimSpace(s.AttrOr("http-equiv", "")), "refresh") {
+			return true
+		}
+		target = refreshTarget(s.AttrOr("content", ""), base)
+		return false
+	})
+	return target
+}
+
+// refreshTarget parses a refresh content value such as "0; url=/next",
+// "0;URL='/next'" or just "5" (a plain reload, ignored). It returns nil
+// unless the value redirects within MaxRefreshDelay.
+func refreshTarget(content string, base *url.URL) *url.URL {
+	delay, rest, _ := strings.Cut(content, ";")
+	if rest == "" {
+		delay, rest, _ = strings.Cut(delay, ",")
+	}
+	secs, err := strconv.ParseFloat(strings.TrimSpace(delay), 64)
+	if err != nil || secs > MaxRefreshDelay {
+		return nil
+	}
+
+	rest = strings.TrimSpace(rest)
+	if len(rest) >= 4 && strings.EqualFold(rest[:3], "url") {
+		if after, ok := strings.CutPrefix(strings.TrimSpace(rest[3:]), "="); ok {
+			rest = strings.TrimSpace(after)
+		}
+	}
+	rest = strings.Trim(rest, `'"`)
+	if rest == "" {
+		return nil
+	}
+	u, err := normalizeURL(base, rest)
+	if err != nil {
+		return nil
+	}
+	return u
+}
+
+// followRefresh chases meta-refresh redirects from res, up to
+// MaxRefreshHops, and returns the destination, parsed, with the hops
+// recorded as redirects. It returns false when the chain leads somewhere
+// the crawler may not go, is already crawled, or fails; the refresh shell
+// itself is not worth indexing.
+func (c *crawler) followRefresh(ctx context.Context, res *fetchResult, ex *extraction, at *url.URL) (*fetchResult, *extraction, *url.URL, bool) {
+	var err error
+	for hops := 0; ; hops++ {
+		target := ex.Refresh
+		if target == nil || target.String() == res.FinalURL {
+			return res, ex, at, true
+		}
+		if hops == MaxRefreshHops {
+			log.Printf("refresh: %s: too many hops", res.FinalURL)
+			return nil, nil, nil, false
+		}
+		secure, ok := c.https.apply(target.String())
+		if !ok {
+			return nil, nil, nil, false
+		}
+		if target, err = url.Parse(secure); err != nil {
+			return nil, nil, nil, false
+		}
+		if !isAllowedDomain(target, c.allowedDomains) || !c.cfg.urlAllowed(target) {
+			log.Printf("refresh: %s leads to disallowed %s", res.FinalURL, target)
+			return nil, nil, nil, false
+		}
+		if c.robots != nil && !c.robots.Allowed(ctx, target) {
+			log.Printf("robots: disallowed %s", target)
+			return nil, nil, nil, false
+		}
+		c.mu.Lock()
+		seen := c.seenLocked(target.String())
+		c.visited.Add(target.String())
+		c.mu.Unlock()
+		if seen {
+			return nil, nil, nil, false
+		}
+
+		if err := c.limiter.Wait(ctx, target.Hostname()); err != nil {
+			return nil, nil, nil, false
+		}
+		log.Printf("refresh: %s -> %s", res.FinalURL, target)
+		next, err := c.fetch(ctx, target, target.String(), nil)
+		if err != nil {
+			log.Printf("error: %s: %v", target, err)
+			return nil, nil, nil, false
+		}
+		if next.FinalURL != target.String() {
+			if target, err = url.Parse(next.FinalURL); err != nil || !isAllowedDomain(target, c.allowedDomains) {
+				return nil, nil, nil, false
+			}
+			c.markVisited(next.FinalURL)
+		}
+		next.Redirects = append(append(res.Redirects, res.FinalURL), next.Redirects...)
+		if ex, err = c.parser.extract(next, target); err != nil {
+			log.Printf("parse: %s: %v", next.FinalURL, err)
+			return nil, nil, nil, false
+		}
+		res, at = next, target
+	}
+}
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/page"
+	"github.com/chromedp/chromedp"
+)
+
+// ----- Headless rendering -----
+
+// chromeRenderer loads pages in headless Chrome for domains configured with
+// "renderer": "chrome", so client-side rendered content reaches
+// extractPage. The browser is started on first use and shared by all
+// workers; RENDER_TABS bounds how many pages render at once.
+type chromeRenderer struct {
+	userAgent string
+	guard     *netGuard
+	execPath  string
+	timeout   time.Duration // whole render, per page
+	idleWait  time.Duration // max wait for network idle after load
+
+	once     sync.Once
+	startErr error
+	browser  context.Context
+	cancel   context.CancelFunc
+	tabs     chan struct{}
+}
+
+func newChromeRenderer(userAgent string, guard *netGuard) *chromeRenderer {
+	return &chromeRenderer{
+		userAgent: userAgent,
+		guard:     guard,
+		execPath:  getEnv("CHROME_PATH", ""),
+		timeout:   getEnvDuration("RENDER_TIMEOUT", 30*time.Second),
+		idleWait:  getEnvDuration("RENDER_IDLE_WAIT", 5*time.Second),
+		tabs:      make(chan struct{}, max(getEnvInt("RENDER_TABS", 2), 1)),
+	}
+}
+
+func (r *chromeRenderer) start() error {
+	r.once.Do(func() {
+		opts := append(chromedp.DefaultExecAllocatorOptions[:],
+			chromedp.UserAgent(r.userAgent),
+		)
+		if r.execPath != "" {
+			opts = append(opts, chromedp.ExecPath(r.execPath))
+		}
+		allocCtx, cancelAlloc := chromedp.NewExecAllocator(context.Background(), opts...)
+		browser, cancelBrowser := chromedp.NewContext(allocCtx)
+		// Run with no actions launches the browser.
+		if err := chromedp.Run(browser); err != nil {
+			cancelBrowser()
+			cancelAlloc()
+			r.startErr = fmt.Errorf("render: start chrome: %w", err)
+			return
+		}
+		r.browser = browser
+		r.cancel = func() {
+			cancelBrowser()
+			cancelAlloc()
+		}
+	})
+	return r.startErr
+}
+
+// Render navigates to u, waits for the load event and then for network
+// idle (bounded by idleWait), and returns the rendered DOM. The domain's
+// user agent and headers, if configured, apply to the page. Chrome makes
+// its own connections, so every request it sends, redirects, frames and
+// subresources included, is paused and its host screened by the net guard
+// first; blocked ones fail, and a page that ends up on a blocked host is
+// not returned.
+func (r *chromeRenderer) Render(ctx context.Context, u string, dc domainConfig) (*fetchResult, *fetchError) {
+	if pu, err := url.Parse(u); err != nil {
+		return nil, &fetchError{Err: err}
+	} else if err := r.guard.CheckHost(ctx, pu.Hostname()); err != nil {
+		return nil, networkError(err)
+	}
+	if err := r.start(); err != nil {
+		return nil, &fetchError{Err: err}
+	}
+
+	select {
+	case r.tabs <- struct{}{}:
+		defer func() { <-r.tabs }()
+	case <-ctx.Done():
+		return nil, &fetchError{Err: ctx.Err()}
+	}
+
+	tab, cancelTab := chromedp.NewContext(r.browser)
+	defer cancelTab()
+	tab, cancelTimeout := context.WithTimeout(tab, r.timeout)
+	defer cancelTimeout()
+	stop := context.AfterFunc(ctx, cancelTab)
+	defer stop()
+
+	idle := make(chan struct{}, 1)
+	var (
+		mu      sync.Mutex
+		blocked error // why a document request was failed
+	)
+	chromedp.ListenTarget(tab, func(ev any) {
+		switch e := ev.(type) {
+		case *page.EventLifecycleEvent:
+			if e.Name == "networkIdle" {
+				select {
+				case idle <- struct{}{}:
+				default:
+				}
+			}
+		case *fetch.EventRequestPaused:
+			// Commands can't be sent from the listener itself.
+			go func() {
+				exec := cdp.WithExecutor(tab, chromedp.FromContext(tab).Target)
+				if err := r.checkRequest(tab, e.Request.URL); err != nil {
+					if e.ResourceType == network.ResourceTypeDocument {
+						mu.Lock()
+						blocked = err
+						mu.Unlock()
+					}
+					fetch.FailRequest(e.RequestID, network.ErrorReasonBlockedByClient).Do(exec)
+					return
+				}
+				fetch.ContinueRequest(e.RequestID).Do(exec)
+			}()
+		}
+	})
+
+	start := time.Now()
+	var html, final string
+	err := chromedp.Run(tab,
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if dc.UserAgent == "" {
+				return nil
+			}
+			return emulation.SetUserAgentOverride(dc.UserAgent).Do(ctx)
+		}),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if len(dc.Headers) == 0 {
+				return nil
+			}
+			headers := make(network.Headers, len(dc.Headers))
+			for k, v := range dc.Headers {
+				headers[k] = v
+			}
+			if err := network.Enable().Do(ctx); err != nil {
+				return err
+			}
+			return network.SetExtraHTTPHeaders(headers).Do(ctx)
+		}),
+		page.SetLifecycleEventsEnabled(true),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			if r.guard.disabled {
+				return nil
+			}
+			return fetch.Enable().Do(ctx)
+		}),
+		chromedp.ActionFunc(func(context.Context) error {
+			// Forget any idle signal from the blank start page.
+			select {
+			case <-idle:
+			default:
+			}
+			return nil
+		}),
+		chromedp.Navigate(u),
+		chromedp.ActionFunc(func(ctx context.Context) error {
+			t := time.NewTimer(r.idleWait)
+			defer t.Stop()
+			select {
+			case <-idle:
+			case <-t.C:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			return nil
+		}),
+		chromedp.Location(&final),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)
+	if err != nil {
+		mu.Lock()
+		defer mu.Unlock()
+		if blocked != nil {
+			return nil, networkError(blocked)
+		}
+		return nil, &fetchError{Err: fmt.Errorf("render: %w", err), retryable: ctx.Err() == nil}
+	}
+	if err := r.checkRequest(ctx, final); err != nil {
+		return nil, networkError(err)
+	}
+
+	res := &fetchResult{Body: []byte(html), Type: "text/html", FinalURL: u}
+	if fu, err := url.Parse(final); err == nil && fu.IsAbs() {
+		canonicalizeURL(fu)
+		if fu.String() != u {
+			res.FinalURL = fu.String()
+			res.Redirects = []string{u}
+		}
+	}
+	res.Meta = ResponseMeta{
+		FinalURL:      res.FinalURL,
+		ContentType:   "text/html",
+		ContentLength: -1,
+		BodyBytes:     len(html),
+		FetchDuration: time.Since(start),
+	}
+	return res, nil
+}
+
+// checkRequest fails a request by Chrome to a blocked host. Besides
+// http(s) and WebSockets only data:, blob: and about: URLs, which open no
+// connection, are allowed.
+func (r *chromeRenderer) checkRequest(ctx context.Context, raw string) error {
+	if r.guard.disabled {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "http", "https", "ws", "wss":
+		return r.guard.CheckHost(ctx, u.Hostname())
+	case "data", "blob", "about":
+		return nil
+	}
+	return fmt.Errorf("%w: %s URL", errBlockedAddress, u.Scheme)
+}
+
+func (r *chromeRenderer) Close() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+}
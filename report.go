@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ----- Run report -----
+
+// crawlReport summarizes one run; it is logged at the end of crawlSeeds
+// and stored on the run's crawl_runs document.
+type crawlReport struct {
+	Duration       time.Duration  `bson:"duration"`
+	Pages          int            `bson:"pages"`        // fetched and processed
+	NotModified    int            `bson:"not_modified"` // 304s on recrawl
+	Bytes          int64          `bson:"bytes"`        // decoded body bytes downloaded
+	AvgFetch       time.Duration  `bson:"avg_fetch"`
+	Errors         map[string]int `bson:"errors"`  // failed URLs by errorKind
+	Domains        []domainCount  `bson:"domains"` // pages per host, most first
+	QueueRemaining int            `bson:"queue_remaining"`
+	Trips          int            `bson:"breaker_trips"`
+}
+
+// runStats accumulates the report; guarded by crawler.mu.
+type runStats struct {
+	start       time.Time
+	fetches     int
+	notModified int
+	bytes       int64
+	fetchTime   time.Duration
+	errors      map[string]int
+	hosts       map[string]int
+}
+
+func newRunStats() runStats {
+	return runStats{
+		start:  time.Now(),
+		errors: make(map[string]int),
+		hosts:  make(map[string]int),
+	}
+}
+
+func (c *crawler) recordFetch(res *fetchResult) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if res.NotModified {
+		c.stats.notModified++
+		return
+	}
+	c.stats.fetches++
+	c.stats.bytes += int64(res.Meta.BodyBytes)
+	c.stats.fetchTime += res.Meta.FetchDuration
+}
+
+func (c *crawler) recordFailure(err error) {
+	c.mu.Lock()
+	c.stats.errors[errorKind(err)]++
+	c.mu.Unlock()
+}
+
+// report builds the run report. It takes c.mu.
+func (c *crawler) report() crawlReport {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	r := crawlReport{
+		Duration:    time.Since(c.stats.start).Round(time.Second),
+		Pages:       c.pagesCrawled,
+		NotModified: c.stats.notModified,
+		Bytes:       c.stats.bytes,
+		Errors:      c.stats.errors,
+		Trips:       len(c.breaker.Trips()),
+	}
+	if c.stats.fetches > 0 {
+		r.AvgFetch = (c.stats.fetchTime / time.Duration(c.stats.fetches)).Round(time.Millisecond)
+	}
+	for host, n := range c.stats.hosts {
+		r.Domains = append(r.Domains, domainCount{Domain: host, Pages: n})
+	}
+	sort.Slice(r.Domains, func(i, j int) bool {
+		if r.Domains[i].Pages != r.Domains[j].Pages {
+			return r.Domains[i].Pages > r.Domains[j].Pages
+		}
+		return r.Domains[i].Domain < r.Domains[j].Domain
+	})
+
+	queued := make(map[string]bool)
+	for _, item := range c.frontier.Top(c.frontier.Len()) {
+		if !c.seenLocked(item.URL) {
+			queued[item.URL] = true
+		}
+	}
+	for _, items := range c.parked {
+		for _, item := range items {
+			queued[item.URL] = true
+		}
+	}
+	r.QueueRemaining = len(queued)
+	return r
+}
+
+// Log prints the report, listing at most ten domains.
+func (r crawlReport) Log() {
+	log.Printf("report: %d pages (%d unchanged) in %s, %s downloaded, avg fetch %s, %d still queued",
+		r.Pages, r.NotModified, r.Duration, formatBytes(r.Bytes), r.AvgFetch, r.QueueRemaining)
+	if len(r.Errors) > 0 {
+		kinds := make([]string, 0, len(r.Errors))
+		for k, n := range r.Errors {
+			kinds = append(kinds, fmt.Sprintf("%s=%d", k, n))
+		}
+		sort.Strings(kinds)
+		log.Printf("report: errors %s", strings.Join(kinds, " "))
+	}
+	if r.Trips > 0 {
+		log.Printf("report: %d circuit breaker trips", r.Trips)
+	}
+	for i, d := range r.Domains {
+		if i == 10 {
+			log.Printf("report: ... and %d more domains", len(r.Domains)-i)
+			break
+		}
+		log.Printf("report: %6d  %s", d.Pages, d.Domain)
+	}
+}
+
+// errorKind buckets a fetch error for the report.
+func errorKind(err error) string {
+	var fe *fetchError
+	if errors.As(err, &fe) && fe.Status != 0 {
+		switch {
+		case fe.Status >= 500:
+			return "http_5xx"
+		case fe.Status >= 400:
+			return "http_4xx"
+		default:
+			return "content" // a 2xx we couldn't use, e.g. not HTML
+		}
+	}
+	var (
+		urlErr *url.Error
+		dnsErr *net.DNSError
+	)
+	if _, ok := certFailure(err); ok {
+		return "tls"
+	}
+	switch {
+	case errors.Is(err, errBlockedAddress), errors.Is(err, errPlainHTTP):
+		return "blocked"
+	case errors.As(err, &dnsErr):
+		return "dns"
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &urlErr) && urlErr.Timeout():
+		return "timeout"
+	}
+	return "network"
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Login walls and paywalls -----
+
+// MaxRestrictedChars is the body text below which a page with a password
+// field or a paywall marker is taken to be only the wall.
+const MaxRestrictedChars = 1500
+
+// paywallFree matches schema.org's paywall markup, e.g. in JSON-LD
+// `"isAccessibleForFree": "False"`.
+var paywallFree = regexp.MustCompile(`(?i)"isaccessibleforfree"\s*:\s*"?false`)
+
+// paywallClasses and paywallIDs are substrings of the class and id of
+// containers used by common paywall vendors and themes.
+var (
+	paywallClasses = []string{"paywall", "subscriber-only", "premium-content", "meteredContent", "tp-modal", "regwall", "login-wall"}
+	paywallIDs     = []string{"paywall", "piano"}
+)
+
+var paywallSelectors = func() string {
+	var sel []string
+	for _, c := range paywallClasses {
+		sel = append(sel, `[class*="`+c+`"]`)
+	}
+	for _, id := range paywallIDs {
+		sel = append(sel, `[id*="`+id+`"]`)
+	}
+	return strings.Join(sel, ", ")
+}()
+
+// paywallMarked reports whether an element's class or id carries a
+// paywall marker; the streaming parser's counterpart of paywallSelectors.
+func paywallMarked(class, id string) bool {
+	for _, c := range paywallClasses {
+		if strings.Contains(class, c) {
+			return true
+		}
+	}
+	for _, p := range paywallIDs {
+		if strings.Contains(id, p) {
+			return true
+		}
+	}
+	return false
+}
+
+var paywallPhrases = []string{
+	"subscribe to continue reading",
+	"subscribe to read",
+	"to continue reading, please",
+	"already a subscriber",
+	"this content is for subscribers",
+	"create a free account to continue",
+	"sign in to continue reading",
+	"log in to continue",
+}
+
+// restriction classifies doc as "login" (little besides a sign-in form),
+// "paywall", or "" for an open page. text is the page's extracted text.
+func restriction(doc *goquery.Document, text string) string {
+	// Publishers that mark up paywalls say so outright.
+	declared := false
+	doc.Find(`script[type="application/ld+json"]`).EachWithBreak(func(_ int, s *goquery.Selection) bool {
+		declared = paywallFree.MatchString(s.Text())
+		return !declared
+	})
+	marked := doc.Find(paywallSelectors).Length() > 0
+	password := doc.Find(`input[type="password" i]`).Length() > 0
+	return classifyRestriction(declared, marked, password, text)
+}
+
+// classifyRestriction turns the signals found in a page into restriction's
+// result: a declared paywall always counts, markers and password fields
+// only on a short page.
+func classifyRestriction(declared, marked, password bool, text string) string {
+	if declared {
+		return "paywall"
+	}
+	short := len([]rune(text)) < MaxRestrictedChars
+
+	lower := strings.ToLower(text)
+	phrase := false
+	for _, p := range paywallPhrases {
+		if strings.Contains(lower, p) {
+			phrase = true
+			break
+		}
+	}
+	if short && (phrase || marked) {
+		return "paywall"
+	}
+	if short && password {
+		return "login"
+	}
+	return ""
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ----- Retry policy -----
+
+type retryPolicy struct {
+	Attempts  int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+func retryPolicyFromEnv() retryPolicy {
+	return retryPolicy{
+		Attempts:  getEnvInt("FETCH_ATTEMPTS", 3),
+		BaseDelay: getEnvDuration("FETCH_RETRY_BASE", 500*time.Millisecond),
+		MaxDelay:  getEnvDuration("FETCH_RETRY_MAX", 30*time.Second),
+	}
+}
+
+// backoff returns base*2^attempt with full jitter, capped at MaxDelay.
+func (p retryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay << attempt
+	if d <= 0 || d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// fetchError is the final failure reason for a URL after all attempts.
+type fetchError struct {
+	Status    int // 0 when no response was received
+	Attempts  int
+	Err       error
+	retryable bool
+	after     time.Duration // from Retry-After
+}
+
+func (e *fetchError) Error() string {
+	if e.Status != 0 {
+		return fmt.Sprintf("status %d after %d attempt(s): %v", e.Status, e.Attempts, e.Err)
+	}
+	return fmt.Sprintf("after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *fetchError) Unwrap() error { return e.Err }
+
+// statusError classifies an HTTP response; 429 and 5xx are worth retrying.
+func statusError(resp *http.Response) *fetchError {
+	fe := &fetchError{
+		Status: resp.StatusCode,
+		Err:    errors.New(http.StatusText(resp.StatusCode)),
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		fe.retryable = true
+		fe.after = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return fe
+}
+
+// networkError wraps transport errors; timeouts and resets are retryable,
+// DNS "no such host" is not.
+func networkError(err error) *fetchError {
+	fe := &fetchError{Err: err, retryable: true}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		fe.retryable = false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, errBlockedAddress) || errors.Is(err, errPlainHTTP) {
+		fe.retryable = false
+	}
+	// A bad certificate won't fix itself between attempts.
+	if _, ok := certFailure(err); ok {
+		fe.retryable = false
+	}
+	return fe
+}
+
+// paced returns the call to make before each request of one fetch. The
+// first is let through, as the caller has waited on the host's limiter for
+// it; every later one, a retry or the GET after a HEAD, waits on wait for
+// a slot of its own, after any backoff.
+func paced(wait func() error) func() *fetchError {
+	sent := false
+	return func() *fetchError {
+		if sent {
+			if err := wait(); err != nil {
+				return &fetchError{Err: err}
+			}
+		}
+		sent = true
+		return nil
+	}
+}
+
+// parseRetryAfter accepts delta-seconds or an HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+// withRetry runs attempt until it succeeds, fails permanently, or the
+// policy is exhausted. The backoff between attempts doesn't pace the host;
+// attempts that send requests do that with paced.
+func withRetry[T any](ctx context.Context, p retryPolicy, attempt func() (T, *fetchError)) (T, error) {
+	attempts := p.Attempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	var zero T
+	for i := 0; ; i++ {
+		v, fe := attempt()
+		if fe == nil {
+			return v, nil
+		}
+		fe.Attempts = i + 1
+		if !fe.retryable || i+1 >= attempts {
+			return zero, fe
+		}
+
+		wait := p.backoff(i)
+		if fe.after > wait {
+			wait = fe.after
+		}
+		if wait > p.MaxDelay {
+			wait = p.MaxDelay
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return zero, fe
+		}
+	}
+}
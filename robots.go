@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ----- robots.txt -----
+
+// CrawlerName is the product token matched against robots.txt User-agent lines.
+const CrawlerName = "BasicSearchBot"
+
+const (
+	MaxRobotsBytes = 512 * 1024
+	MaxCrawlDelay  = 60 * time.Second // cap absurd Crawl-delay values
+)
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+}
+
+// robotsRules holds the rules of the group that applies to us for one host.
+type robotsRules struct {
+	rules      []robotsRule
+	sitemaps   []string
+	crawlDelay time.Duration
+}
+
+// allowAll / disallowAll are used when robots.txt is missing or unreachable.
+var (
+	allowAll    = &robotsRules{}
+	disallowAll = &robotsRules{rules: []robotsRule{{allow: false, pattern: "/"}}}
+)
+
+// Allowed applies longest-match precedence; Allow wins ties.
+func (r *robotsRules) Allowed(path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+	best := -1
+	allowed := true
+	for _, rule := range r.rules {
+		if !robotsMatch(rule.pattern, path) {
+			continue
+		}
+		n := len(rule.pattern)
+		if n > best || (n == best && rule.allow) {
+			best = n
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// robotsMatch supports the "*" wildcard and the "$" end anchor.
+func robotsMatch(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = strings.TrimSuffix(pattern, "$")
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	pos := len(parts[0])
+	for i, part := range parts[1:] {
+		if i == len(parts)-2 && anchored {
+			return len(path)-pos >= len(part) && strings.HasSuffix(path, part)
+		}
+		idx := strings.Index(path[pos:], part)
+		if idx < 0 {
+			return false
+		}
+		pos += idx + len(part)
+	}
+	if anchored && len(parts) == 1 {
+		return pos == len(path)
+	}
+	return true
+}
+
+// parseRobots keeps the group naming our agent, falling back to "*".
+func parseRobots(r io.Reader, agent string) *robotsRules {
+	agent = strings.ToLower(agent)
+
+	var (
+		specific, generic []robotsRule
+		specificDelay     time.Duration
+		genericDelay      time.Duration
+		sitemaps          []string
+		hasSpecific       bool
+		inSpecific        bool
+		inGeneric         bool
+		readingAgents     bool
+	)
+
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := sc.Text()
+		if i := strings.Index(line, "#"); i >= 0 {
+			line = line[:i]
+		}
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "user-agent":
+			if !readingAgents {
+				inSpecific, inGeneric = false, false
+				readingAgents = true
+			}
+			ua := strings.ToLower(val)
+			if ua == "*" {
+				inGeneric = true
+			} else if ua != "" && strings.Contains(agent, ua) {
+				inSpecific = true
+				hasSpecific = true
+			}
+		case "allow", "disallow":
+			readingAgents = false
+			if val == "" {
+				// "Disallow:" with no value means allow everything.
+				continue
+			}
+			rule := robotsRule{allow: key == "allow", pattern: val}
+			if inSpecific {
+				specific = append(specific, rule)
+			}
+			if inGeneric {
+				generic = append(generic, rule)
+			}
+		case "crawl-delay":
+			readingAgents = false
+			secs, err := strconv.ParseFloat(val, 64)
+			if err != nil || secs <= 0 {
+				continue
+			}
+			d := time.Duration(secs * float64(time.Second))
+			if d > MaxCrawlDelay {
+				d = MaxCrawlDelay
+			}
+			if inSpecific {
+				specificDelay = d
+			}
+			if inGeneric {
+				genericDelay = d
+			}
+		case "sitemap":
+			// Sitemap lines are global, not part of any group.
+			if val != "" {
+				sitemaps = append(sitemaps, val)
+			}
+		default:
+			readingAgents = false
+		}
+	}
+
+	if hasSpecific {
+		return &robotsRules{rules: specific, sitemaps: sitemaps, crawlDelay: specificDelay}
+	}
+	return &robotsRules{rules: generic, sitemaps: sitemaps, crawlDelay: genericDelay}
+}
+
+// robotsCache fetches robots.txt once per host. Files are shared across
+// workers, and through the store's robots_txt across runs and processes,
+// for ROBOTS_TTL (24h by default, the longest RFC 9309 recommends).
+// Unreachable or 5xx files are never stored, so the host is retried next
+// run.
+type robotsCache struct {
+	client *http.Client
+	agent  string
+	store  Store // nil: in-memory only
+	ttl    time.Duration
+
+	mu    sync.Mutex
+	hosts map[string]*robotsEntry
+}
+
+// robotsEntry is one origin; ready is closed once rules is set, so
+// workers asking for the same host at once share a single fetch.
+type robotsEntry struct {
+	ready chan struct{}
+	rules *robotsRules
+}
+
+// storedRobots is a robots_txt document. The raw file is kept rather than
+// parsed rules so a change of agent name needs no refetch.
+type storedRobots struct {
+	Origin    string    `bson:"_id"`
+	Status    int       `bson:"status"`
+	Body      string    `bson:"body"`
+	FetchedAt time.Time `bson:"fetched_at"`
+	ExpiresAt time.Time `bson:"expires_at"` // TTL index
+}
+
+func newRobotsCache(client *http.Client, agent string, store Store) *robotsCache {
+	return &robotsCache{
+		client: client,
+		agent:  agent,
+		store:  store,
+		ttl:    getEnvDuration("ROBOTS_TTL", 24*time.Hour),
+		hosts:  make(map[string]*robotsEntry),
+	}
+}
+
+func (c *robotsCache) Allowed(ctx context.Context, u *url.URL) bool {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return c.rulesFor(ctx, u).Allowed(path)
+}
+
+// CrawlDelay is the Crawl-delay for u's host, or 0 when none is set.
+func (c *robotsCache) CrawlDelay(ctx context.Context, u *url.URL) time.Duration {
+	return c.rulesFor(ctx, u).crawlDelay
+}
+
+func (c *robotsCache) rulesFor(ctx context.Context, u *url.URL) *robotsRules {
+	key := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	e, ok := c.hosts[key]
+	if !ok {
+		e = &robotsEntry{ready: make(chan struct{})}
+		c.hosts[key] = e
+	}
+	c.mu.Unlock()
+
+	if ok {
+		select {
+		case <-e.ready:
+			return e.rules
+		case <-ctx.Done():
+			return disallowAll
+		}
+	}
+
+	e.rules = c.load(ctx, key)
+	close(e.ready)
+	return e.rules
+}
+
+// load reads origin's robots.txt from the store, or fetches and stores
+// it.
+func (c *robotsCache) load(ctx context.Context, origin string) *robotsRules {
+	if c.store != nil {
+		doc, err := c.store.CachedRobots(ctx, origin)
+		if err != nil {
+			log.Printf("robots: cache: %v", err)
+		}
+		if doc != nil {
+			return c.rulesFrom(doc.Status, strings.NewReader(doc.Body))
+		}
+	}
+
+	status, body, err := c.fetch(ctx, origin)
+	if err != nil {
+		log.Printf("robots: %s: %v", origin, err)
+		return disallowAll
+	}
+	if status >= 500 {
+		log.Printf("robots: %s: status %d", origin, status)
+		return disallowAll
+	}
+
+	if c.store != nil {
+		now := time.Now().UTC()
+		doc := storedRobots{Origin: origin, Status: status, Body: safeUTF8(body), FetchedAt: now, ExpiresAt: now.Add(c.ttl)}
+		if err := c.store.CacheRobots(ctx, doc); err != nil {
+			log.Printf("robots: cache: %v", err)
+		}
+	}
+	return c.rulesFrom(status, strings.NewReader(body))
+}
+
+// rulesFrom treats 4xx as "no restrictions" and anything else but 2xx as
+// "keep out".
+func (c *robotsCache) rulesFrom(status int, body io.Reader) *robotsRules {
+	switch {
+	case status >= 200 && status < 300:
+		return parseRobots(body, c.agent)
+	case status >= 400 && status < 500:
+		return allowAll
+	default:
+		return disallowAll
+	}
+}
+
+func (c *robotsCache) fetch(ctx context.Context, origin string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, origin+"/robots.txt", nil)
+	if err != nil {
+		return 0, "", err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	var body []byte
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		if body, err = io.ReadAll(io.LimitReader(resp.Body, MaxRobotsBytes)); err != nil {
+			return 0, "", err
+		}
+	}
+	return resp.StatusCode, string(body), nil
+}
+
+// ----- Page-level robots directives -----
+
+type robotsDirectives struct {
+	NoIndex  bool
+	NoFollow bool
+}
+
+// parse reads a meta robots content value or an X-Robots-Tag header.
+// Values may be scoped to a bot ("otherbot: noindex"); scoped values only
+// apply when they name our agent.
+func (d *robotsDirectives) parse(value, agent string) {
+	value = strings.ToLower(value)
+	if name, rest, ok := strings.Cut(value, ":"); ok && !strings.ContainsAny(name, ", ") {
+		if !strings.Contains(strings.ToLower(agent), strings.TrimSpace(name)) {
+			return
+		}
+		value = rest
+	}
+	for _, tok := range strings.Split(value, ",") {
+		switch strings.TrimSpace(tok) {
+		case "noindex":
+			d.NoIndex = true
+		case "nofollow":
+			d.NoFollow = true
+		case "none":
+			d.NoIndex = true
+			d.NoFollow = true
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Crawl runs -----
+
+const (
+	DefaultRunTimeout    = 10 * time.Minute
+	DefaultCrawlSchedule = "0 */6 * * *"
+)
+
+// CrawlRun is one crawl, as tracked in the crawl_runs collection.
+type CrawlRun struct {
+	ID         primitive.ObjectID `bson:"_id"`
+	StartedAt  time.Time          `bson:"started_at"`
+	FinishedAt time.Time          `bson:"finished_at,omitempty"`
+	Status     string             `bson:"status"` // running, finished, interrupted, failed
+	Error      string             `bson:"error,omitempty"`
+	Pages      int                `bson:"pages"`
+	Failures   int                `bson:"failures"`
+	Trips      []hostTrip         `bson:"breaker_trips,omitempty"`
+	Report     *crawlReport       `bson:"report,omitempty"`
+
+	ResumedFrom primitive.ObjectID `bson:"resumed_from,omitempty"`
+}
+
+// runCrawl executes one tracked crawl, continuing the checkpoint of run
+// resumeID when it is set. It refuses to start while another process holds
+// the crawl lock. Cancelling ctx stops the crawl gracefully rather than
+// aborting in-flight fetches.
+func runCrawl(ctx context.Context, store Store, timeout time.Duration, resumeID string) error {
+	owner := lockOwner()
+	ok, err := store.AcquireCrawlLock(ctx, owner, timeout+time.Minute)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("another crawl is already running")
+	}
+	defer store.ReleaseCrawlLock(context.Background(), owner)
+
+	var resume *checkpoint
+	if resumeID != "" {
+		if resume, err = store.LoadCheckpoint(ctx, resumeID); err != nil {
+			return err
+		}
+	}
+
+	run := CrawlRun{
+		ID:        primitive.NewObjectID(),
+		StartedAt: time.Now().UTC(),
+		Status:    "running",
+	}
+	if resume != nil {
+		run.ResumedFrom = resume.RunID
+	}
+	if err := store.SaveRun(ctx, run); err != nil {
+		return err
+	}
+
+	runCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	summary, crawlErr := crawlSeeds(runCtx, store, crawlOptions{RunID: run.ID, Stop: ctx.Done(), Resume: resume})
+	cancel()
+
+	run.FinishedAt = time.Now().UTC()
+	run.Pages = summary.Pages
+	run.Failures = summary.Failures
+	run.Trips = summary.Trips
+	if crawlErr == nil {
+		run.Report = &summary.Report
+	}
+	run.Status = "finished"
+	if summary.Interrupted {
+		run.Status = "interrupted"
+	}
+	if crawlErr != nil {
+		run.Status = "failed"
+		run.Error = crawlErr.Error()
+	}
+	// The run context may be spent; record the outcome regardless.
+	if err := store.SaveRun(context.Background(), run); err != nil {
+		log.Printf("crawl_runs: %v", err)
+	}
+	return crawlErr
+}
+
+// runDaemon triggers runCrawl on CRAWL_SCHEDULE until ctx is done. Runs are
+// sequential, so a tick that arrives mid-run is skipped, not queued.
+func runDaemon(ctx context.Context, store Store, timeout time.Duration) error {
+	sched, err := parseCron(getEnv("CRAWL_SCHEDULE", DefaultCrawlSchedule))
+	if err != nil {
+		return err
+	}
+	for {
+		next := sched.Next(time.Now())
+		log.Printf("daemon: next crawl at %s", next.Format(time.RFC3339))
+
+		t := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return nil
+		case <-t.C:
+		}
+
+		if err := runCrawl(ctx, store, timeout, ""); err != nil {
+			log.Printf("daemon: crawl: %v", err)
+		}
+	}
+}
+
+// ----- Crawl lock -----
+
+type crawlLock struct {
+	ID        string    `bson:"_id"`
+	Owner     string    `bson:"owner"`
+	ExpiresAt time.Time `bson:"expires_at"`
+}
+
+func lockOwner() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s:%d", host, os.Getpid())
+}
+
+// acquireCrawlLock is Store.AcquireCrawlLock for Mongo.
+func acquireCrawlLock(ctx context.Context, db *mongo.Database, owner string, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	filter := bson.M{
+		"_id": "crawl",
+		"$or": []bson.M{{"expires_at": bson.M{"$lt": now}}, {"owner": owner}},
+	}
+	update := bson.M{"$set": bson.M{"owner": owner, "expires_at": now.Add(lease)}}
+	_, err := db.Collection("crawl_locks").UpdateOne(ctx, filter, update, options.Update().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		// The lock document exists and is held by someone else.
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func releaseCrawlLock(ctx context.Context, db *mongo.Database, owner string) {
+	_, err := db.Collection("crawl_locks").DeleteOne(ctx, bson.M{"_id": "crawl", "owner": owner})
+	if err != nil {
+		log.Printf("crawl lock: %v", err)
+	}
+}
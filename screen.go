@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// ----- HEAD screening -----
+
+// pageExtensions are path extensions that normally serve HTML and are
+// fetched without screening.
+var pageExtensions = map[string]bool{
+	"": true, ".html": true, ".htm": true, ".xhtml": true, ".shtml": true,
+	".php": true, ".asp": true, ".aspx": true, ".jsp": true, ".cfm": true,
+	".cgi": true, ".pl": true,
+}
+
+// needsScreening reports whether u's extension makes it worth a HEAD
+// request before downloading it: .zip, .mp4, .pdf and anything else not
+// commonly used for HTML pages.
+func needsScreening(u string) bool {
+	pu, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	return !pageExtensions[strings.ToLower(path.Ext(pu.Path))]
+}
+
+// screen issues a HEAD request for u and returns a permanent error if the
+// response announces a type outside documentTypes or a body over its
+// limit. Servers that don't support HEAD (405, 501) or fail it otherwise
+// are let through to the GET, which makes the final call. The caller has
+// waited on the host's limiter for it, like for any other request.
+func screen(ctx context.Context, client *http.Client, u string) *fetchError {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, u, nil)
+	if err != nil {
+		return &fetchError{Err: err}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return networkError(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil
+	}
+	limit := int64(MaxBodyBytes)
+	if ct := resp.Header.Get("Content-Type"); ct != "" {
+		var ok bool
+		if _, limit, ok = documentType(ct); !ok {
+			return &fetchError{Status: resp.StatusCode, Err: fmt.Errorf("unsupported content type: %s", ct)}
+		}
+	}
+	if resp.ContentLength > limit {
+		return &fetchError{Status: resp.StatusCode, Err: fmt.Errorf("body too large: %d bytes", resp.ContentLength)}
+	}
+	return nil
+}
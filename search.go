@@ -0,0 +1,485 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"maps"
+	"net/url"
+	"os"
+	"runtime"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/realutkarshh/mini-search-crawler/analysis"
+	"github.com/realutkarshh/mini-search-crawler/index"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ----- Search index -----
+
+// DefaultIndexDir is where the index is saved unless INDEX_DIR says
+// otherwise.
+const DefaultIndexDir = "search-index"
+
+// indexProjection is what indexing reads from a stored page.
+var indexProjection = bson.M{
+	"url": 1, "title": 1, "headings": 1, "snippet": 1, "text": 1, "main_text": 1, "lang": 1,
+	"favicon": 1, "image": 1,
+	"crawl_time": 1, "published_at": 1, "word_count": 1,
+}
+
+// MaxAnchors caps the inbound anchor texts indexed per page; a page linked
+// from every page of its site would otherwise carry thousands of copies
+// of "Home".
+const MaxAnchors = 50
+
+func indexDir() string {
+	return getEnv("INDEX_DIR", DefaultIndexDir)
+}
+
+// inbound is what the index takes from the links to a page: their
+// distinct anchor texts, and how many other pages link to it.
+type inbound struct {
+	anchors []string
+	pages   int
+}
+
+// indexDocument maps a stored page and the links to it to the indexed
+// document. The main text is indexed in place of the full text when there
+// is one, so navigation and footers don't match queries.
+func indexDocument(p Page, in inbound) index.Document {
+	return index.Document{
+		URL:      p.URL,
+		Title:    p.Title,
+		Headings: slices.Concat(p.Headings.H1, p.Headings.H2, p.Headings.H3),
+		Snippet:  p.Snippet,
+		Anchors:  in.anchors,
+		Body:     cmp.Or(p.MainText, p.Text),
+		Lang:     p.Lang,
+		Favicon:  p.Favicon,
+		Image:    p.Image,
+
+		Crawled:   p.CrawlTime,
+		Published: p.PublishedAt,
+		Words:     p.WordCount,
+		Inlinks:   in.pages,
+	}
+}
+
+// inboundLinks reads every stored page's links and collects, for each
+// target, the distinct anchor texts of links from other pages, up to
+// MaxAnchors, and counts those pages.
+func inboundLinks(ctx context.Context, store Store) (map[string]*inbound, error) {
+	links := make(map[string]*inbound)
+	seen := make(map[[2]string]bool)
+	err := store.EachPage(ctx, bson.M{"url": 1, "links": 1}, func(p Page) error {
+		for _, l := range p.Links {
+			if l.URL == p.URL {
+				continue
+			}
+			in := links[l.URL]
+			if in == nil {
+				in = &inbound{}
+				links[l.URL] = in
+			}
+			in.pages++ // stored links are one per target
+			if l.AnchorText == "" || len(in.anchors) >= MaxAnchors {
+				continue
+			}
+			key := [2]string{l.URL, strings.ToLower(l.AnchorText)}
+			if !seen[key] {
+				seen[key] = true
+				in.anchors = append(in.anchors, l.AnchorText)
+			}
+		}
+		return nil
+	})
+	return links, err
+}
+
+// indexConfig reads the analyzers from ANALYZER, and ANALYZER_<FIELD>
+// (ANALYZER_TITLE, ANALYZER_BODY, ...) for fields analyzed differently:
+// say with stemming in the body but not the title. A synonym file is one
+// more stage: "lowercase,punctuation,querysynonyms:synonyms.txt,stem" to
+// expand queries, "synonyms:..." to index the synonyms. A spec may start
+// with a tokenizer: "code,lowercase,stop" for programming docs, where
+// "fmt.Println" is one term, or "social,..." to keep "#tags". INDEX_LANGUAGE
+// is the language assumed for pages whose language wasn't detected, and
+// INDEX_COMPRESS compresses the larger posting lists. INDEX_SHINGLES
+// indexes the word pairs of titles and headings, which search uses to
+// rank pages with the query's words side by side higher.
+func indexConfig() index.Config {
+	cfg := index.Config{
+		Analyzer: getEnv("ANALYZER", analysis.DefaultSpec),
+		Language: getEnv("INDEX_LANGUAGE", analysis.DefaultLang),
+		Compress: getEnvBool("INDEX_COMPRESS", false),
+		Shingles: getEnvBool("INDEX_SHINGLES", false),
+	}
+	for _, f := range index.Fields {
+		if spec := getEnv("ANALYZER_"+strings.ToUpper(f), ""); spec != "" {
+			if cfg.Fields == nil {
+				cfg.Fields = make(map[string]string)
+			}
+			cfg.Fields[f] = spec
+		}
+	}
+	return cfg
+}
+
+// DefaultIndexBatch is how many pages a rebuild reads per round trip to
+// the database, unless INDEX_BATCH_SIZE says otherwise.
+const DefaultIndexBatch = 500
+
+// IndexProgressEvery is how often, in pages, a rebuild logs progress.
+const IndexProgressEvery = 10000
+
+// runIndex runs an index subcommand: rebuild, the default, compact,
+// delete or stats. With the Bleve and SQLite backends only rebuild
+// applies.
+func runIndex(ctx context.Context, store Store, args []string) error {
+	mode := "rebuild"
+	if len(args) > 0 {
+		mode, args = args[0], args[1:]
+	}
+	if bleveBackend() || sqliteBackend() {
+		if mode != "rebuild" {
+			return fmt.Errorf("index %s: not supported with SEARCH_BACKEND=%s", mode, getEnv("SEARCH_BACKEND", ""))
+		}
+		if sqliteBackend() {
+			return rebuildFTS(ctx, store)
+		}
+		return rebuildBleve(ctx, store)
+	}
+	switch mode {
+	case "rebuild":
+		return rebuildIndex(ctx, store)
+	case "compact":
+		return compactIndex()
+	case "delete":
+		return deleteFromIndex(args)
+	case "stats":
+		return indexStats()
+	}
+	return fmt.Errorf("index: unknown mode %q", mode)
+}
+
+// deleteFromIndex takes pages out of the index in INDEX_DIR without a
+// rebuild: the URLs given, and with -domain every page on that domain or
+// its subdomains. The pages stay in the database, so a rebuild brings them
+// back unless they are removed there too.
+func deleteFromIndex(args []string) error {
+	fs := flag.NewFlagSet("index delete", flag.ExitOnError)
+	domain := fs.String("domain", "", "delete every page on this domain and its subdomains")
+	fs.Parse(args)
+	if *domain == "" && fs.NArg() == 0 {
+		return fmt.Errorf("index delete: no URLs or -domain")
+	}
+
+	ix, err := index.Open(indexDir())
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	n := 0
+	for _, raw := range fs.Args() {
+		u, err := normalizeURL(&url.URL{}, raw)
+		if err != nil {
+			return fmt.Errorf("index delete: %w", err)
+		}
+		if ix.Delete(u.String()) {
+			n++
+		} else {
+			log.Printf("index delete: %s is not indexed", u)
+		}
+	}
+	if *domain != "" {
+		scope := domainScope{policy: policySubdomains, domains: []string{canonicalHost(*domain)}}
+		n += ix.DeleteFunc(func(raw string) bool {
+			u, err := url.Parse(raw)
+			return err == nil && scope.Allows(u.Hostname())
+		})
+	}
+	if err := ix.Save(indexDir()); err != nil {
+		return err
+	}
+	log.Printf("index: %d documents deleted, %d left", n, ix.Len())
+	return nil
+}
+
+// compactIndex merges the segments of the index in INDEX_DIR as far as
+// its merge policy allows and drops deleted documents from them.
+func compactIndex() error {
+	ix, err := index.Open(indexDir())
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	if err := ix.Compact(); err != nil {
+		return err
+	}
+	if err := ix.Save(indexDir()); err != nil {
+		return err
+	}
+	log.Printf("index: compacted, %d documents", ix.Len())
+	return nil
+}
+
+// indexStats prints what the index in INDEX_DIR holds: documents, terms
+// and postings overall and per field, segments and the share of deleted
+// documents a compact would drop.
+func indexStats() error {
+	ix, err := index.Open(indexDir())
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	s := ix.Stats()
+	w := os.Stdout
+	fmt.Fprintf(w, "documents  %d (%d deleted, %.1f%%; %d unflushed)\n", s.Docs, s.Deleted, 100*s.DeletedRatio(), s.Buffered)
+	fmt.Fprintf(w, "segments   %d, %s\n", s.Segments, formatBytes(s.Bytes))
+	fmt.Fprintf(w, "terms      %d\n", s.Terms)
+	fmt.Fprintf(w, "postings   %d\n", s.Postings)
+	for _, lang := range slices.Sorted(maps.Keys(s.Langs)) {
+		fmt.Fprintf(w, "language   %-4s %d documents\n", cmp.Or(lang, "-"), s.Langs[lang])
+	}
+	fmt.Fprintf(w, "\n%-10s %10s %12s %12s %10s\n", "field", "terms", "postings", "tokens", "size")
+	for _, f := range index.Fields {
+		fs := s.Fields[f]
+		fmt.Fprintf(w, "%-10s %10d %12d %12d %10s\n", f, fs.Terms, fs.Postings, fs.Tokens, formatBytes(fs.Bytes))
+	}
+	for _, f := range index.ShingleFields {
+		if fs := s.Fields[index.ShingleField(f)]; fs.Terms > 0 {
+			fmt.Fprintf(w, "%-10s %10d %12d %12s %10s\n", f+" pairs", fs.Terms, fs.Postings, "-", formatBytes(fs.Bytes))
+		}
+	}
+	return nil
+}
+
+// eachDocument hands add every stored page as an index.Document, with
+// the links to it from other pages, stopping at the first error.
+func eachDocument(ctx context.Context, store Store, add func(index.Document) error) error {
+	links, err := inboundLinks(ctx, store)
+	if err != nil {
+		return err
+	}
+	n := 0
+	return store.EachPage(ctx, indexProjection, func(p Page) error {
+		var in inbound
+		if l := links[p.URL]; l != nil {
+			in = *l
+		}
+		if err := add(indexDocument(p, in)); err != nil {
+			return err
+		}
+		if n++; n%IndexProgressEvery == 0 {
+			log.Printf("index: %d pages indexed", n)
+		}
+		return nil
+	})
+}
+
+// rebuildIndex builds the search index from scratch from the stored
+// pages and saves it to INDEX_DIR, replacing the old one only once it is
+// complete. It is the way to apply new analyzers to pages already
+// indexed, or to recover an index that won't open. The analyzers are
+// saved with the index and used by search.
+//
+// Pages are analyzed on INDEX_WORKERS goroutines (default one per CPU),
+// whose buffers share INDEX_MAX_BUFFERED documents (default
+// index.DefaultMaxBuffered) between them.
+//
+// A crawl running meanwhile saves its own copy of the index over the
+// rebuilt one; stop the crawler first.
+func rebuildIndex(ctx context.Context, store Store) error {
+	start := time.Now()
+	ix, err := index.Create(indexDir(), indexConfig())
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	ix.MaxBuffered = getEnvInt("INDEX_MAX_BUFFERED", index.DefaultMaxBuffered)
+	b, err := ix.Build(getEnvInt("INDEX_WORKERS", runtime.NumCPU()))
+	if err != nil {
+		return err
+	}
+	err = eachDocument(ctx, store, b.Add)
+	if cerr := b.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := ix.Compact(); err != nil {
+		return err
+	}
+	if err := ix.Save(indexDir()); err != nil {
+		return err
+	}
+	log.Printf("index: %d documents, %d terms saved to %s in %s", ix.Len(), ix.Terms(), indexDir(), time.Since(start).Round(time.Second))
+	return nil
+}
+
+// MaxSearchResults is how many results the search command prints.
+const MaxSearchResults = 10
+
+// SearchBackend answers queries for the search command. Results carry
+// what the index stores for a page; backends that lack a numeric field
+// leave it 0.
+type SearchBackend interface {
+	Search(ctx context.Context, query string, limit int, opts index.Options) ([]index.Result, error)
+	Close() error
+}
+
+// newSearchBackend opens the backend named by SEARCH_BACKEND: "index" (the
+// default), the index in INDEX_DIR, "mongo", a text index on the pages
+// collection, "bleve", a Bleve index in BLEVE_DIR, or "sqlite", an FTS5
+// index in the SQLite database at SQLITE_PATH; see mongoText, bleveIndex
+// and ftsIndex.
+func newSearchBackend(ctx context.Context) (SearchBackend, error) {
+	switch b := strings.ToLower(getEnv("SEARCH_BACKEND", "index")); b {
+	case "index":
+		return openIndexSearch()
+	case "mongo":
+		return openMongoText(ctx)
+	case "bleve":
+		return openBleve(bleveDir(), false, true)
+	case "sqlite":
+		return openFTS(sqlitePath(), false)
+	default:
+		return nil, fmt.Errorf("SEARCH_BACKEND: unknown backend %q (want index, mongo, bleve or sqlite)", b)
+	}
+}
+
+// indexSearch searches the index in INDEX_DIR.
+type indexSearch struct {
+	ix *index.Index
+}
+
+// openIndexSearch opens the index for searching. BM25_K1 and BM25_B tune
+// the ranking, BM25_B_<FIELD> (BM25_B_TITLE, ...) the length penalty of
+// one field, BM25_NORM what length is penalized: "field" (the default),
+// "document" or "none", see index.BM25; and BOOST_<FIELD> (BOOST_TITLE,
+// BOOST_ANCHORS, ...) the weight of each field. SEARCH_FUZZY=false turns
+// off typo correction and SEARCH_PROXIMITY=false the position-based bonus
+// for words close together.
+func openIndexSearch() (*indexSearch, error) {
+	norm := strings.ToLower(getEnv("BM25_NORM", index.NormField))
+	if !slices.Contains(index.Norms, norm) {
+		return nil, fmt.Errorf("BM25_NORM: unknown normalization %q (want field, document or none)", norm)
+	}
+	ix, err := index.Open(indexDir())
+	if err != nil {
+		return nil, err
+	}
+	ix.BM25 = index.BM25{
+		K1:     getEnvFloat("BM25_K1", index.DefaultBM25.K1),
+		B:      getEnvFloat("BM25_B", index.DefaultBM25.B),
+		FieldB: make(map[string]float64),
+		Norm:   norm,
+	}
+	for _, f := range index.Fields {
+		ix.BM25.FieldB[f] = getEnvFloat("BM25_B_"+strings.ToUpper(f), ix.BM25.B)
+	}
+	ix.Boosts = make(map[string]float64)
+	for _, f := range index.Fields {
+		ix.Boosts[f] = getEnvFloat("BOOST_"+strings.ToUpper(f), index.DefaultBoosts[f])
+	}
+	ix.Fuzzy = getEnvBool("SEARCH_FUZZY", true)
+	ix.Proximity = getEnvBool("SEARCH_PROXIMITY", true)
+	return &indexSearch{ix: ix}, nil
+}
+
+func (s *indexSearch) Search(_ context.Context, query string, limit int, opts index.Options) ([]index.Result, error) {
+	return s.ix.SearchWith(query, limit, opts), nil
+}
+
+func (s *indexSearch) Close() error { return s.ix.Close() }
+
+// runSearch queries the backend SEARCH_BACKEND picks and prints the best
+// matches; see searchOptions for filtering and sorting.
+func runSearch(query []string) error {
+	q := strings.Join(query, " ")
+	if q == "" {
+		return fmt.Errorf("search: no query")
+	}
+	opts, err := searchOptions()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	b, err := newSearchBackend(ctx)
+	if err != nil {
+		return err
+	}
+	defer b.Close()
+	results, err := b.Search(ctx, q, MaxSearchResults, opts)
+	if err != nil {
+		return err
+	}
+	for i, r := range results {
+		date := ""
+		if p := r.Values[index.NumPublished]; p != 0 {
+			date = time.Unix(p, 0).UTC().Format(time.DateOnly) + " "
+		}
+		fmt.Fprintf(os.Stdout, "%2d. %s\n    %s\n    %.3f %s%s\n", i+1, cmp.Or(r.Title, r.URL), r.URL, r.Score, date, truncateRunes(r.Snippet, 160))
+	}
+	return nil
+}
+
+// MaxSuggestions is how many titles the suggest command prints.
+const MaxSuggestions = 10
+
+// runSuggest prints the titles the saved index completes a partly typed
+// query to.
+func runSuggest(query []string) error {
+	ix, err := index.Open(indexDir())
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	for _, title := range ix.Suggest(strings.Join(query, " "), MaxSuggestions) {
+		fmt.Fprintln(os.Stdout, title)
+	}
+	return nil
+}
+
+// searchOptions reads SEARCH_LANG, which keeps pages in one language,
+// SEARCH_AFTER and SEARCH_BEFORE, which keep pages published in a date
+// range (2024-01-31), and SEARCH_SORT: "newest" for the latest published
+// first, or crawled, words or inlinks for the largest first.
+func searchOptions() (index.Options, error) {
+	opts := index.Options{Lang: getEnv("SEARCH_LANG", "")}
+	published := index.Range{Field: index.NumPublished}
+	if s := getEnv("SEARCH_AFTER", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return opts, fmt.Errorf("search: SEARCH_AFTER: bad date %q", s)
+		}
+		published.Min = t.Unix()
+	}
+	if s := getEnv("SEARCH_BEFORE", ""); s != "" {
+		t, ok := parseTimestamp(s)
+		if !ok {
+			return opts, fmt.Errorf("search: SEARCH_BEFORE: bad date %q", s)
+		}
+		published.Max = t.Unix() - 1
+	}
+	if published.Min != 0 || published.Max != 0 {
+		opts.Ranges = append(opts.Ranges, published)
+	}
+	switch s := getEnv("SEARCH_SORT", ""); {
+	case s == "" || s == "score":
+	case s == "newest":
+		opts.Sort = index.NumPublished
+	case slices.Contains(index.Numeric, s):
+		opts.Sort = s
+	default:
+		return opts, fmt.Errorf("search: SEARCH_SORT: unknown field %q", s)
+	}
+	return opts, nil
+}
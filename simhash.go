@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// ----- Near-duplicate detection -----
+
+const (
+	SimHashShingle   = 3     // words per shingle
+	SimHashMaxDist   = 3     // max differing bits to count as near-duplicate
+	SimHashWindow    = 10000 // recent fingerprints kept for comparison
+	MinSimHashTokens = 50    // shorter texts fingerprint too unreliably
+)
+
+// simHash is a 64-bit Charikar fingerprint over word shingles. Pages that
+// differ only in boilerplate end up a few bits apart.
+func simHash(text string) (uint64, bool) {
+	words := strings.Fields(strings.ToLower(text))
+	if len(words) < MinSimHashTokens {
+		return 0, false
+	}
+
+	var v [64]int
+	h := fnv.New64a()
+	for i := 0; i+SimHashShingle <= len(words); i++ {
+		h.Reset()
+		h.Write([]byte(strings.Join(words[i:i+SimHashShingle], " ")))
+		sum := h.Sum64()
+		for b := 0; b < 64; b++ {
+			if sum&(1<<b) != 0 {
+				v[b]++
+			} else {
+				v[b]--
+			}
+		}
+	}
+
+	var fp uint64
+	for b := 0; b < 64; b++ {
+		if v[b] > 0 {
+			fp |= 1 << b
+		}
+	}
+	return fp, true
+}
+
+type simEntry struct {
+	url string
+	fp  uint64
+}
+
+// simhashWindow is a ring buffer of recently stored fingerprints.
+type simhashWindow struct {
+	mu      sync.Mutex
+	entries []simEntry
+	next    int
+}
+
+func newSimhashWindow() *simhashWindow {
+	return &simhashWindow{entries: make([]simEntry, 0, SimHashWindow)}
+}
+
+// Nearest returns the closest recent page within SimHashMaxDist bits.
+func (w *simhashWindow) Nearest(fp uint64, except string) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	best, bestDist := "", SimHashMaxDist+1
+	for _, e := range w.entries {
+		if e.url == except {
+			continue
+		}
+		if d := bits.OnesCount64(e.fp ^ fp); d < bestDist {
+			best, bestDist = e.url, d
+		}
+	}
+	return best, best != ""
+}
+
+func (w *simhashWindow) Add(url string, fp uint64) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.entries) < SimHashWindow {
+		w.entries = append(w.entries, simEntry{url: url, fp: fp})
+		return
+	}
+	w.entries[w.next] = simEntry{url: url, fp: fp}
+	w.next = (w.next + 1) % SimHashWindow
+}
+
+// loadRecent primes the window with the most recently crawled pages.
+func (w *simhashWindow) loadRecent(ctx context.Context, store Store) error {
+	return store.RecentSimHashes(ctx, SimHashWindow, w.Add)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ----- Sitemaps -----
+
+const (
+	MaxSitemapBytes = 50 * 1024 * 1024 // protocol limit for an uncompressed sitemap
+	MaxSitemapURLs  = 5000             // per seed origin
+	MaxSitemapFiles = 50               // sitemap index fan-out guard
+)
+
+// sitemapXML covers both <urlset> and <sitemapindex> roots.
+type sitemapXML struct {
+	URLs     []sitemapLoc `xml:"url"`
+	Sitemaps []sitemapLoc `xml:"sitemap"`
+}
+
+type sitemapLoc struct {
+	Loc string `xml:"loc"`
+}
+
+// discoverSitemaps returns the Sitemap: entries from robots.txt, or the
+// conventional /sitemap.xml when robots.txt lists none.
+func discoverSitemaps(ctx context.Context, robots *robotsCache, origin *url.URL) []string {
+	if robots != nil {
+		if maps := robots.rulesFor(ctx, origin).sitemaps; len(maps) > 0 {
+			return maps
+		}
+	}
+	return []string{origin.Scheme + "://" + origin.Host + "/sitemap.xml"}
+}
+
+// sitemapURLs walks sitemap indexes breadth-first and collects page URLs.
+func sitemapURLs(ctx context.Context, client *http.Client, roots []string) []string {
+	pending := append([]string(nil), roots...)
+	seen := make(map[string]bool)
+	var pages []string
+
+	for len(pending) > 0 && len(seen) < MaxSitemapFiles && len(pages) < MaxSitemapURLs {
+		sm := pending[0]
+		pending = pending[1:]
+		if seen[sm] {
+			continue
+		}
+		seen[sm] = true
+
+		parsed, err := fetchSitemap(ctx, client, sm)
+		if err != nil {
+			log.Printf("sitemap: %s: %v", sm, err)
+			continue
+		}
+		for _, s := range parsed.Sitemaps {
+			if loc := strings.TrimSpace(s.Loc); loc != "" {
+				pending = append(pending, loc)
+			}
+		}
+		for _, u := range parsed.URLs {
+			if loc := strings.TrimSpace(u.Loc); loc != "" && len(pages) < MaxSitemapURLs {
+				pages = append(pages, loc)
+			}
+		}
+	}
+	return pages
+}
+
+func fetchSitemap(ctx context.Context, client *http.Client, sm string) (*sitemapXML, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sm, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+
+	// .xml.gz files arrive as gzip bytes rather than Content-Encoding,
+	// so sniff the magic number instead of trusting headers.
+	br := bufio.NewReader(resp.Body)
+	var body io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	var parsed sitemapXML
+	if err := xml.NewDecoder(io.LimitReader(body, MaxSitemapBytes)).Decode(&parsed); err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	s3creds "github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/realutkarshh/mini-search-crawler/index"
+)
+
+// ----- Index snapshots -----
+
+// snapshotStore keeps the files of index snapshots, by name.
+type snapshotStore interface {
+	Put(ctx context.Context, name string, r io.Reader, size int64) error
+	Get(ctx context.Context, name string) (io.ReadCloser, error)
+}
+
+// openSnapshotStore opens target, "s3://bucket/prefix" or a directory.
+func openSnapshotStore(target string) (snapshotStore, error) {
+	if rest, ok := strings.CutPrefix(target, "s3://"); ok {
+		return openS3Snapshots(rest)
+	}
+	return dirSnapshots(target), nil
+}
+
+// dirSnapshots keeps a snapshot in a directory, which is then an index
+// itself: SEARCH_BACKEND=index with INDEX_DIR set to it searches it.
+type dirSnapshots string
+
+func (d dirSnapshots) Put(_ context.Context, name string, r io.Reader, _ int64) error {
+	if err := os.MkdirAll(string(d), 0o755); err != nil {
+		return err
+	}
+	p := filepath.Join(string(d), name)
+	f, err := os.Create(p + ".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(p+".tmp", p)
+	}
+	if err != nil {
+		os.Remove(p + ".tmp")
+	}
+	return err
+}
+
+func (d dirSnapshots) Get(_ context.Context, name string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(string(d), name))
+}
+
+// s3Snapshots keeps a snapshot under a prefix of an S3 bucket. S3_ENDPOINT
+// picks an S3-compatible service other than AWS, with http:// for one
+// without TLS, and S3_REGION the bucket's region. Credentials are the
+// usual AWS ones: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY,
+// ~/.aws/credentials or the instance's IAM role.
+type s3Snapshots struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+func openS3Snapshots(target string) (*s3Snapshots, error) {
+	bucket, prefix, _ := strings.Cut(target, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("s3: no bucket in s3://%s", target)
+	}
+	endpoint := getEnv("S3_ENDPOINT", "s3.amazonaws.com")
+	secure := !strings.HasPrefix(endpoint, "http://")
+	endpoint = strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://")
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: s3creds.NewChainCredentials([]s3creds.Provider{
+			&s3creds.EnvAWS{},
+			&s3creds.FileAWSCredentials{},
+			&s3creds.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		}),
+		Secure: secure,
+		Region: getEnv("S3_REGION", ""),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("s3: %w", err)
+	}
+	return &s3Snapshots{client: client, bucket: bucket, prefix: strings.Trim(prefix, "/")}, nil
+}
+
+func (s *s3Snapshots) key(name string) string {
+	return path.Join(s.prefix, name)
+}
+
+func (s *s3Snapshots) Put(ctx context.Context, name string, r io.Reader, size int64) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), r, size, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+// Get fails at once for a missing object, rather than at the first read.
+func (s *s3Snapshots) Get(ctx context.Context, name string) (io.ReadCloser, error) {
+	obj, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := obj.Stat(); err != nil {
+		obj.Close()
+		return nil, err
+	}
+	return obj, nil
+}
+
+// runSnapshot copies the index in INDEX_DIR to target as it was last
+// saved; a crawl may go on writing to it meanwhile. Each snapshot should
+// get a target of its own, as one written over another leaves the old
+// one's files behind.
+func runSnapshot(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("snapshot: want one target directory or s3://bucket/prefix")
+	}
+	if bleveBackend() || sqliteBackend() {
+		return fmt.Errorf("snapshot: not supported with SEARCH_BACKEND=%s", getEnv("SEARCH_BACKEND", ""))
+	}
+	start := time.Now()
+	dst, err := openSnapshotStore(args[0])
+	if err != nil {
+		return err
+	}
+	ix, err := index.Open(indexDir())
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	ctx := context.Background()
+	if err := ix.Snapshot(func(name string, r io.Reader, size int64) error { return dst.Put(ctx, name, r, size) }); err != nil {
+		return err
+	}
+	log.Printf("snapshot: %d documents copied from %s to %s in %s", ix.Len(), indexDir(), args[0], time.Since(start).Round(time.Second))
+	return nil
+}
+
+// runRestore replaces the index in INDEX_DIR with the snapshot at source,
+// without the store: a query server can be set up from a snapshot alone.
+// Searches read the old index until the new one is complete. A crawl
+// writing to INDEX_DIR meanwhile would save over the restored index.
+func runRestore(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("restore: want one source directory or s3://bucket/prefix")
+	}
+	start := time.Now()
+	src, err := openSnapshotStore(args[0])
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	n, err := index.Restore(indexDir(), func(name string) (io.ReadCloser, error) { return src.Get(ctx, name) })
+	if err != nil {
+		return err
+	}
+	log.Printf("restore: %d documents copied from %s to %s in %s", n, args[0], indexDir(), time.Since(start).Round(time.Second))
+	return nil
+}
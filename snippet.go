@@ -0,0 +1,136 @@
+package main
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// ----- Snippets -----
+
+const (
+	MaxSnippetChars      = 300
+	MinSentenceChars     = 40  // shorter ones are captions, labels and "Read more"
+	MaxSentenceChars     = 400 // longer ones are usually run-together lists
+	MaxSnippetCandidates = 60  // sentences scored, from the top of the page
+	MinContentWordRunes  = 4   // words this long or longer count as content words
+)
+
+// sentenceEndRE matches the end of a sentence: terminal punctuation, any
+// closing quotes or brackets, then whitespace. CJK full stops need no space.
+var sentenceEndRE = regexp.MustCompile(`[.!?…]+["'”’)\]]*\s+|[。！？]+`)
+
+// summarize builds a description from passages (paragraphs, in page order)
+// for pages that don't declare one. Sentences are scored by how many of
+// the page's frequent words they use, favoring the top of the page, and
+// the best are taken in page order up to MaxSnippetChars. Without any
+// sentence of a usable length the passages' opening is used instead.
+func summarize(passages []string) string {
+	var sentences []string
+	for _, p := range passages {
+		// A whole document passed as one passage only needs its top split.
+		p = truncateRunes(p, MaxSnippetCandidates*MaxSentenceChars)
+		for _, s := range splitSentences(p) {
+			if len(sentences) == MaxSnippetCandidates {
+				break
+			}
+			sentences = append(sentences, s)
+		}
+	}
+
+	tf := make(map[string]int)
+	words := make([][]string, len(sentences))
+	for i, s := range sentences {
+		words[i] = contentWords(s)
+		for _, w := range words[i] {
+			tf[w]++
+		}
+	}
+
+	type candidate struct {
+		i     int
+		score float64
+	}
+	var cands []candidate
+	for i, s := range sentences {
+		if n := len(s); n < MinSentenceChars || n > MaxSentenceChars || len(words[i]) == 0 {
+			continue
+		}
+		// Mean frequency of the sentence's distinct content words, so long
+		// sentences don't win on length alone; repeated words within the
+		// sentence count once.
+		var sum float64
+		seen := make(map[string]bool)
+		for _, w := range words[i] {
+			if !seen[w] {
+				seen[w] = true
+				sum += math.Log1p(float64(tf[w] - 1))
+			}
+		}
+		density := sum / float64(len(seen))
+		position := 1 / math.Sqrt(float64(i+1))
+		cands = append(cands, candidate{i, density + position})
+	}
+	if len(cands) == 0 {
+		return truncateRunes(cleanText(strings.Join(passages, " ")), MaxSnippetChars)
+	}
+
+	slices.SortStableFunc(cands, func(a, b candidate) int {
+		switch {
+		case a.score > b.score:
+			return -1
+		case a.score < b.score:
+			return 1
+		}
+		return 0
+	})
+	var picked []int
+	length := 0
+	for _, c := range cands {
+		n := len(sentences[c.i]) + 1
+		if len(picked) > 0 && length+n > MaxSnippetChars {
+			continue
+		}
+		picked = append(picked, c.i)
+		length += n
+	}
+	slices.Sort(picked)
+	out := make([]string, len(picked))
+	for k, i := range picked {
+		out[k] = sentences[i]
+	}
+	return truncateRunes(strings.Join(out, " "), MaxSnippetChars)
+}
+
+// splitSentences cuts text after sentence-ending punctuation.
+func splitSentences(text string) []string {
+	var out []string
+	start := 0
+	for _, loc := range sentenceEndRE.FindAllStringIndex(text, -1) {
+		if s := cleanText(text[start:loc[1]]); s != "" {
+			out = append(out, s)
+		}
+		start = loc[1]
+	}
+	if s := cleanText(text[start:]); s != "" {
+		out = append(out, s)
+	}
+	return out
+}
+
+// contentWords lowercases s's words of at least MinContentWordRunes
+// letters; shorter ones are mostly function words in the languages we
+// see most.
+func contentWords(s string) []string {
+	var out []string
+	for _, w := range strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	}) {
+		if len([]rune(w)) >= MinContentWordRunes {
+			out = append(out, w)
+		}
+	}
+	return out
+}
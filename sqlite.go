@@ -0,0 +1,503 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	_ "modernc.org/sqlite" // database/sql driver "sqlite", with FTS5
+)
+
+// ----- SQLite store -----
+
+// DefaultSQLitePath is the database file STORE=sqlite and
+// SEARCH_BACKEND=sqlite use unless SQLITE_PATH says otherwise.
+const DefaultSQLitePath = "search.db"
+
+func sqlitePath() string {
+	return getEnv("SQLITE_PATH", DefaultSQLitePath)
+}
+
+// openSQLite opens the database file at path, creating it if needed.
+// Writers wait on each other for up to SQLITE_BUSY_TIMEOUT; with the
+// write-ahead log, readers such as a search go on meanwhile.
+func openSQLite(path string) (*sql.DB, error) {
+	busy := getEnvDuration("SQLITE_BUSY_TIMEOUT", 10*time.Second)
+	dsn := fmt.Sprintf("file:%s?_pragma=busy_timeout(%d)&_pragma=journal_mode(WAL)&_pragma=synchronous(NORMAL)&_txlock=immediate", path, busy.Milliseconds())
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: %s: %w", path, err)
+	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %s: %w", path, err)
+	}
+	return db, nil
+}
+
+// sqliteSchema holds what mongoStore keeps in collections. Documents are
+// stored as BSON, encoded as for Mongo, beside the columns they are looked
+// up by; times are Unix milliseconds.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS pages (
+	url          TEXT PRIMARY KEY,
+	content_hash TEXT NOT NULL,
+	next_crawl   INTEGER NOT NULL,
+	crawl_time   INTEGER NOT NULL,
+	simhash      INTEGER NOT NULL,
+	doc          BLOB NOT NULL
+);
+CREATE INDEX IF NOT EXISTS pages_content_hash ON pages (content_hash);
+CREATE INDEX IF NOT EXISTS pages_next_crawl ON pages (next_crawl);
+CREATE INDEX IF NOT EXISTS pages_crawl_time ON pages (crawl_time);
+CREATE TABLE IF NOT EXISTS aliases (
+	alias TEXT NOT NULL,
+	url   TEXT NOT NULL,
+	PRIMARY KEY (alias, url)
+);
+CREATE TABLE IF NOT EXISTS links (
+	src    TEXT NOT NULL,
+	dst    TEXT NOT NULL,
+	anchor TEXT NOT NULL,
+	PRIMARY KEY (src, dst)
+);
+CREATE INDEX IF NOT EXISTS links_dst ON links (dst);
+CREATE TABLE IF NOT EXISTS raw_pages (
+	url TEXT PRIMARY KEY,
+	doc BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS crawl_errors (
+	url       TEXT PRIMARY KEY,
+	permanent INTEGER NOT NULL,
+	time      INTEGER NOT NULL,
+	doc       BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS robots_txt (
+	origin     TEXT PRIMARY KEY,
+	expires_at INTEGER NOT NULL,
+	doc        BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS crawl_checkpoints (
+	run_id TEXT PRIMARY KEY,
+	doc    BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS crawl_runs (
+	id  TEXT PRIMARY KEY,
+	doc BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS crawl_locks (
+	id         TEXT PRIMARY KEY,
+	owner      TEXT NOT NULL,
+	expires_at INTEGER NOT NULL
+);
+`
+
+// sqliteStore keeps everything in one SQLite file, for a crawler, index
+// and search on one machine with no database server: a personal site, or
+// development. Pages keep their aliases and outgoing links in tables of
+// their own so lookups by alias and link target are indexed.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+// openSQLiteStore opens the store at path, creating its tables if needed.
+func openSQLiteStore(path string) (*sqliteStore, error) {
+	db, err := openSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite: %s: %w", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+// queryer is what sqliteStore reads through: the database or a
+// transaction.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// getDoc decodes the BSON document query selects into v, reporting
+// whether there was one.
+func getDoc(ctx context.Context, q queryer, v any, query string, args ...any) (bool, error) {
+	var doc []byte
+	err := q.QueryRowContext(ctx, query, args...).Scan(&doc)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, bson.Unmarshal(doc, v)
+}
+
+// inTx runs fn in a transaction, committing it if fn succeeds.
+func (s *sqliteStore) inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// pageKey is the URL of the page stored under pageURL or with it as an
+// alias, or "" for none.
+func pageKey(ctx context.Context, q queryer, pageURL string) (string, error) {
+	var key string
+	err := q.QueryRowContext(ctx, `SELECT url FROM pages WHERE url = ?1
+		UNION ALL SELECT url FROM aliases WHERE alias = ?1 LIMIT 1`, pageURL).Scan(&key)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return key, err
+}
+
+// setPageFields overlays set on the page stored under key and removes the
+// fields of unset, as Mongo's $set and $unset do, storing a new page if
+// there is none, and refreshes its lookup columns and links.
+func setPageFields(ctx context.Context, tx *sql.Tx, key string, set bson.M, unset ...string) error {
+	doc := bson.M{}
+	if _, err := getDoc(ctx, tx, &doc, `SELECT doc FROM pages WHERE url = ?`, key); err != nil {
+		return err
+	}
+	for k, v := range set {
+		doc[k] = v
+	}
+	for _, k := range unset {
+		delete(doc, k)
+	}
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	var p Page
+	if err := bson.Unmarshal(raw, &p); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO pages (url, content_hash, next_crawl, crawl_time, simhash, doc)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (url) DO UPDATE SET content_hash = excluded.content_hash, next_crawl = excluded.next_crawl,
+			crawl_time = excluded.crawl_time, simhash = excluded.simhash, doc = excluded.doc`,
+		key, p.ContentHash, p.NextCrawl.UnixMilli(), p.CrawlTime.UnixMilli(), p.SimHash, raw)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM links WHERE src = ?`, key); err != nil {
+		return err
+	}
+	for _, l := range p.Links {
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO links (src, dst, anchor) VALUES (?, ?, ?)`, key, l.URL, l.AnchorText); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) UpsertPage(ctx context.Context, p Page) error {
+	p, aliases := cleanPage(p)
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		return err
+	}
+	set := bson.M{}
+	if err := bson.Unmarshal(raw, &set); err != nil {
+		return err
+	}
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		if err := setPageFields(ctx, tx, p.URL, set, unsetFields(raw)...); err != nil {
+			return err
+		}
+		for _, a := range aliases {
+			if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO aliases (alias, url) VALUES (?, ?)`, a, p.URL); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *sqliteStore) Page(ctx context.Context, pageURL string) (*Page, error) {
+	key, err := pageKey(ctx, s.db, pageURL)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	var p Page
+	if ok, err := getDoc(ctx, s.db, &p, `SELECT doc FROM pages WHERE url = ?`, key); !ok || err != nil {
+		return nil, err
+	}
+	p.Aliases, err = scanStrings(s.db.QueryContext(ctx, `SELECT alias FROM aliases WHERE url = ? ORDER BY rowid`, key))
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *sqliteStore) PageMeta(ctx context.Context, pageURL string) (*pageMeta, error) {
+	key, err := pageKey(ctx, s.db, pageURL)
+	if err != nil || key == "" {
+		return nil, err
+	}
+	var meta pageMeta
+	if ok, err := getDoc(ctx, s.db, &meta, `SELECT doc FROM pages WHERE url = ?`, key); !ok || err != nil {
+		return nil, err
+	}
+	return &meta, nil
+}
+
+func (s *sqliteStore) TouchPage(ctx context.Context, pageURL string, sched recrawlSchedule) error {
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		key, err := pageKey(ctx, tx, pageURL)
+		if err != nil || key == "" {
+			return err
+		}
+		return setPageFields(ctx, tx, key, sched.touched())
+	})
+}
+
+func (s *sqliteStore) FindDuplicate(ctx context.Context, hash, pageURL string) (string, error) {
+	var orig string
+	err := s.db.QueryRowContext(ctx, `SELECT url FROM pages WHERE content_hash = ? AND url != ? LIMIT 1`, hash, pageURL).Scan(&orig)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return orig, err
+}
+
+func (s *sqliteStore) AddAlias(ctx context.Context, pageURL, alias string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT OR IGNORE INTO aliases (alias, url) SELECT ?, url FROM pages WHERE url = ?`, alias, pageURL)
+	return err
+}
+
+// scanStrings scans the single column of rows.
+func scanStrings(rows *sql.Rows, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		out = append(out, u)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) DueForRecrawl(ctx context.Context, limit int) ([]string, error) {
+	return scanStrings(s.db.QueryContext(ctx, `SELECT url FROM pages WHERE next_crawl <= ? ORDER BY next_crawl LIMIT ?`,
+		time.Now().UTC().UnixMilli(), limit))
+}
+
+func (s *sqliteStore) RecentSimHashes(ctx context.Context, limit int, add func(string, uint64)) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT url, simhash FROM pages WHERE simhash != 0 ORDER BY crawl_time DESC LIMIT ?`, limit)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u string
+		var fp int64
+		if err := rows.Scan(&u, &fp); err != nil {
+			return err
+		}
+		add(u, uint64(fp))
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) LinksTo(ctx context.Context, pageURL string) (inbound, error) {
+	var in inbound
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM links WHERE dst = ? AND src != dst`, pageURL).Scan(&in.pages)
+	if err != nil {
+		return in, err
+	}
+	anchors, err := scanStrings(s.db.QueryContext(ctx, `SELECT anchor FROM links WHERE dst = ? AND src != dst AND anchor != '' LIMIT ?`, pageURL, MaxAnchors))
+	seen := make(map[string]bool)
+	for _, a := range anchors {
+		if key := strings.ToLower(a); !seen[key] {
+			seen[key] = true
+			in.anchors = append(in.anchors, a)
+		}
+	}
+	return in, err
+}
+
+// EachPage decodes whole pages; projection is not applied.
+func (s *sqliteStore) EachPage(ctx context.Context, _ bson.M, fn func(Page) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM pages ORDER BY url`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc []byte
+		if err := rows.Scan(&doc); err != nil {
+			return err
+		}
+		var p Page
+		if err := bson.Unmarshal(doc, &p); err != nil {
+			log.Printf("store: skipping page: %v", err)
+			continue
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) RecordCrawlError(ctx context.Context, pageURL string, err error) error {
+	ce := newCrawlError(pageURL, err)
+	return s.inTx(ctx, func(tx *sql.Tx) error {
+		var prev CrawlError
+		if _, err := getDoc(ctx, tx, &prev, `SELECT doc FROM crawl_errors WHERE url = ?`, ce.URL); err != nil {
+			return err
+		}
+		ce.Failures = prev.Failures + 1
+		doc, err := bson.Marshal(ce)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `INSERT OR REPLACE INTO crawl_errors (url, permanent, time, doc) VALUES (?, ?, ?, ?)`,
+			ce.URL, ce.Permanent, ce.Time.UnixMilli(), doc)
+		return err
+	})
+}
+
+func (s *sqliteStore) ClearCrawlError(ctx context.Context, pageURL string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM crawl_errors WHERE url = ?`, pageURL)
+	return err
+}
+
+func (s *sqliteStore) PermanentlyFailed(ctx context.Context, pageURL string, ttl time.Duration) bool {
+	if ttl <= 0 {
+		return false
+	}
+	var one int
+	err := s.db.QueryRowContext(ctx, `SELECT 1 FROM crawl_errors WHERE url = ? AND permanent AND time > ?`,
+		pageURL, time.Now().UTC().Add(-ttl).UnixMilli()).Scan(&one)
+	return err == nil
+}
+
+func (s *sqliteStore) StoreRaw(ctx context.Context, pageURL string, res *fetchResult) error {
+	raw, err := newRawPage(pageURL, res)
+	if err != nil {
+		return err
+	}
+	doc, err := bson.Marshal(raw)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO raw_pages (url, doc) VALUES (?, ?)`, pageURL, doc)
+	return err
+}
+
+func (s *sqliteStore) EachRaw(ctx context.Context, only string, fn func(*rawPage) error) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT doc FROM raw_pages WHERE ?1 = '' OR url = ?1 ORDER BY url`, only)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var doc []byte
+		if err := rows.Scan(&doc); err != nil {
+			return err
+		}
+		var raw rawPage
+		if err := bson.Unmarshal(doc, &raw); err != nil {
+			return err
+		}
+		if err := fn(&raw); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (s *sqliteStore) CachedRobots(ctx context.Context, origin string) (*storedRobots, error) {
+	var doc storedRobots
+	ok, err := getDoc(ctx, s.db, &doc, `SELECT doc FROM robots_txt WHERE origin = ? AND expires_at > ?`, origin, time.Now().UTC().UnixMilli())
+	if !ok || err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *sqliteStore) CacheRobots(ctx context.Context, doc storedRobots) error {
+	raw, err := bson.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO robots_txt (origin, expires_at, doc) VALUES (?, ?, ?)`,
+		doc.Origin, doc.ExpiresAt.UnixMilli(), raw)
+	return err
+}
+
+func (s *sqliteStore) SaveCheckpoint(ctx context.Context, cp checkpoint) error {
+	doc, err := bson.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO crawl_checkpoints (run_id, doc) VALUES (?, ?)`, cp.RunID.Hex(), doc)
+	return err
+}
+
+func (s *sqliteStore) LoadCheckpoint(ctx context.Context, runID string) (*checkpoint, error) {
+	id, err := runIDFromHex(runID)
+	if err != nil {
+		return nil, err
+	}
+	var cp checkpoint
+	ok, err := getDoc(ctx, s.db, &cp, `SELECT doc FROM crawl_checkpoints WHERE run_id = ?`, id.Hex())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("resume: no checkpoint for run %s", runID)
+	}
+	return &cp, nil
+}
+
+func (s *sqliteStore) SaveRun(ctx context.Context, run CrawlRun) error {
+	doc, err := bson.Marshal(run)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `INSERT OR REPLACE INTO crawl_runs (id, doc) VALUES (?, ?)`, run.ID.Hex(), doc)
+	return err
+}
+
+func (s *sqliteStore) AcquireCrawlLock(ctx context.Context, owner string, lease time.Duration) (bool, error) {
+	now := time.Now().UTC()
+	res, err := s.db.ExecContext(ctx, `INSERT INTO crawl_locks (id, owner, expires_at) VALUES ('crawl', ?1, ?2)
+		ON CONFLICT (id) DO UPDATE SET owner = excluded.owner, expires_at = excluded.expires_at
+		WHERE crawl_locks.expires_at < ?3 OR crawl_locks.owner = ?1`,
+		owner, now.Add(lease).UnixMilli(), now.UnixMilli())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+func (s *sqliteStore) ReleaseCrawlLock(ctx context.Context, owner string) {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM crawl_locks WHERE id = 'crawl' AND owner = ?`, owner)
+	if err != nil {
+		log.Printf("crawl lock: %v", err)
+	}
+}
+
+func (s *sqliteStore) Close() error { return s.db.Close() }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"os"
+	"syscall"
+)
+
+// ----- SSRF protection -----
+
+// errBlockedAddress is returned for connections to non-public addresses.
+var errBlockedAddress = errors.New("refusing to connect to a non-public address")
+
+// blockedPrefixes are ranges a crawler has no business fetching from on
+// behalf of arbitrary links, beyond what netip classifies as private.
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // "this network"
+	netip.MustParsePrefix("100.64.0.0/10"), // carrier-grade NAT, some cloud metadata services
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF protocol assignments
+	netip.MustParsePrefix("198.18.0.0/15"), // benchmarking
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64, may map to private IPv4
+}
+
+// blockedAddr reports whether ip is loopback, private (RFC 1918 / ULA),
+// link-local (which includes the 169.254.169.254 metadata service),
+// multicast, unspecified or otherwise reserved.
+func blockedAddr(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, p := range blockedPrefixes {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// netGuard keeps the crawler off private networks. Direct connections are
+// checked at dial time, after DNS resolution, so redirects and DNS
+// rebinding are covered too. Requests sent through a proxy are resolved
+// and checked up front instead, while the proxies themselves may live on a
+// private network. ALLOW_PRIVATE_NETWORKS=true turns the guard off, for
+// crawling an intranet or testing against localhost.
+type netGuard struct {
+	disabled bool
+	proxies  map[string]bool // proxy host:port, exempt at dial time
+}
+
+func newNetGuard(pool *proxyPool) *netGuard {
+	g := &netGuard{
+		disabled: getEnvBool("ALLOW_PRIVATE_NETWORKS", false),
+		proxies:  make(map[string]bool),
+	}
+	var proxies []*url.URL
+	if pool != nil {
+		proxies = pool.proxies
+	}
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy"} {
+		if u, err := url.Parse(os.Getenv(name)); err == nil && u.Host != "" {
+			proxies = append(proxies, u)
+		}
+	}
+	for _, u := range proxies {
+		g.proxies[proxyAddr(u)] = true
+	}
+	return g
+}
+
+// proxyAddr is the address http.Transport dials for proxy u.
+func proxyAddr(u *url.URL) string {
+	port := u.Port()
+	if port == "" {
+		switch u.Scheme {
+		case "https":
+			port = "443"
+		case "socks5", "socks5h":
+			port = "1080"
+		default:
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// dialContext wraps dialer so that every connection it makes, other than
+// to a configured proxy, is refused if it would reach a blocked address.
+func (g *netGuard) dialContext(dialer *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if g.disabled {
+		return dialer.DialContext
+	}
+	guarded := *dialer
+	guarded.Control = func(_, address string, _ syscall.RawConn) error {
+		ap, err := netip.ParseAddrPort(address)
+		if err != nil {
+			return err
+		}
+		if blockedAddr(ap.Addr()) {
+			return fmt.Errorf("%w: %s", errBlockedAddress, ap.Addr())
+		}
+		return nil
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if g.proxies[addr] {
+			return dialer.DialContext(ctx, network, addr)
+		}
+		return guarded.DialContext(ctx, network, addr)
+	}
+}
+
+// CheckHost resolves host and fails if any of its addresses is blocked.
+// It is used where the crawler does not make the connection itself.
+func (g *netGuard) CheckHost(ctx context.Context, host string) error {
+	if g.disabled {
+		return nil
+	}
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if blockedAddr(ip) {
+			return fmt.Errorf("%w: %s", errBlockedAddress, ip)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+	for _, ip := range addrs {
+		if blockedAddr(ip) {
+			return fmt.Errorf("%w: %s resolves to %s", errBlockedAddress, host, ip)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ----- Storage -----
+
+// Store keeps what the crawler stores between fetches and runs: pages and
+// their raw bodies, crawl errors, cached robots.txt files, checkpoints,
+// the run log and the crawl lock. STORE picks the implementation; see
+// openStore.
+type Store interface {
+	// UpsertPage stores p under its URL, replacing the stored fields and
+	// removing the extractedFields p leaves empty, and adds p's aliases to
+	// the stored ones.
+	UpsertPage(ctx context.Context, p Page) error
+	// Page and PageMeta find a page by its URL or an alias; a page never
+	// stored is nil, nil.
+	Page(ctx context.Context, pageURL string) (*Page, error)
+	PageMeta(ctx context.Context, pageURL string) (*pageMeta, error)
+	// TouchPage records a 304 revisit: content unchanged, only crawl_time
+	// and the recrawl schedule move.
+	TouchPage(ctx context.Context, pageURL string, sched recrawlSchedule) error
+	// FindDuplicate returns the URL of another stored page with the given
+	// content hash, or "" when there is none.
+	FindDuplicate(ctx context.Context, hash, pageURL string) (string, error)
+	AddAlias(ctx context.Context, pageURL, alias string) error
+	// DueForRecrawl lists stored pages whose next_crawl has passed,
+	// oldest first.
+	DueForRecrawl(ctx context.Context, limit int) ([]string, error)
+	// RecentSimHashes hands add the fingerprints of up to limit pages,
+	// the most recently crawled first.
+	RecentSimHashes(ctx context.Context, limit int, add func(pageURL string, fp uint64)) error
+	// LinksTo is inboundLinks for one page; see linksTo.
+	LinksTo(ctx context.Context, pageURL string) (inbound, error)
+	// EachPage hands fn every stored page, stopping at the first error.
+	// Stores may fill in only the fields of projection.
+	EachPage(ctx context.Context, projection bson.M, fn func(Page) error) error
+
+	RecordCrawlError(ctx context.Context, pageURL string, err error) error
+	ClearCrawlError(ctx context.Context, pageURL string) error
+	// PermanentlyFailed reports whether pageURL hit a permanent error
+	// within ttl.
+	PermanentlyFailed(ctx context.Context, pageURL string, ttl time.Duration) bool
+
+	StoreRaw(ctx context.Context, pageURL string, res *fetchResult) error
+	// EachRaw hands fn every stored raw body, or just the one of only.
+	EachRaw(ctx context.Context, only string, fn func(*rawPage) error) error
+
+	// CachedRobots returns origin's robots.txt if it is stored and not
+	// expired, else nil, nil.
+	CachedRobots(ctx context.Context, origin string) (*storedRobots, error)
+	CacheRobots(ctx context.Context, doc storedRobots) error
+
+	SaveCheckpoint(ctx context.Context, cp checkpoint) error
+	LoadCheckpoint(ctx context.Context, runID string) (*checkpoint, error)
+	// SaveRun records run, replacing an earlier record of it.
+	SaveRun(ctx context.Context, run CrawlRun) error
+	// AcquireCrawlLock takes the single crawl lock if it is free or
+	// expired. The lease outlives a run's timeout so a crashed holder
+	// frees it eventually.
+	AcquireCrawlLock(ctx context.Context, owner string, lease time.Duration) (bool, error)
+	ReleaseCrawlLock(ctx context.Context, owner string)
+
+	Close() error
+}
+
+// openStore opens the store named by STORE: "mongo" (the default), the
+// database at MONGO_URI, or "sqlite", a file at SQLITE_PATH for running
+// on one machine with no database server.
+func openStore(ctx context.Context) (Store, error) {
+	switch s := strings.ToLower(getEnv("STORE", "mongo")); s {
+	case "mongo":
+		client, db, err := connectMongo(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := ensureIndexes(ctx, db); err != nil {
+			client.Disconnect(context.Background())
+			return nil, err
+		}
+		return &mongoStore{client: client, db: db}, nil
+	case "sqlite":
+		return openSQLiteStore(sqlitePath())
+	default:
+		return nil, fmt.Errorf("STORE: unknown store %q (want mongo or sqlite)", s)
+	}
+}
+
+// mongoStore keeps everything in Mongo collections: pages, raw_pages,
+// crawl_errors, robots_txt, crawl_checkpoints, crawl_runs and
+// crawl_locks.
+type mongoStore struct {
+	client *mongo.Client
+	db     *mongo.Database
+}
+
+func (s *mongoStore) pages() *mongo.Collection { return s.db.Collection("pages") }
+
+func (s *mongoStore) UpsertPage(ctx context.Context, p Page) error {
+	return upsertPage(ctx, s.pages(), p)
+}
+
+func (s *mongoStore) Page(ctx context.Context, pageURL string) (*Page, error) {
+	var p Page
+	err := s.pages().FindOne(ctx, pageFilter(pageURL)).Decode(&p)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (s *mongoStore) PageMeta(ctx context.Context, pageURL string) (*pageMeta, error) {
+	return loadPageMeta(ctx, s.pages(), pageURL)
+}
+
+func (s *mongoStore) TouchPage(ctx context.Context, pageURL string, sched recrawlSchedule) error {
+	return touchPage(ctx, s.pages(), pageURL, sched)
+}
+
+func (s *mongoStore) FindDuplicate(ctx context.Context, hash, pageURL string) (string, error) {
+	return findDuplicate(ctx, s.pages(), hash, pageURL)
+}
+
+func (s *mongoStore) AddAlias(ctx context.Context, pageURL, alias string) error {
+	return addAlias(ctx, s.pages(), pageURL, alias)
+}
+
+func (s *mongoStore) DueForRecrawl(ctx context.Context, limit int) ([]string, error) {
+	return dueForRecrawl(ctx, s.pages(), limit)
+}
+
+func (s *mongoStore) RecentSimHashes(ctx context.Context, limit int, add func(string, uint64)) error {
+	opts := options.Find().
+		SetSort(bson.M{"crawl_time": -1}).
+		SetLimit(int64(limit)).
+		SetProjection(bson.M{"url": 1, "simhash": 1})
+	cur, err := s.pages().Find(ctx, bson.M{"simhash": bson.M{"$ne": 0}}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var doc struct {
+			URL     string `bson:"url"`
+			SimHash int64  `bson:"simhash"`
+		}
+		if err := cur.Decode(&doc); err == nil {
+			add(doc.URL, uint64(doc.SimHash))
+		}
+	}
+	return cur.Err()
+}
+
+func (s *mongoStore) LinksTo(ctx context.Context, pageURL string) (inbound, error) {
+	return linksTo(ctx, s.pages(), pageURL)
+}
+
+// EachPage streams the pages collection in batches of INDEX_BATCH_SIZE.
+// The cursor is kept open however long fn takes; a large rebuild outlasts
+// the server's idle cursor timeout.
+func (s *mongoStore) EachPage(ctx context.Context, projection bson.M, fn func(Page) error) error {
+	opts := options.Find().
+		SetProjection(projection).
+		SetBatchSize(int32(getEnvInt("INDEX_BATCH_SIZE", DefaultIndexBatch))).
+		SetNoCursorTimeout(true)
+	cur, err := s.pages().Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var p Page
+		if err := cur.Decode(&p); err != nil {
+			log.Printf("store: skipping page: %v", err)
+			continue
+		}
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (s *mongoStore) RecordCrawlError(ctx context.Context, pageURL string, err error) error {
+	return recordCrawlError(ctx, s.db.Collection("crawl_errors"), pageURL, err)
+}
+
+func (s *mongoStore) ClearCrawlError(ctx context.Context, pageURL string) error {
+	return clearCrawlError(ctx, s.db.Collection("crawl_errors"), pageURL)
+}
+
+func (s *mongoStore) PermanentlyFailed(ctx context.Context, pageURL string, ttl time.Duration) bool {
+	return permanentlyFailed(ctx, s.db.Collection("crawl_errors"), pageURL, ttl)
+}
+
+func (s *mongoStore) StoreRaw(ctx context.Context, pageURL string, res *fetchResult) error {
+	return storeRaw(ctx, s.db.Collection("raw_pages"), pageURL, res)
+}
+
+func (s *mongoStore) EachRaw(ctx context.Context, only string, fn func(*rawPage) error) error {
+	filter := bson.M{}
+	if only != "" {
+		filter["_id"] = only
+	}
+	cur, err := s.db.Collection("raw_pages").Find(ctx, filter)
+	if err != nil {
+		return err
+	}
+	defer cur.Close(ctx)
+	for cur.Next(ctx) {
+		var raw rawPage
+		if err := cur.Decode(&raw); err != nil {
+			return err
+		}
+		if err := fn(&raw); err != nil {
+			return err
+		}
+	}
+	return cur.Err()
+}
+
+func (s *mongoStore) CachedRobots(ctx context.Context, origin string) (*storedRobots, error) {
+	var doc storedRobots
+	err := s.db.Collection("robots_txt").FindOne(ctx, bson.M{"_id": origin, "expires_at": bson.M{"$gt": time.Now().UTC()}}).Decode(&doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+func (s *mongoStore) CacheRobots(ctx context.Context, doc storedRobots) error {
+	_, err := s.db.Collection("robots_txt").ReplaceOne(ctx, bson.M{"_id": doc.Origin}, doc, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) SaveCheckpoint(ctx context.Context, cp checkpoint) error {
+	return saveCheckpoint(ctx, s.db, cp)
+}
+
+func (s *mongoStore) LoadCheckpoint(ctx context.Context, runID string) (*checkpoint, error) {
+	return loadCheckpoint(ctx, s.db, runID)
+}
+
+func (s *mongoStore) SaveRun(ctx context.Context, run CrawlRun) error {
+	_, err := s.db.Collection("crawl_runs").ReplaceOne(ctx, bson.M{"_id": run.ID}, run, options.Replace().SetUpsert(true))
+	return err
+}
+
+func (s *mongoStore) AcquireCrawlLock(ctx context.Context, owner string, lease time.Duration) (bool, error) {
+	return acquireCrawlLock(ctx, s.db, owner, lease)
+}
+
+func (s *mongoStore) ReleaseCrawlLock(ctx context.Context, owner string) {
+	releaseCrawlLock(ctx, s.db, owner)
+}
+
+func (s *mongoStore) Close() error {
+	return s.client.Disconnect(context.Background())
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ----- Crawl strategy -----
+
+// crawlStrategy is the frontier ordering selected by CRAWL_STRATEGY:
+//
+//	best-first  DefaultScore (the default), plus topic relevance when
+//	            CRAWL_TOPIC lists keywords, for focused crawls
+//	bfs         strictly level by level, in discovery order
+//	dfs         deepest first, newest link first; still capped at MaxDepth
+type crawlStrategy struct {
+	Name  string
+	Score ScoreFunc
+	LIFO  bool // break score ties newest-first
+
+	topic []string // lowercased CRAWL_TOPIC keywords
+}
+
+func strategyFromEnv() (crawlStrategy, error) {
+	s := crawlStrategy{Name: strings.ToLower(getEnv("CRAWL_STRATEGY", "best-first"))}
+	for _, kw := range strings.Split(getEnv("CRAWL_TOPIC", ""), ",") {
+		if kw = strings.ToLower(strings.TrimSpace(kw)); kw != "" {
+			s.topic = append(s.topic, kw)
+		}
+	}
+
+	switch s.Name {
+	case "best-first":
+		s.Score = DefaultScore
+		if len(s.topic) > 0 {
+			s.Score = s.topicScore
+		}
+	case "bfs":
+		s.Score = func(item QueueItem, _ FrontierSignals) float64 { return -float64(item.Depth) }
+	case "dfs":
+		s.Score = func(item QueueItem, _ FrontierSignals) float64 { return float64(item.Depth) }
+		s.LIFO = true
+	default:
+		return s, fmt.Errorf("CRAWL_STRATEGY: unknown strategy %q (want best-first, bfs or dfs)", s.Name)
+	}
+	return s, nil
+}
+
+// Focused reports whether pages should be scored for topic relevance.
+func (s crawlStrategy) Focused() bool {
+	return s.Name == "best-first" && len(s.topic) > 0
+}
+
+// Relevance is the fraction of topic keywords that occur in text.
+func (s crawlStrategy) Relevance(text string) float64 {
+	if len(s.topic) == 0 {
+		return 0
+	}
+	text = strings.ToLower(text)
+	hits := 0
+	for _, kw := range s.topic {
+		if strings.Contains(text, kw) {
+			hits++
+		}
+	}
+	return float64(hits) / float64(len(s.topic))
+}
+
+// topicScore adds to DefaultScore the relevance of the page a link was
+// found on and of the link's own URL. A fully on-topic parent outweighs
+// one level of depth.
+func (s crawlStrategy) topicScore(item QueueItem, sig FrontierSignals) float64 {
+	score := DefaultScore(item, sig) + 15*item.Relevance
+	if u, err := url.Parse(item.URL); err == nil {
+		score += 5 * s.Relevance(u.Host+" "+u.Path)
+	}
+	return score
+}
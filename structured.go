@@ -0,0 +1,366 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Structured data -----
+
+// MaxEntities caps how many entities of each type a page keeps; listing
+// pages can mark up hundreds of products.
+const MaxEntities = 20
+
+// StructuredData holds the schema.org entities a page marks up, reduced to
+// the fields search can use. Source says where each came from ("json-ld",
+// "microdata" or "rdfa"), since the same entity is sometimes marked up
+// twice. Fields are validated on the way in; see entities.go.
+type StructuredData struct {
+	Articles      []ArticleEntity      `bson:"articles,omitempty"`
+	Products      []ProductEntity      `bson:"products,omitempty"`
+	Recipes       []RecipeEntity       `bson:"recipes,omitempty"`
+	Events        []EventEntity        `bson:"events,omitempty"`
+	Organizations []OrganizationEntity `bson:"organizations,omitempty"`
+}
+
+type ArticleEntity struct {
+	Source      string    `bson:"source"`
+	Type        string    `bson:"type"` // Article, NewsArticle, BlogPosting, ...
+	Headline    string    `bson:"headline,omitempty"`
+	Description string    `bson:"description,omitempty"`
+	Author      string    `bson:"author,omitempty"`
+	Publisher   string    `bson:"publisher,omitempty"`
+	Image       string    `bson:"image,omitempty"`
+	Published   time.Time `bson:"published,omitempty"`
+	Modified    time.Time `bson:"modified,omitempty"`
+}
+
+type ProductEntity struct {
+	Source       string  `bson:"source"`
+	Name         string  `bson:"name"`
+	Description  string  `bson:"description,omitempty"`
+	Brand        string  `bson:"brand,omitempty"`
+	SKU          string  `bson:"sku,omitempty"`
+	Image        string  `bson:"image,omitempty"`
+	Price        float64 `bson:"price,omitempty"`
+	Currency     string  `bson:"currency,omitempty"`
+	Availability string  `bson:"availability,omitempty"` // InStock, OutOfStock, ...
+	Rating       float64 `bson:"rating,omitempty"`
+	ReviewCount  int     `bson:"review_count,omitempty"`
+}
+
+type RecipeEntity struct {
+	Source       string   `bson:"source"`
+	Name         string   `bson:"name"`
+	Description  string   `bson:"description,omitempty"`
+	Author       string   `bson:"author,omitempty"`
+	Image        string   `bson:"image,omitempty"`
+	PrepMinutes  int      `bson:"prep_minutes,omitempty"`
+	CookMinutes  int      `bson:"cook_minutes,omitempty"`
+	TotalMinutes int      `bson:"total_minutes,omitempty"`
+	Yield        string   `bson:"yield,omitempty"`
+	Ingredients  []string `bson:"ingredients,omitempty"`
+	Rating       float64  `bson:"rating,omitempty"`
+}
+
+type EventEntity struct {
+	Source      string    `bson:"source"`
+	Type        string    `bson:"type"`
+	Name        string    `bson:"name"`
+	Description string    `bson:"description,omitempty"`
+	Start       time.Time `bson:"start,omitempty"`
+	End         time.Time `bson:"end,omitempty"`
+	Status      string    `bson:"status,omitempty"` // EventScheduled, EventCancelled, ...
+	Location    string    `bson:"location,omitempty"`
+	URL         string    `bson:"url,omitempty"`
+}
+
+type OrganizationEntity struct {
+	Source string   `bson:"source"`
+	Type   string   `bson:"type"`
+	Name   string   `bson:"name"`
+	URL    string   `bson:"url,omitempty"`
+	Logo   string   `bson:"logo,omitempty"`
+	SameAs []string `bson:"same_as,omitempty"`
+}
+
+var (
+	articleTypes = map[string]bool{
+		"Article": true, "NewsArticle": true, "BlogPosting": true, "TechArticle": true,
+		"ScholarlyArticle": true, "Report": true, "ReportageNewsArticle": true, "AnalysisNewsArticle": true,
+	}
+	organizationTypes = map[string]bool{
+		"Organization": true, "Corporation": true, "LocalBusiness": true, "NewsMediaOrganization": true,
+		"EducationalOrganization": true, "NGO": true, "GovernmentOrganization": true, "OnlineStore": true,
+	}
+)
+
+// extractStructured gathers the page's entities; nil if it marks none up.
+func extractStructured(doc *goquery.Document) *StructuredData {
+	var sd StructuredData
+	for _, node := range jsonLD(doc) {
+		sd.add(node, "json-ld")
+		if main, ok := node["mainEntity"].(map[string]any); ok {
+			sd.add(main, "json-ld")
+		}
+	}
+	for _, syntax := range []markupSyntax{microdataSyntax, rdfaSyntax} {
+		for _, node := range markupNodes(doc, syntax) {
+			sd.add(node, syntax.source)
+		}
+	}
+	if sd.empty() {
+		return nil
+	}
+	return &sd
+}
+
+func (sd *StructuredData) empty() bool {
+	return len(sd.Articles)+len(sd.Products)+len(sd.Recipes)+len(sd.Events)+len(sd.Organizations) == 0
+}
+
+// add files one node, decoded JSON-LD style ("@type" plus properties), under
+// the first of its types that is kept. Nodes without a name or headline
+// are dropped.
+func (sd *StructuredData) add(node map[string]any, source string) {
+	for _, typ := range ldTypes(node["@type"]) {
+		switch {
+		case articleTypes[typ]:
+			e := ArticleEntity{
+				Source:      source,
+				Type:        typ,
+				Headline:    ldString(node["headline"]),
+				Description: ldString(node["description"]),
+				Author:      ldName(node["author"]),
+				Publisher:   ldName(node["publisher"]),
+				Image:       ldURL(node["image"]),
+				Published:   ldTime(node["datePublished"]),
+				Modified:    ldTime(node["dateModified"]),
+			}
+			if e.Headline == "" {
+				e.Headline = ldString(node["name"])
+			}
+			if e.Headline != "" && len(sd.Articles) < MaxEntities {
+				sd.Articles = append(sd.Articles, e)
+			}
+			return
+
+		case typ == "Product":
+			e := ProductEntity{
+				Source:      source,
+				Name:        ldString(node["name"]),
+				Description: ldString(node["description"]),
+				Brand:       ldName(node["brand"]),
+				SKU:         ldString(node["sku"]),
+				Image:       ldURL(node["image"]),
+			}
+			if offer := ldFirst(node["offers"]); offer != nil {
+				e.Price = ldFloat(offer["price"])
+				if e.Price == 0 {
+					e.Price = ldFloat(offer["lowPrice"])
+				}
+				e.Currency = ldString(offer["priceCurrency"])
+				e.Availability = schemaTerm(ldString(offer["availability"]))
+			}
+			if rating := ldFirst(node["aggregateRating"]); rating != nil {
+				e.Rating = ldRating(rating)
+				e.ReviewCount = int(ldFloat(rating["reviewCount"]))
+				if e.ReviewCount == 0 {
+					e.ReviewCount = int(ldFloat(rating["ratingCount"]))
+				}
+			}
+			if e.validate() && len(sd.Products) < MaxEntities {
+				sd.Products = append(sd.Products, e)
+			}
+			return
+
+		case typ == "Recipe":
+			e := RecipeEntity{
+				Source:       source,
+				Name:         ldString(node["name"]),
+				Description:  ldString(node["description"]),
+				Author:       ldName(node["author"]),
+				Image:        ldURL(node["image"]),
+				PrepMinutes:  durationMinutes(ldString(node["prepTime"])),
+				CookMinutes:  durationMinutes(ldString(node["cookTime"])),
+				TotalMinutes: durationMinutes(ldString(node["totalTime"])),
+				Yield:        ldString(node["recipeYield"]),
+				Ingredients:  ldStrings(node["recipeIngredient"]),
+			}
+			if rating := ldFirst(node["aggregateRating"]); rating != nil {
+				e.Rating = ldRating(rating)
+			}
+			if e.validate() && len(sd.Recipes) < MaxEntities {
+				sd.Recipes = append(sd.Recipes, e)
+			}
+			return
+
+		case strings.HasSuffix(typ, "Event"):
+			e := EventEntity{
+				Source:      source,
+				Type:        typ,
+				Name:        ldString(node["name"]),
+				Description: ldString(node["description"]),
+				Start:       ldTimestamp(node["startDate"]),
+				End:         ldTimestamp(node["endDate"]),
+				Status:      schemaTerm(ldString(node["eventStatus"])),
+				Location:    ldName(node["location"]),
+				URL:         ldURL(node["url"]),
+			}
+			if e.validate() && len(sd.Events) < MaxEntities {
+				sd.Events = append(sd.Events, e)
+			}
+			return
+
+		case organizationTypes[typ]:
+			e := OrganizationEntity{
+				Source: source,
+				Type:   typ,
+				Name:   ldString(node["name"]),
+				URL:    ldURL(node["url"]),
+				Logo:   ldURL(node["logo"]),
+				SameAs: ldStrings(node["sameAs"]),
+			}
+			if e.Name != "" && len(sd.Organizations) < MaxEntities {
+				sd.Organizations = append(sd.Organizations, e)
+			}
+			return
+		}
+	}
+}
+
+// ldTypes lists a node's @type values reduced to their schema.org term, so
+// "Product", "schema:Product" and "https://schema.org/Product" all match.
+func ldTypes(v any) []string {
+	var types []string
+	for _, t := range ldStrings(v) {
+		types = append(types, schemaTerm(t))
+	}
+	return types
+}
+
+// schemaTerm strips a schema.org prefix or URL: "https://schema.org/InStock"
+// becomes "InStock".
+func schemaTerm(s string) string {
+	if i := strings.LastIndexAny(s, "/:#"); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// ldString reads a text value, which may also be given as a list (the
+// first entry is used), a number, or an {"@value": ...} object.
+func ldString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return cleanText(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []any:
+		for _, e := range v {
+			if s := ldString(e); s != "" {
+				return s
+			}
+		}
+	case map[string]any:
+		return ldString(v["@value"])
+	}
+	return ""
+}
+
+func ldStrings(v any) []string {
+	if list, ok := v.([]any); ok {
+		var out []string
+		for _, e := range list {
+			if s := ldString(e); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	if s := ldString(v); s != "" {
+		return []string{s}
+	}
+	return nil
+}
+
+// ldURL reads a URL given directly or as an ImageObject-style {"url": ...}.
+func ldURL(v any) string {
+	switch v := v.(type) {
+	case map[string]any:
+		if s := ldString(v["url"]); s != "" {
+			return s
+		}
+		return ldString(v["@id"])
+	case []any:
+		for _, e := range v {
+			if s := ldURL(e); s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	return ldString(v)
+}
+
+// ldFloat reads a number that may be quoted, as prices usually are, and
+// written with a decimal comma or thousands separators: "4,5", "19,99",
+// "1,299.99" and "1.299,99" all read as meant.
+func ldFloat(v any) float64 {
+	f, _ := strconv.ParseFloat(decimalPoint(strings.TrimSpace(ldString(v))), 64)
+	return f
+}
+
+// decimalPoint rewrites a number's separators for strconv. With a '.'
+// after the last comma the commas separate thousands; with one before it
+// the dots do and the comma is the decimal point. Without a '.', a lone
+// comma followed by one or two digits is the decimal point and other
+// commas separate thousands.
+func decimalPoint(s string) string {
+	comma, dot := strings.LastIndex(s, ","), strings.LastIndex(s, ".")
+	switch {
+	case comma < 0:
+		return s
+	case dot > comma:
+		return strings.ReplaceAll(s, ",", "")
+	case dot >= 0:
+		return strings.Replace(strings.ReplaceAll(s, ".", ""), ",", ".", 1)
+	case strings.Count(s, ",") == 1 && len(s)-comma-1 <= 2:
+		return strings.Replace(s, ",", ".", 1)
+	}
+	return strings.ReplaceAll(s, ",", "")
+}
+
+func ldTime(v any) time.Time {
+	t, _ := parseDate(ldString(v))
+	return t
+}
+
+// ldTimestamp is ldTime for dates that may be in the future.
+func ldTimestamp(v any) time.Time {
+	t, _ := parseTimestamp(ldString(v))
+	return t
+}
+
+// ldRating reads an AggregateRating or Rating onto a 0-5 scale.
+func ldRating(rating map[string]any) float64 {
+	return normalizeRating(ldFloat(rating["ratingValue"]), ldFloat(rating["bestRating"]), ldFloat(rating["worstRating"]))
+}
+
+// ldFirst returns an object value, or the first object of a list.
+func ldFirst(v any) map[string]any {
+	switch v := v.(type) {
+	case map[string]any:
+		return v
+	case []any:
+		for _, e := range v {
+			if m, ok := e.(map[string]any); ok {
+				return m
+			}
+		}
+	}
+	return nil
+}
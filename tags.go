@@ -0,0 +1,50 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// ----- Tags -----
+
+const (
+	MaxTags     = 30
+	MaxTagChars = 50
+)
+
+// tagWidgets are the links themes render as a post's tags or categories.
+var tagWidgets = strings.Join([]string{
+	`a[rel~="tag"]`, `a[rel~="category"]`,
+	`.tags a`, `.tag-list a`, `.post-tags a`, `.entry-tags a`,
+	`.categories a`, `.post-categories a`, `.cat-links a`,
+}, ", ")
+
+// extractTags collects the page's keywords: <meta name="keywords">,
+// article:tag and visible tag links. Tags are lowercased so the facet
+// doesn't split "Go" from "go", and deduplicated in first-seen order.
+func extractTags(doc *goquery.Document) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	add := func(t string) {
+		t = strings.ToLower(cleanText(strings.TrimLeft(t, "#")))
+		if t == "" || len([]rune(t)) > MaxTagChars || seen[t] || len(tags) >= MaxTags {
+			return
+		}
+		seen[t] = true
+		tags = append(tags, safeUTF8(t))
+	}
+
+	doc.Find(`meta[name="keywords" i]`).Each(func(_ int, s *goquery.Selection) {
+		for _, k := range strings.Split(s.AttrOr("content", ""), ",") {
+			add(k)
+		}
+	})
+	doc.Find(`meta[property="article:tag"]`).Each(func(_ int, s *goquery.Selection) {
+		add(s.AttrOr("content", ""))
+	})
+	doc.Find(tagWidgets).Each(func(_ int, s *goquery.Selection) {
+		add(visibleText(s))
+	})
+	return tags
+}
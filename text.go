@@ -0,0 +1,92 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+	"golang.org/x/text/unicode/norm"
+)
+
+// ----- Visible text -----
+
+// hiddenTags hold code or embedded documents, never prose.
+var hiddenTags = map[string]bool{
+	"script": true, "style": true, "noscript": true, "template": true, "iframe": true,
+}
+
+// blockTags break words apart: text on either side of one is separated by
+// a space even when the markup has none, as in "<li>a</li><li>b</li>".
+var blockTags = map[string]bool{
+	"address": true, "article": true, "aside": true, "blockquote": true, "br": true,
+	"dd": true, "div": true, "dl": true, "dt": true, "fieldset": true, "figcaption": true,
+	"figure": true, "footer": true, "form": true, "h1": true, "h2": true, "h3": true,
+	"h4": true, "h5": true, "h6": true, "header": true, "hr": true, "li": true,
+	"main": true, "nav": true, "ol": true, "p": true, "pre": true, "section": true,
+	"table": true, "td": true, "th": true, "tr": true, "ul": true,
+}
+
+// visibleText is s's text without script, style, noscript, template and
+// iframe contents, cleaned up by cleanText.
+func visibleText(s *goquery.Selection) string {
+	return nodesText(s.Nodes...)
+}
+
+// nodesText is visibleText for bare nodes.
+func nodesText(nodes ...*html.Node) string {
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		switch n.Type {
+		case html.TextNode:
+			b.WriteString(n.Data)
+			return
+		case html.ElementNode:
+			if hiddenTags[n.Data] {
+				return
+			}
+			if blockTags[n.Data] {
+				b.WriteByte(' ')
+				defer b.WriteByte(' ')
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	for _, n := range nodes {
+		walk(n)
+	}
+	return cleanText(b.String())
+}
+
+// entityRE matches character references left in text after parsing, as in
+// double-escaped meta content ("Tom&amp;#39;s") or JSON-LD strings. Only
+// terminated references count, so "AT&T" and "a=1&copy=2" stay as they are.
+var entityRE = regexp.MustCompile(`&(?:#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[a-zA-Z][a-zA-Z0-9]{1,31});`)
+
+// cleanText normalizes extracted text for storage and matching: leftover
+// character references are decoded, control characters and invisible
+// format characters (zero-width space, word joiner, BOM, soft hyphen) are
+// dropped, the result is put in NFC, and runs of whitespace, including
+// no-break spaces, become one space. The zero-width joiner and non-joiner
+// are kept; emoji sequences and Persian and Indic spelling need them.
+func cleanText(s string) string {
+	if strings.IndexByte(s, '&') >= 0 {
+		s = entityRE.ReplaceAllStringFunc(s, html.UnescapeString)
+	}
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r == '\u200b', r == '\u2060', r == '\ufeff', r == '\u00ad', r == '\u180e':
+			return -1
+		case unicode.IsSpace(r):
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		}
+		return r
+	}, s)
+	return strings.Join(strings.Fields(norm.NFC.String(s)), " ")
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+// ----- Outgoing request decoration -----
+
+// DefaultUserAgent identifies the crawler; set CRAWLER_USER_AGENT to
+// include your own contact URL.
+const DefaultUserAgent = CrawlerName + "/1.0 (+https://github.com/realutkarshh/Basic-Search-Engine-)"
+
+// crawlerTransport stamps every outgoing request (pages, robots.txt and
+// sitemaps alike) with the User-Agent and headers configured for its host,
+// and transparently decodes gzip/br bodies. Size limits are applied by the
+// callers to the decoded stream, so a compression bomb is cut off at the
+// same byte budget as an uncompressed page.
+type crawlerTransport struct {
+	base      http.RoundTripper
+	cfg       *crawlConfig
+	proxies   *proxyPool
+	guard     *netGuard
+	httpsOnly bool
+	userAgent string
+}
+
+// transportConfig holds the connection knobs; the env names are listed in
+// transportConfigFromEnv.
+type transportConfig struct {
+	DialTimeout     time.Duration
+	TLSTimeout      time.Duration
+	HeaderTimeout   time.Duration
+	IdleTimeout     time.Duration
+	MaxIdle         int
+	MaxIdlePerHost  int
+	MaxConnsPerHost int
+	DisableHTTP2    bool
+}
+
+func transportConfigFromEnv() transportConfig {
+	return transportConfig{
+		DialTimeout:     getEnvDuration("HTTP_DIAL_TIMEOUT", 5*time.Second),
+		TLSTimeout:      getEnvDuration("HTTP_TLS_TIMEOUT", 5*time.Second),
+		HeaderTimeout:   getEnvDuration("HTTP_HEADER_TIMEOUT", 8*time.Second),
+		IdleTimeout:     getEnvDuration("HTTP_IDLE_TIMEOUT", 90*time.Second),
+		MaxIdle:         getEnvInt("HTTP_MAX_IDLE", 256),
+		MaxIdlePerHost:  getEnvInt("HTTP_MAX_IDLE_PER_HOST", 8),
+		MaxConnsPerHost: getEnvInt("HTTP_MAX_CONNS_PER_HOST", 0),
+		DisableHTTP2:    getEnvBool("HTTP_DISABLE_HTTP2", false),
+	}
+}
+
+// newBaseTransport builds the one keep-alive pool shared by every worker.
+// Dial, TLS and header timeouts are separate from the overall
+// RequestTimeout on the client so a slow handshake fails fast.
+func newBaseTransport(tc transportConfig, proxies *proxyPool, guard *netGuard) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   tc.DialTimeout,
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		Proxy:                 proxies.proxyFunc,
+		DialContext:           guard.dialContext(dialer),
+		TLSHandshakeTimeout:   tc.TLSTimeout,
+		ResponseHeaderTimeout: tc.HeaderTimeout,
+		IdleConnTimeout:       tc.IdleTimeout,
+		MaxIdleConns:          tc.MaxIdle,
+		MaxIdleConnsPerHost:   tc.MaxIdlePerHost,
+		MaxConnsPerHost:       tc.MaxConnsPerHost,
+		ExpectContinueTimeout: time.Second,
+		// A custom DialContext turns HTTP/2 off unless forced back on.
+		ForceAttemptHTTP2: !tc.DisableHTTP2,
+	}
+	if tc.DisableHTTP2 {
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+	return t
+}
+
+func (t *crawlerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.httpsOnly && req.URL.Scheme != "https" {
+		return nil, fmt.Errorf("%w: %s", errPlainHTTP, req.URL)
+	}
+	req = req.Clone(req.Context())
+
+	dc, _ := t.cfg.forHost(req.URL.Hostname())
+	// Configured headers come first so User-Agent and Accept-Encoding
+	// below always win; use user_agent to change the former.
+	for k, v := range dc.Headers {
+		req.Header.Set(k, v)
+	}
+
+	ua := t.userAgent
+	if dc.UserAgent != "" {
+		ua = dc.UserAgent
+	}
+	req.Header.Set("User-Agent", ua)
+
+	if cred, ok := t.cfg.credentialsFor(req.URL.Hostname()); ok && cred.Username != "" {
+		req.SetBasicAuth(cred.Username, cred.password())
+	}
+
+	// Setting Accept-Encoding ourselves disables the stdlib's implicit
+	// gzip handling, so decoding is done here.
+	req.Header.Set("Accept-Encoding", "gzip, br")
+
+	proxyIdx := -1
+	if t.proxies != nil {
+		req, proxyIdx = t.proxies.withProxy(req)
+	}
+	// The proxy resolves proxied hosts, so the dial-time check can't.
+	if p, _ := t.proxies.proxyFunc(req); p != nil {
+		if err := t.guard.CheckHost(req.Context(), req.URL.Hostname()); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if proxyIdx >= 0 {
+		t.proxies.report(proxyIdx, proxyFailure(req, resp, err))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := decodeBody(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func decodeBody(resp *http.Response) error {
+	var decoded io.Reader
+	switch strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding"))) {
+	case "gzip", "x-gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			if err == io.EOF {
+				// Empty body (HEAD, 304): nothing to decode.
+				return nil
+			}
+			return err
+		}
+		decoded = gz
+	case "br":
+		decoded = brotli.NewReader(resp.Body)
+	default:
+		return nil
+	}
+
+	resp.Body = &decodedBody{Reader: decoded, raw: resp.Body, length: resp.ContentLength}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return nil
+}
+
+// decodedBody reads decompressed bytes but closes the underlying body.
+type decodedBody struct {
+	io.Reader
+	raw    io.ReadCloser
+	length int64 // Content-Length of the encoded body, -1 if not sent
+}
+
+func (b *decodedBody) Close() error {
+	return b.raw.Close()
+}
+
+// wireLength is resp's Content-Length as sent, before any decoding, or -1
+// if it had none.
+func wireLength(resp *http.Response) int64 {
+	if b, ok := resp.Body.(*decodedBody); ok {
+		return b.length
+	}
+	return resp.ContentLength
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ----- Crawler trap detection -----
+
+const (
+	MaxURLLength       = 2048 // URL_MAX_LENGTH default, in bytes
+	MaxQueryParams     = 12   // URL_MAX_PARAMS default
+	MaxSegmentRepeats  = 3    // same path segment this often = loop
+	MaxQueryVariants   = 100  // distinct query strings per path
+	MaxPaginationPage  = 500  // ?page=N beyond this is a generated space
+	TypicalPageSize    = 20   // results per page, to turn ?offset=N into a page number
+	MaxCalendarYearGap = 2    // years beyond now still considered real dates
+	MinCalendarYear    = 1995 // calendar pages before this are generated
+)
+
+var (
+	datePathRE  = regexp.MustCompile(`/((?:19|20|21)\d{2})(?:[/-](\d{1,2}))(?:[/-](\d{1,2}))?(?:/|$)`)
+	dateQueryRE = regexp.MustCompile(`(?i)(?:^|&)(?:date|day|month|year|y|cal)=((?:19|20|21)\d{2})`)
+	pageQueryRE = regexp.MustCompile(`(?i)(?:^|&)(page|p|pg|offset|start)=(\d+)`)
+)
+
+// trapDetector spots infinite URL spaces. Stateless checks look at a URL
+// alone; stateful ones count what has been discovered per host, path and
+// path prefix this run. Not safe for concurrent use; the crawler locks it.
+type trapDetector struct {
+	prefixLimit int
+	maxLength   int // 0 = no limit
+	maxParams   int // 0 = no limit
+
+	queryVariants map[string]int // host+path -> distinct queries
+	prefixURLs    map[string]int // host+first two segments -> distinct URLs
+	reported      map[string]bool
+}
+
+func newTrapDetector(prefixLimit, maxLength, maxParams int) *trapDetector {
+	return &trapDetector{
+		prefixLimit:   prefixLimit,
+		maxLength:     maxLength,
+		maxParams:     maxParams,
+		queryVariants: make(map[string]int),
+		prefixURLs:    make(map[string]int),
+		reported:      make(map[string]bool),
+	}
+}
+
+// Check must be called once per newly discovered URL. It returns a reason
+// when u looks like part of a trap, or is too long or has too many query
+// parameters to be anything but session junk.
+func (d *trapDetector) Check(u *url.URL) (string, bool) {
+	if d.maxLength > 0 && len(u.String()) > d.maxLength {
+		return "URL too long", true
+	}
+	if d.maxParams > 0 && u.RawQuery != "" && strings.Count(u.RawQuery, "&")+1 > d.maxParams {
+		return "too many query parameters", true
+	}
+	if reason, ok := staticTrap(u); ok {
+		return reason, true
+	}
+
+	host := u.Hostname()
+	if u.RawQuery != "" {
+		key := host + u.Path
+		d.queryVariants[key]++
+		if d.queryVariants[key] > MaxQueryVariants {
+			return d.report(key, "too many query variants"), true
+		}
+	}
+
+	if d.prefixLimit > 0 {
+		key := host + "/" + pathPrefix(u.Path, 2)
+		d.prefixURLs[key]++
+		if d.prefixURLs[key] > d.prefixLimit {
+			return d.report(key, "too many URLs under prefix"), true
+		}
+	}
+	return "", false
+}
+
+// report logs the first hit for each trap so one bad site isn't a wall of logs.
+func (d *trapDetector) report(key, reason string) string {
+	if !d.reported[key] {
+		d.reported[key] = true
+		log.Printf("trap: %s: %s", key, reason)
+	}
+	return reason
+}
+
+// staticTrap covers patterns that are traps regardless of crawl history.
+func staticTrap(u *url.URL) (string, bool) {
+	segs := strings.Split(strings.Trim(u.Path, "/"), "/")
+	counts := make(map[string]int)
+	for _, seg := range segs {
+		if seg == "" {
+			continue
+		}
+		counts[seg]++
+		if counts[seg] >= MaxSegmentRepeats {
+			return "repeated path segment", true
+		}
+	}
+	if repeatedRun(segs) {
+		return "repeated path segment", true
+	}
+
+	// offset= and start= count results, not pages.
+	if m := pageQueryRE.FindStringSubmatch(u.RawQuery); m != nil {
+		n, err := strconv.Atoi(m[2])
+		if k := strings.ToLower(m[1]); k == "offset" || k == "start" {
+			n /= TypicalPageSize
+		}
+		if err != nil || n > MaxPaginationPage {
+			return "deep pagination", true
+		}
+	}
+
+	year := 0
+	if m := datePathRE.FindStringSubmatch(u.Path); m != nil {
+		year, _ = strconv.Atoi(m[1])
+	} else if m := dateQueryRE.FindStringSubmatch(u.RawQuery); m != nil {
+		year, _ = strconv.Atoi(m[1])
+	}
+	if year != 0 && (year < MinCalendarYear || year > time.Now().Year()+MaxCalendarYearGap) {
+		return "calendar out of range", true
+	}
+	return "", false
+}
+
+// repeatedRun reports a run of segments directly followed by itself, as
+// in /a/a or /a/b/a/b, which relative links on a misconfigured server
+// generate without end. A lone number repeated (/1/1) is let through; it
+// is usually an ID or a page.
+func repeatedRun(segs []string) bool {
+	for n := 1; 2*n <= len(segs); n++ {
+	next:
+		for i := 0; i+2*n <= len(segs); i++ {
+			if n == 1 {
+				if _, err := strconv.Atoi(segs[i]); err == nil {
+					continue
+				}
+			}
+			for j := 0; j < n; j++ {
+				if segs[i+j] != segs[i+n+j] {
+					continue next
+				}
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// pathPrefix returns the first n segments of p.
+func pathPrefix(p string, n int) string {
+	segs := strings.SplitN(strings.Trim(p, "/"), "/", n+1)
+	if len(segs) > n {
+		segs = segs[:n]
+	}
+	return strings.Join(segs, "/")
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/idna"
+)
+
+// ----- URL canonicalization -----
+
+// trackingParams are stripped from query strings; any utm_* key is too.
+var trackingParams = map[string]bool{
+	"fbclid":  true,
+	"gclid":   true,
+	"dclid":   true,
+	"msclkid": true,
+	"mc_cid":  true,
+	"mc_eid":  true,
+	"igshid":  true,
+	"yclid":   true,
+	"_ga":     true,
+	"_hsenc":  true,
+	"_hsmi":   true,
+}
+
+func isTrackingParam(key string) bool {
+	key = strings.ToLower(key)
+	return strings.HasPrefix(key, "utm_") || trackingParams[key]
+}
+
+// canonicalizeURL rewrites u in place so that trivially different spellings
+// of the same page share one key in the visited set and in Mongo.
+func canonicalizeURL(u *url.URL) {
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = canonicalHost(u.Host)
+	u.Fragment = ""
+	u.RawFragment = ""
+
+	if host, port, err := net.SplitHostPort(u.Host); err == nil {
+		if (u.Scheme == "http" && port == "80") || (u.Scheme == "https" && port == "443") {
+			u.Host = host
+			if strings.Contains(host, ":") {
+				u.Host = "[" + host + "]"
+			}
+		}
+	}
+
+	// Fold "/a/b/" into "/a/b"; the root path is always "/".
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	} else if len(u.Path) > 1 && strings.HasSuffix(u.Path, "/") {
+		u.Path = strings.TrimRight(u.Path, "/")
+		if u.Path == "" {
+			u.Path = "/"
+		}
+		u.RawPath = strings.TrimRight(u.RawPath, "/")
+	}
+	// Let net/url pick the one standard escaping of Path, unless the
+	// original holds an encoded slash, which Path can't represent.
+	if strings.Contains(strings.ToLower(u.RawPath), "%2f") {
+		u.RawPath = normalizeEscapes(u.RawPath)
+	} else {
+		u.RawPath = ""
+	}
+
+	u.RawQuery = canonicalQuery(u.RawQuery)
+	u.ForceQuery = false
+}
+
+// canonicalQuery drops tracking and empty pairs and sorts the rest by key.
+// Pairs keep their encoding apart from normalizeEscapes, so "+" and "%20"
+// stay distinct.
+func canonicalQuery(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	type pair struct{ key, raw string }
+	var pairs []pair
+	for _, p := range strings.Split(raw, "&") {
+		if p == "" {
+			continue
+		}
+		k, _, _ := strings.Cut(p, "=")
+		key, err := url.QueryUnescape(k)
+		if err != nil {
+			key = k
+		}
+		if isTrackingParam(key) {
+			continue
+		}
+		pairs = append(pairs, pair{key: key, raw: normalizeEscapes(p)})
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].key < pairs[j].key })
+
+	parts := make([]string, len(pairs))
+	for i, p := range pairs {
+		parts[i] = p.raw
+	}
+	return strings.Join(parts, "&")
+}
+
+// canonicalHost lowercases host and converts internationalized names to
+// punycode, so "BÜCHER.example" and "xn--bcher-kva.example" are one host.
+// A trailing root dot is dropped.
+func canonicalHost(hostport string) string {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host, port = hostport, ""
+	}
+	if strings.HasPrefix(host, "[") || strings.Contains(host, ":") {
+		return strings.ToLower(hostport) // IPv6 literal
+	}
+	host = strings.TrimSuffix(host, ".")
+	if ascii, err := idna.Lookup.ToASCII(host); err == nil {
+		host = ascii
+	} else {
+		host = strings.ToLower(host)
+	}
+	if port != "" {
+		return net.JoinHostPort(host, port)
+	}
+	return host
+}
+
+// normalizeEscapes rewrites percent-encoding in s to one spelling: escapes
+// of unreserved characters are decoded, remaining escapes use upper-case
+// hex, and raw non-ASCII bytes, spaces and stray '%' are escaped.
+func normalizeEscapes(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]):
+			v := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(v) {
+				b.WriteByte(v)
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(hex[v>>4])
+				b.WriteByte(hex[v&15])
+			}
+			i += 2
+		case c >= 0x80 || c <= ' ' || c == '%':
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&15])
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= 'a':
+		return c - 'a' + 10
+	case c >= 'A':
+		return c - 'A' + 10
+	}
+	return c - '0'
+}
+
+// canonicalLink returns the normalized <link rel="canonical"> target, or ""
+// when the page doesn't declare a usable one.
+func canonicalLink(doc *goquery.Document, base *url.URL) string {
+	href := ""
+	doc.Find("link[rel][href]").EachWithBreak(func(i int, s *goquery.Selection) bool {
+		for _, rel := range strings.Fields(strings.ToLower(s.AttrOr("rel", ""))) {
+			if rel == "canonical" {
+				href = s.AttrOr("href", "")
+				return false
+			}
+		}
+		return true
+	})
+	if href == "" {
+		return ""
+	}
+	u, err := normalizeURL(base, href)
+	if err != nil {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// ----- URL allow/deny rules -----
+
+// urlRules filters candidate URLs before they enter the frontier. Deny
+// patterns always win; when allow patterns exist a URL must match one.
+// In the crawler config they are written as
+//
+//	"urls": {"allow": ["/docs/"], "deny": ["\\.php\\?replytocom="]}
+//
+// and matched (unanchored) against the full URL.
+type urlRules struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+func (r *urlRules) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Allow []string `json:"allow"`
+		Deny  []string `json:"deny"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	var err error
+	if r.allow, err = compilePatterns(raw.Allow); err != nil {
+		return err
+	}
+	r.deny, err = compilePatterns(raw.Deny)
+	return err
+}
+
+func compilePatterns(patterns []string) ([]*regexp.Regexp, error) {
+	var out []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("url rule %q: %w", p, err)
+		}
+		out = append(out, re)
+	}
+	return out, nil
+}
+
+func (r urlRules) Allowed(u string) bool {
+	for _, re := range r.deny {
+		if re.MatchString(u) {
+			return false
+		}
+	}
+	if len(r.allow) == 0 {
+		return true
+	}
+	for _, re := range r.allow {
+		if re.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"strings"
+)
+
+// ----- Visited set -----
+
+// VisitedSet records URLs the crawler has queued for fetching, or decided
+// never to fetch, this run. Implementations need not be safe for
+// concurrent use; the crawler locks around them.
+type VisitedSet interface {
+	Has(u string) bool
+	// Add marks u visited and reports whether it was new.
+	Add(u string) bool
+	Len() int
+}
+
+// urlLister is implemented by sets that can list their members, so a
+// checkpoint can save them URL by URL.
+type urlLister interface {
+	Each(fn func(u string))
+}
+
+// newVisitedSet picks the set named by VISITED_SET: "map" (exact, the
+// default) or "bloom", which holds BLOOM_CAPACITY URLs in fixed memory at
+// a BLOOM_FP_RATE chance of skipping a URL it hasn't seen.
+func newVisitedSet() (VisitedSet, error) {
+	switch v := strings.ToLower(getEnv("VISITED_SET", "map")); v {
+	case "map":
+		return mapSet{}, nil
+	case "bloom":
+		fp := 0.001
+		if s := getEnv("BLOOM_FP_RATE", ""); s != "" {
+			if _, err := fmt.Sscan(s, &fp); err != nil || fp <= 0 || fp >= 1 {
+				return nil, fmt.Errorf("BLOOM_FP_RATE: want a rate between 0 and 1, got %q", s)
+			}
+		}
+		return newBloomSet(getEnvInt("BLOOM_CAPACITY", 4_000_000), fp), nil
+	default:
+		return nil, fmt.Errorf("VISITED_SET: unknown set %q (want map or bloom)", v)
+	}
+}
+
+type mapSet map[string]struct{}
+
+func (s mapSet) Has(u string) bool { _, ok := s[u]; return ok }
+func (s mapSet) Len() int          { return len(s) }
+
+func (s mapSet) Add(u string) bool {
+	if _, ok := s[u]; ok {
+		return false
+	}
+	s[u] = struct{}{}
+	return true
+}
+
+func (s mapSet) Each(fn func(string)) {
+	for u := range s {
+		fn(u)
+	}
+}
+
+// bloomSet is a Bloom filter sized for capacity entries at false-positive
+// rate fp; 4M URLs at 0.1% take about 7 MiB, against gigabytes for the
+// map. Positions come from two FNV hashes combined by double hashing, so
+// a saved filter stays valid in another process.
+type bloomSet struct {
+	bits []uint64
+	m    uint64 // bits
+	k    int    // hash functions
+	n    int    // entries added
+}
+
+func newBloomSet(capacity int, fp float64) *bloomSet {
+	capacity = max(capacity, 1)
+	m := uint64(math.Ceil(-float64(capacity) * math.Log(fp) / (math.Ln2 * math.Ln2)))
+	k := max(int(math.Round(float64(m)/float64(capacity)*math.Ln2)), 1)
+	return &bloomSet{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+func (b *bloomSet) hashes(u string) (uint64, uint64) {
+	h1 := fnv.New64a()
+	h1.Write([]byte(u))
+	h2 := fnv.New64()
+	h2.Write([]byte(u))
+	return h1.Sum64(), h2.Sum64() | 1
+}
+
+func (b *bloomSet) Has(u string) bool {
+	h1, h2 := b.hashes(u)
+	for i := 0; i < b.k; i++ {
+		pos := (h1 + uint64(i)*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomSet) Add(u string) bool {
+	h1, h2 := b.hashes(u)
+	added := false
+	for i := 0; i < b.k; i++ {
+		pos := (h1 + uint64(i)*h2) % b.m
+		if b.bits[pos/64]&(1<<(pos%64)) == 0 {
+			b.bits[pos/64] |= 1 << (pos % 64)
+			added = true
+		}
+	}
+	if added {
+		b.n++
+	}
+	return added
+}
+
+func (b *bloomSet) Len() int { return b.n }
+
+// bloomState is a bloomSet as saved in a checkpoint.
+type bloomState struct {
+	M    int64  `bson:"m"`
+	K    int    `bson:"k"`
+	N    int    `bson:"n"`
+	Bits []byte `bson:"bits"`
+}
+
+func (b *bloomSet) state() bloomState {
+	buf := make([]byte, 8*len(b.bits))
+	for i, w := range b.bits {
+		binary.LittleEndian.PutUint64(buf[8*i:], w)
+	}
+	return bloomState{M: int64(b.m), K: b.k, N: b.n, Bits: buf}
+}
+
+// load replaces b's contents with st if the filters have the same shape.
+func (b *bloomSet) load(st bloomState) bool {
+	if uint64(st.M) != b.m || st.K != b.k || len(st.Bits) != 8*len(b.bits) {
+		return false
+	}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(st.Bits[8*i:])
+	}
+	b.n = st.N
+	return true
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
-	"bytes"
 	"context"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"github.com/joho/godotenv"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -28,6 +33,7 @@ const (
 	MaxBodyBytes    = 2 * 1024 * 1024
 	MaxDepth        = 5
 	MaxTextChars    = 70000
+	DefaultWorkers  = 4
 )
 
 // ---------------- UTF-8 SAFE ------------------
@@ -40,17 +46,96 @@ func safeUTF8(s string) string {
 
 // Page stored in MongoDB
 type Page struct {
-	URL       string    `bson:"url"`
-	Title     string    `bson:"title"`
-
-	Snippet   string    `bson:"snippet"`    // NEW
-	Favicon   string    `bson:"favicon"`    // NEW
-	SiteName  string    `bson:"site_name"`  // NEW
-	Image     string    `bson:"image"`      // NEW
+	URL   string `bson:"url"`
+	Title string `bson:"title"`
+
+	Snippet  string `bson:"snippet"`   // NEW
+	Favicon  string `bson:"favicon"`   // NEW
+	SiteName string `bson:"site_name"` // NEW
+	Image    string `bson:"image"`     // NEW
+
+	ContentType string `bson:"content_type"` // media type: text/html, application/pdf, ...
+
+	Text      string      `bson:"text"`
+	MainText  string      `bson:"main_text"` // article text without navigation and chrome, see readability.go
+	Lang      string      `bson:"lang"`      // ISO 639-1 code, "" if unknown; see lang.go
+	Headings  Headings    `bson:"headings"`
+	Tags      []string    `bson:"tags,omitempty"` // keywords, article:tag and tag links; see tags.go
+	Images    []ImageInfo `bson:"images,omitempty"`
+	Media     []MediaItem `bson:"media,omitempty"` // video and audio; see media.go
+	Links     []Link      `bson:"links"`
+	CrawlTime time.Time   `bson:"crawl_time"`
+
+	// Content signals, see metrics.go. TextRatio is text bytes over HTML
+	// bytes; boilerplate-heavy and script-heavy pages score low.
+	WordCount      int     `bson:"word_count"`
+	ReadingMinutes int     `bson:"reading_minutes"`
+	TextRatio      float64 `bson:"text_html_ratio"`
+
+	// Article metadata, when the page declares it; see dates.go.
+	PublishedAt time.Time `bson:"published_at,omitempty"`
+	ModifiedAt  time.Time `bson:"modified_at,omitempty"`
+	Author      string    `bson:"author,omitempty"`
+
+	// schema.org entities marked up on the page; see structured.go.
+	Structured *StructuredData `bson:"structured,omitempty"`
+
+	// Site › Section › Page path for result display; see breadcrumbs.go.
+	Breadcrumbs []Crumb `bson:"breadcrumbs,omitempty"`
+
+	// Open Graph and Twitter Card data; see opengraph.go.
+	OG *SocialCard `bson:"og,omitempty"`
+
+	// Translations of this page it declares via hreflang, itself included
+	// when listed. Pages sharing alternates are versions of one another.
+	Alternates []Alternate `bson:"alternates,omitempty"`
+
+	// Other URLs that resolved to this page (redirect hops).
+	Aliases []string `bson:"aliases,omitempty"`
+
+	// Validators for conditional GET on recrawl.
+	ETag         string `bson:"etag"`
+	LastModified string `bson:"last_modified"`
+
+	// Near-duplicate fingerprint (uint64 bits stored as int64) and the page
+	// it was found to match, if any.
+	SimHash         int64  `bson:"simhash"`
+	NearDuplicateOf string `bson:"near_duplicate_of"`
+
+	// Adaptive recrawl state, see recrawl.go.
+	ContentHash     string        `bson:"content_hash"`
+	RecrawlInterval time.Duration `bson:"recrawl_interval"`
+	NextCrawl       time.Time     `bson:"next_crawl"`
+	Checks          int           `bson:"checks"`
+	Changes         int           `bson:"changes"`
+
+	// Main-content change since the previous visit, see change.go.
+	// LastChanged is when a visit last found the main content different.
+	MainHash    string    `bson:"main_hash"`
+	MainSketch  []uint32  `bson:"main_sketch,omitempty"`
+	Changed     bool      `bson:"changed"`
+	ChangeRatio float64   `bson:"change_ratio"`
+	LastChanged time.Time `bson:"last_changed"`
+
+	// Restricted pages are mostly a login form or paywall; the reason is
+	// "login" or "paywall". See restricted.go.
+	Restricted       bool   `bson:"restricted"`
+	RestrictedReason string `bson:"restricted_reason,omitempty"`
+
+	// The response this version was built from.
+	Response ResponseMeta `bson:"response"`
+}
 
-	Text      string    `bson:"text"`
-	Links     []string  `bson:"links"`
-	CrawlTime time.Time `bson:"crawl_time"`
+// ResponseMeta describes the HTTP exchange behind a stored page.
+type ResponseMeta struct {
+	StatusCode      int           `bson:"status_code"` // 0 when rendered in a browser
+	FinalURL        string        `bson:"final_url"`   // as fetched, before canonicalization
+	ContentType     string        `bson:"content_type"`
+	ContentLength   int64         `bson:"content_length"` // as declared, before decompression; -1 if unknown
+	BodyBytes       int           `bson:"body_bytes"`     // decoded bytes read, capped at MaxBodyBytes
+	Server          string        `bson:"server"`
+	ContentLanguage string        `bson:"content_language"`
+	FetchDuration   time.Duration `bson:"fetch_duration"`
 }
 
 // ----- Env -----
@@ -63,19 +148,46 @@ func getEnv(key, def string) string {
 	return v
 }
 
-// ----- Domain helpers -----
-
-func isAllowedDomain(u *url.URL, allowedDomains []string) bool {
-	if len(allowedDomains) == 0 {
+func getEnvBool(key string, def bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes", "on":
 		return true
+	case "0", "false", "no", "off":
+		return false
 	}
-	host := u.Hostname()
-	for _, d := range allowedDomains {
-		if strings.HasSuffix(host, d) {
-			return true
-		}
+	return def
+}
+
+func getEnvInt(key string, def int) int {
+	n, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return def
 	}
-	return false
+	return n
+}
+
+// getEnvFloat accepts decimal numbers ("0.5", "1e-3").
+func getEnvFloat(key string, def float64) float64 {
+	f, err := strconv.ParseFloat(os.Getenv(key), 64)
+	if err != nil {
+		return def
+	}
+	return f
+}
+
+// getEnvDuration accepts Go durations ("750ms", "2s").
+func getEnvDuration(key string, def time.Duration) time.Duration {
+	d, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return def
+	}
+	return d
+}
+
+// ----- Domain helpers -----
+
+func isAllowedDomain(u *url.URL, scope domainScope) bool {
+	return scope.Allows(u.Hostname())
 }
 
 func normalizeURL(base *url.URL, href string) (*url.URL, error) {
@@ -93,13 +205,13 @@ func normalizeURL(base *url.URL, href string) (*url.URL, error) {
 	if parsed.Scheme != "http" && parsed.Scheme != "https" {
 		return nil, fmt.Errorf("unsupported scheme")
 	}
-	parsed.Fragment = ""
+	canonicalizeURL(parsed)
 	return parsed, nil
 }
 
 // ----- Mongo Setup -----
 
-func connectMongo(ctx context.Context) (*mongo.Client, *mongo.Collection, error) {
+func connectMongo(ctx context.Context) (*mongo.Client, *mongo.Database, error) {
 	uri := getEnv("MONGO_URI", "")
 	dbName := getEnv("MONGO_DB_NAME", "basic_search_engine")
 
@@ -116,11 +228,91 @@ func connectMongo(ctx context.Context) (*mongo.Client, *mongo.Collection, error)
 		return nil, nil, err
 	}
 
-	col := client.Database(dbName).Collection("pages")
-	return client, col, nil
+	return client, client.Database(dbName), nil
+}
+
+// ensureIndexes creates the lookups the crawler relies on; it is safe to
+// call on every start.
+func ensureIndexes(ctx context.Context, db *mongo.Database) error {
+	pages := []mongo.IndexModel{
+		{Keys: bson.D{{Key: "url", Value: 1}}},
+		{Keys: bson.D{{Key: "aliases", Value: 1}}},
+		{Keys: bson.D{{Key: "content_hash", Value: 1}}},
+		{Keys: bson.D{{Key: "next_crawl", Value: 1}}},
+		{Keys: bson.D{{Key: "lang", Value: 1}}},
+		{Keys: bson.D{{Key: "published_at", Value: -1}}},
+		{Keys: bson.D{{Key: "last_changed", Value: -1}}},
+		{Keys: bson.D{{Key: "alternates.url", Value: 1}}},
+		{Keys: bson.D{{Key: "tags", Value: 1}}},
+		{Keys: bson.D{{Key: "media.kind", Value: 1}}},
+		{Keys: bson.D{{Key: "links.url", Value: 1}}},
+	}
+	if _, err := db.Collection("pages").Indexes().CreateMany(ctx, pages); err != nil {
+		return err
+	}
+	if mongoSearchBackend() {
+		if err := ensureTextIndex(ctx, db.Collection("pages")); err != nil {
+			return err
+		}
+	}
+	errs := mongo.IndexModel{Keys: bson.D{{Key: "url", Value: 1}}}
+	if _, err := db.Collection("crawl_errors").Indexes().CreateOne(ctx, errs); err != nil {
+		return err
+	}
+	robots := mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	}
+	_, err := db.Collection("robots_txt").Indexes().CreateOne(ctx, robots)
+	return err
 }
 
 func upsertPage(ctx context.Context, col *mongo.Collection, p Page) error {
+	p, aliases := cleanPage(p)
+	raw, err := bson.Marshal(p)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"url": p.URL}
+	update := bson.M{"$set": raw}
+	if unset := unsetFields(raw); len(unset) > 0 {
+		fields := bson.M{}
+		for _, f := range unset {
+			fields[f] = ""
+		}
+		update["$unset"] = fields
+	}
+	if len(aliases) > 0 {
+		update["$addToSet"] = bson.M{"aliases": bson.M{"$each": aliases}}
+	}
+	opts := options.Update().SetUpsert(true)
+
+	_, err = col.UpdateOne(ctx, filter, update, opts)
+	return err
+}
+
+// extractedFields are the page fields left out when empty that every
+// fetch extracts anew. Upserts unset those a new version lacks, so a page
+// that loses its date or byline loses it in the store too.
+var extractedFields = []string{
+	"tags", "images", "media", "published_at", "modified_at", "author", "structured",
+	"breadcrumbs", "og", "alternates", "main_sketch", "restricted_reason",
+}
+
+// unsetFields lists the extractedFields missing from doc, a marshaled page.
+func unsetFields(doc bson.Raw) []string {
+	var unset []string
+	for _, f := range extractedFields {
+		if _, err := doc.LookupErr(f); err != nil {
+			unset = append(unset, f)
+		}
+	}
+	return unset
+}
+
+// cleanPage makes p's text fields valid UTF-8 and takes its aliases out,
+// returning those other than its URL.
+func cleanPage(p Page) (Page, []string) {
 	// SANITIZE EVERYTHING → UTF-8 SAFE
 	p.URL = safeUTF8(p.URL)
 	p.Title = safeUTF8(p.Title)
@@ -129,48 +321,187 @@ func upsertPage(ctx context.Context, col *mongo.Collection, p Page) error {
 	p.SiteName = safeUTF8(p.SiteName)
 	p.Image = safeUTF8(p.Image)
 	p.Text = safeUTF8(p.Text)
+	p.MainText = safeUTF8(p.MainText)
+	p.Author = safeUTF8(p.Author)
+	p.ETag = safeUTF8(p.ETag)
+	p.LastModified = safeUTF8(p.LastModified)
+	p.Response.FinalURL = safeUTF8(p.Response.FinalURL)
+	p.Response.ContentType = safeUTF8(p.Response.ContentType)
+	p.Response.Server = safeUTF8(p.Response.Server)
+	p.Response.ContentLanguage = safeUTF8(p.Response.ContentLanguage)
+
+	// Aliases accumulate across crawls instead of being overwritten.
+	aliases := make([]string, 0, len(p.Aliases))
+	for _, a := range p.Aliases {
+		if a = safeUTF8(a); a != p.URL {
+			aliases = append(aliases, a)
+		}
+	}
+	p.Aliases = nil
+	return p, aliases
+}
 
-	filter := bson.M{"url": p.URL}
-	update := bson.M{"$set": p}
-	opts := options.Update().SetUpsert(true)
+// pageFilter matches a page by its URL or any recorded alias.
+func pageFilter(pageURL string) bson.M {
+	return bson.M{"$or": []bson.M{{"url": pageURL}, {"aliases": pageURL}}}
+}
 
-	_, err := col.UpdateOne(ctx, filter, update, opts)
-	return err
+// pageMeta is the subset of a stored page needed to decide on a recrawl.
+type pageMeta struct {
+	URL             string        `bson:"url"`
+	CrawlTime       time.Time     `bson:"crawl_time"`
+	ETag            string        `bson:"etag"`
+	LastModified    string        `bson:"last_modified"`
+	ContentHash     string        `bson:"content_hash"`
+	RecrawlInterval time.Duration `bson:"recrawl_interval"`
+	NextCrawl       time.Time     `bson:"next_crawl"`
+	Checks          int           `bson:"checks"`
+	Changes         int           `bson:"changes"`
+	MainHash        string        `bson:"main_hash"`
+	MainSketch      []uint32      `bson:"main_sketch"`
+	LastChanged     time.Time     `bson:"last_changed"`
+}
+
+var pageMetaProjection = bson.M{
+	"url": 1, "crawl_time": 1, "etag": 1, "last_modified": 1,
+	"content_hash": 1, "recrawl_interval": 1, "next_crawl": 1, "checks": 1, "changes": 1,
+	"main_hash": 1, "main_sketch": 1, "last_changed": 1,
 }
 
-func pageExists(ctx context.Context, col *mongo.Collection, pageURL string) (bool, error) {
-	err := col.FindOne(ctx, bson.M{"url": pageURL}).Err()
+// loadPageMeta returns nil, nil when the page has never been stored.
+func loadPageMeta(ctx context.Context, col *mongo.Collection, pageURL string) (*pageMeta, error) {
+	var meta pageMeta
+	opts := options.FindOne().SetProjection(pageMetaProjection)
+	err := col.FindOne(ctx, pageFilter(pageURL), opts).Decode(&meta)
 	if err == mongo.ErrNoDocuments {
-		return false, nil
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
 	}
-	return err == nil, err
+	return &meta, nil
+}
+
+// touchPage records a 304 revisit.
+func touchPage(ctx context.Context, col *mongo.Collection, pageURL string, sched recrawlSchedule) error {
+	update := bson.M{"$set": sched.touched()}
+	_, err := col.UpdateOne(ctx, pageFilter(pageURL), update)
+	return err
 }
 
 // ----- Fetch -----
 
-func fetchPage(u string) (*goquery.Document, error) {
-	client := &http.Client{
-		Timeout: RequestTimeout,
-	}
+type fetchResult struct {
+	Body         []byte   // text transcoded to UTF-8, PDFs as sent
+	Type         string   // media type, one of documentTypes
+	FinalURL     string   // after following redirects
+	Redirects    []string // URLs that redirected, in order
+	RobotsTag    []string // X-Robots-Tag header values
+	ETag         string
+	LastModified string
+	NotModified  bool // 304 in reply to a conditional request
+	Meta         ResponseMeta
+}
 
-	resp, err := client.Get(u)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+// fetchPage sends If-None-Match / If-Modified-Since when prev is known.
+// URLs that don't look like pages are screened with a HEAD request first.
+// wait paces every request after the first, HEAD, GET or retry; see paced.
+func fetchPage(ctx context.Context, client *http.Client, policy retryPolicy, u string, prev *pageMeta, wait func() error) (*fetchResult, error) {
+	screened := !needsScreening(u)
+	pace := paced(wait)
+	return withRetry(ctx, policy, func() (*fetchResult, *fetchError) {
+		if !screened {
+			if fe := pace(); fe != nil {
+				return nil, fe
+			}
+			if fe := screen(ctx, client, u); fe != nil {
+				return nil, fe
+			}
+			screened = true
+		}
+		if fe := pace(); fe != nil {
+			return nil, fe
+		}
 
-	contentType := resp.Header.Get("Content-Type")
-	if !strings.Contains(contentType, "text/html") {
-		return nil, fmt.Errorf("non-html content type: %s", contentType)
-	}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, &fetchError{Err: err}
+		}
+		if prev != nil {
+			if prev.ETag != "" {
+				req.Header.Set("If-None-Match", prev.ETag)
+			}
+			if prev.LastModified != "" {
+				req.Header.Set("If-Modified-Since", prev.LastModified)
+			}
+		}
 
-	limited := io.LimitReader(resp.Body, MaxBodyBytes)
-	body, err := io.ReadAll(limited)
-	if err != nil {
-		return nil, err
-	}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, networkError(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotModified && prev != nil {
+			return &fetchResult{NotModified: true}, nil
+		}
+		if resp.StatusCode < 200 || resp.StatusCode > 299 {
+			return nil, statusError(resp)
+		}
+
+		contentType := resp.Header.Get("Content-Type")
+		mediaType, limit, ok := documentType(contentType)
+		if !ok {
+			return nil, &fetchError{Status: resp.StatusCode, Err: fmt.Errorf("unsupported content type: %s", contentType)}
+		}
 
-	return goquery.NewDocumentFromReader(bytes.NewReader(body))
+		limited := io.LimitReader(resp.Body, limit)
+		raw, err := io.ReadAll(limited)
+		if err != nil {
+			return nil, networkError(err)
+		}
+		body := raw
+		if isText(mediaType) {
+			if body, err = io.ReadAll(utf8Reader(raw, contentType)); err != nil {
+				return nil, &fetchError{Status: resp.StatusCode, Err: err}
+			}
+		}
+
+		final, hops := redirectChain(resp)
+		return &fetchResult{
+			Body:         body,
+			Type:         mediaType,
+			FinalURL:     final,
+			Redirects:    hops,
+			RobotsTag:    resp.Header.Values("X-Robots-Tag"),
+			ETag:         resp.Header.Get("ETag"),
+			LastModified: resp.Header.Get("Last-Modified"),
+			Meta: ResponseMeta{
+				StatusCode:      resp.StatusCode,
+				FinalURL:        final,
+				ContentType:     contentType,
+				ContentLength:   wireLength(resp),
+				BodyBytes:       len(raw),
+				Server:          resp.Header.Get("Server"),
+				ContentLanguage: resp.Header.Get("Content-Language"),
+				FetchDuration:   time.Since(start),
+			},
+		}, nil
+	})
+}
+
+// redirectChain walks resp.Request back through the responses that caused
+// each redirect.
+func redirectChain(resp *http.Response) (string, []string) {
+	final := *resp.Request.URL
+	canonicalizeURL(&final)
+
+	var hops []string
+	for r := resp.Request; r.Response != nil; r = r.Response.Request {
+		hops = append([]string{r.Response.Request.URL.String()}, hops...)
+	}
+	return final.String(), hops
 }
 
 // ----- Extract Page (Upgraded) -----
@@ -178,44 +509,38 @@ func fetchPage(u string) (*goquery.Document, error) {
 func extractPage(u string, doc *goquery.Document) Page {
 
 	parsedURL, _ := url.Parse(u)
+	main, mainTxt := mainContent(doc)
 
 	// TITLE
-	title := strings.TrimSpace(doc.Find("title").First().Text())
+	title := cleanText(doc.Find("title").First().Text())
 
 	// SNIPPET PRIORITY:
 	snippet := ""
 
 	// 1. meta description
 	if desc, ok := doc.Find(`meta[name="description"]`).Attr("content"); ok {
-		snippet = strings.TrimSpace(desc)
+		snippet = cleanText(desc)
 	}
 
 	// 2. og:description
 	if snippet == "" {
 		if og, ok := doc.Find(`meta[property="og:description"]`).Attr("content"); ok {
-			snippet = strings.TrimSpace(og)
+			snippet = cleanText(og)
 		}
 	}
 
-	// 3. first <p>
+	// 3. a summary of the main content's paragraphs
 	if snippet == "" {
-		doc.Find("p").Each(func(i int, s *goquery.Selection) {
-			txt := strings.TrimSpace(s.Text())
-			if len(txt) > 40 && snippet == "" {
-				snippet = txt
+		var paras []string
+		main.Find("p").Each(func(i int, s *goquery.Selection) {
+			if txt := visibleText(s); txt != "" {
+				paras = append(paras, txt)
 			}
 		})
-	}
-
-	// 4. fallback
-	if snippet == "" {
-		raw := strings.TrimSpace(doc.Find("body").Text())
-		runes := []rune(raw)
-		if len(runes) > 300 {
-			snippet = string(runes[:300])
-		} else {
-			snippet = raw
+		if len(paras) == 0 {
+			paras = []string{mainTxt}
 		}
+		snippet = summarize(paras)
 	}
 
 	// FAVICON
@@ -229,17 +554,17 @@ func extractPage(u string, doc *goquery.Document) Page {
 	})
 
 	if favicon != "" {
-		fu, err := url.Parse(favicon)
-		if err == nil && !fu.IsAbs() {
-			fu = parsedURL.ResolveReference(fu)
+		if fu, err := url.Parse(strings.TrimSpace(favicon)); err == nil {
+			favicon = parsedURL.ResolveReference(fu).String()
+		} else {
+			favicon = ""
 		}
-		favicon = fu.String()
 	}
 
 	// SITE NAME
 	siteName := parsedURL.Hostname()
 	if sn, ok := doc.Find(`meta[property="og:site_name"]`).Attr("content"); ok {
-		if strings.TrimSpace(sn) != "" {
+		if sn = cleanText(sn); sn != "" {
 			siteName = sn
 		}
 	}
@@ -254,156 +579,916 @@ func extractPage(u string, doc *goquery.Document) Page {
 			img = twImg
 		}
 	}
+	images := extractImages(doc, parsedURL)
 	if img == "" {
-		doc.Find("img").Each(func(i int, s *goquery.Selection) {
-			if img != "" {
-				return
-			}
-			src, ok := s.Attr("src")
-			if !ok || src == "" {
-				return
-			}
-			if strings.Contains(src, "logo") {
-				return
-			}
-			img = src
-		})
+		img = bestImage(images)
 	}
 	if img != "" {
-		iu, err := url.Parse(img)
-		if err == nil && !iu.IsAbs() {
-			iu = parsedURL.ResolveReference(iu)
+		if iu, err := url.Parse(strings.TrimSpace(img)); err == nil {
+			img = parsedURL.ResolveReference(iu).String()
+		} else {
+			img = ""
 		}
-		img = iu.String()
 	}
 
 	// FULL TEXT
-	text := strings.TrimSpace(doc.Find("body").Text())
-	runes := []rune(text)
-	if len(runes) > MaxTextChars {
-		text = string(runes[:MaxTextChars])
-	}
+	text := truncateRunes(visibleText(doc.Find("body")), MaxTextChars)
+	mainTxt = truncateRunes(mainTxt, MaxTextChars)
 
 	// LINKS
-	var links []string
+	var links []Link
+	blocks := make(blockTexts)
 	doc.Find("a[href]").Each(func(i int, s *goquery.Selection) {
 		h, _ := s.Attr("href")
-		links = append(links, safeUTF8(h))
+		anchor := visibleText(s)
+		if anchor == "" {
+			anchor = cleanText(s.Find("img[alt]").First().AttrOr("alt", s.AttrOr("title", "")))
+		}
+		l := newLink(h, s.AttrOr("rel", ""), anchor, parsedURL)
+		l.Context = blocks.context(s.Get(0), l.AnchorText)
+		links = append(links, l)
 	})
 
+	reason := restriction(doc, text)
+	article := articleMeta(doc)
+
 	return Page{
-		URL:       u,
-		Title:     title,
-		Snippet:   snippet,
-		Favicon:   favicon,
-		SiteName:  siteName,
-		Image:     img,
-		Text:      text,
-		Links:     links,
-		CrawlTime: time.Now().UTC(),
+		URL:              u,
+		Title:            title,
+		Snippet:          snippet,
+		Favicon:          favicon,
+		SiteName:         siteName,
+		Image:            img,
+		Text:             text,
+		MainText:         mainTxt,
+		Headings:         extractHeadings(doc),
+		PublishedAt:      article.Published,
+		ModifiedAt:       article.Modified,
+		Author:           article.Author,
+		Structured:       extractStructured(doc),
+		Breadcrumbs:      extractBreadcrumbs(doc, parsedURL),
+		OG:               extractSocialCard(doc, parsedURL),
+		Alternates:       extractAlternates(doc, parsedURL),
+		Tags:             extractTags(doc),
+		Images:           images,
+		Media:            extractMedia(doc, parsedURL),
+		Links:            links,
+		CrawlTime:        time.Now().UTC(),
+		Restricted:       reason != "",
+		RestrictedReason: reason,
 	}
 }
 
 // ----- Crawling -----
 
-func crawlSeeds(ctx context.Context, col *mongo.Collection) error {
+type QueueItem struct {
+	URL      string `bson:"url"`
+	Depth    int    `bson:"depth"`
+	MaxDepth int    `bson:"max_depth"` // inherited from the seed the item was discovered from
+
+	// Topic relevance of the page the item was found on (focused crawls).
+	Relevance float64 `bson:"relevance,omitempty"`
+}
+
+// crawler holds the state shared by all workers of one run.
+type crawler struct {
+	runID           primitive.ObjectID
+	store           Store
+	stop            <-chan struct{}
+	checkpointN     int
+	storeRaw        bool       // STORE_RAW
+	search          *liveIndex // nil with INDEX_ON_CRAWL=false
+	cfg             *crawlConfig
+	client          *http.Client
+	renderer        *chromeRenderer
+	robots          *robotsCache
+	limiter         *hostLimiter
+	breaker         *hostBreaker
+	retry           retryPolicy
+	permanentTTL    time.Duration
+	domainBudget    int
+	recrawlAfter    time.Duration
+	adaptive        bool
+	followNoindex   bool
+	linkPolicies    linkPolicies
+	simhashes       *simhashWindow
+	collapseNearDup bool
+	allowedDomains  domainScope
+	strategy        crawlStrategy
+	https           httpsMode
+	parser          htmlParser
+	favicons        *faviconChecker
+
+	mu           sync.Mutex
+	cond         *sync.Cond
+	frontier     *frontier
+	traps        *trapDetector
+	score        ScoreFunc
+	visited      VisitedSet           // fetched or rejected; see seenLocked
+	active       map[string]QueueItem // in flight
+	inFlight     int
+	pagesCrawled int
+	domainPages  map[string]int         // budget key -> pages fetched this run
+	reserved     map[string]reservation // in-flight URL -> the budget it holds
+	failures     map[string]string      // URL -> final failure reason
+	parked       map[string][]QueueItem // host -> items held while its circuit is open
+	stats        runStats
+}
+
+// crawlSummary is what a finished crawl reports back to its run.
+type crawlSummary struct {
+	Pages       int
+	Failures    int
+	Trips       []hostTrip // circuit breaker openings
+	Report      crawlReport
+	Interrupted bool // stopped early; a checkpoint was written
+}
+
+type crawlOptions struct {
+	RunID primitive.ObjectID
+	// Closing Stop makes the workers finish their current fetches and take
+	// nothing new; whatever is left is then checkpointed under RunID.
+	Stop <-chan struct{}
+	// Resume continues from a checkpoint instead of starting from seeds.
+	Resume *checkpoint
+}
+
+func crawlSeeds(ctx context.Context, store Store, opts crawlOptions) (crawlSummary, error) {
 
 	seedsEnv := getEnv("SEED_URLS", "")
 	if seedsEnv == "" {
-		return fmt.Errorf("SEED_URLS not set")
+		return crawlSummary{}, fmt.Errorf("SEED_URLS not set")
 	}
 	seeds := strings.Split(seedsEnv, ",")
 
-	allowedDomainsEnv := getEnv("ALLOWED_DOMAINS", "")
-	var allowedDomains []string
-	if allowedDomainsEnv != "" {
-		for _, d := range strings.Split(allowedDomainsEnv, ",") {
-			allowedDomains = append(allowedDomains, strings.TrimSpace(d))
+	allowedDomains, err := domainScopeFromEnv()
+	if err != nil {
+		return crawlSummary{}, err
+	}
+
+	cfg, err := loadCrawlConfig()
+	if err != nil {
+		return crawlSummary{}, err
+	}
+
+	proxies, err := loadProxyPool()
+	if err != nil {
+		return crawlSummary{}, err
+	}
+
+	strategy, err := strategyFromEnv()
+	if err != nil {
+		return crawlSummary{}, err
+	}
+	https, err := httpsModeFromEnv()
+	if err != nil {
+		return crawlSummary{}, err
+	}
+	visited, err := newVisitedSet()
+	if err != nil {
+		return crawlSummary{}, err
+	}
+	polite := politeness{
+		Delay:  getEnvDuration("HOST_CRAWL_INTERVAL", PolitenessDelay),
+		Jitter: getEnvFloat("HOST_CRAWL_JITTER", 0.1),
+	}
+
+	userAgent := getEnv("CRAWLER_USER_AGENT", DefaultUserAgent)
+	guard := newNetGuard(proxies)
+	client := &http.Client{
+		Jar:     newCookieJar(cfg),
+		Timeout: getEnvDuration("REQUEST_TIMEOUT", RequestTimeout),
+		Transport: &crawlerTransport{
+			base:      newBaseTransport(transportConfigFromEnv(), proxies, guard),
+			cfg:       cfg,
+			proxies:   proxies,
+			guard:     guard,
+			httpsOnly: https != httpsAny,
+			userAgent: userAgent,
+		},
+	}
+
+	// IGNORE_ROBOTS=true skips robots.txt, for private deployments only.
+	var robots *robotsCache
+	if !getEnvBool("IGNORE_ROBOTS", false) {
+		robots = newRobotsCache(client, CrawlerName, store)
+	}
+
+	login(ctx, client, cfg)
+
+	c := &crawler{
+		runID:           opts.RunID,
+		store:           store,
+		stop:            opts.Stop,
+		checkpointN:     getEnvInt("CHECKPOINT_EVERY", 100),
+		storeRaw:        getEnvBool("STORE_RAW", false),
+		cfg:             cfg,
+		client:          client,
+		renderer:        newChromeRenderer(userAgent, guard),
+		robots:          robots,
+		limiter:         newHostLimiter(polite, func(host string) (politeness, bool) { return cfg.politenessFor(host, polite) }),
+		breaker:         newHostBreaker(),
+		retry:           retryPolicyFromEnv(),
+		permanentTTL:    getEnvDuration("PERMANENT_ERROR_TTL", 30*24*time.Hour),
+		domainBudget:    getEnvInt("MAX_PAGES_PER_DOMAIN", 0),
+		recrawlAfter:    getEnvDuration("RECRAWL_AFTER", 0),
+		adaptive:        getEnvBool("ADAPTIVE_RECRAWL", true),
+		followNoindex:   getEnvBool("FOLLOW_NOINDEX_LINKS", true),
+		linkPolicies:    linkPoliciesFromEnv(),
+		simhashes:       newSimhashWindow(),
+		collapseNearDup: getEnv("NEAR_DUPLICATES", "flag") == "collapse",
+		allowedDomains:  allowedDomains,
+		strategy:        strategy,
+		https:           https,
+		parser:          parserFromEnv(),
+		favicons:        newFaviconChecker(client),
+		frontier:        newFrontier(strategy.LIFO),
+		traps:           newTrapDetector(getEnvInt("TRAP_PREFIX_LIMIT", 1000), getEnvInt("URL_MAX_LENGTH", MaxURLLength), getEnvInt("URL_MAX_PARAMS", MaxQueryParams)),
+		score:           strategy.Score,
+		visited:         visited,
+		active:          make(map[string]QueueItem),
+		domainPages:     make(map[string]int),
+		reserved:        make(map[string]reservation),
+		failures:        make(map[string]string),
+		parked:          make(map[string][]QueueItem),
+		stats:           newRunStats(),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	if getEnvBool("INDEX_ON_CRAWL", true) {
+		search, err := openLiveIndex(indexDir())
+		if err != nil {
+			log.Printf("index: %v; pages won't be indexed as they are crawled", err)
 		}
+		c.search = search
 	}
 
-	type QueueItem struct {
-		URL   string
-		Depth int
+	if err := c.simhashes.loadRecent(ctx, c.store); err != nil {
+		log.Printf("simhash: %v", err)
 	}
 
-	queue := []QueueItem{}
-	visited := make(map[string]bool)
+	if opts.Resume != nil {
+		c.restore(opts.Resume)
+		log.Printf("resume: %d queued, %d visited from run %s", len(opts.Resume.Frontier), len(opts.Resume.Visited), opts.Resume.RunID.Hex())
+		c.enqueue(opts.Resume.Frontier...)
+		return c.finish(ctx)
+	}
 
+	var queue []QueueItem
 	for _, s := range seeds {
-		s = strings.TrimSpace(s)
-		if s != "" {
-			queue = append(queue, QueueItem{URL: s, Depth: 0})
+		norm, err := normalizeURL(&url.URL{}, s)
+		if err != nil {
+			continue
 		}
+		queue = append(queue, QueueItem{URL: norm.String(), Depth: 0, MaxDepth: c.seedDepth(norm)})
 	}
 
-	pagesCrawled := 0
+	// Sitemap URLs are treated like seeds.
+	if getEnvBool("USE_SITEMAPS", true) {
+		origins := make(map[string]bool)
+		for _, item := range queue {
+			u, err := url.Parse(item.URL)
+			if err != nil || origins[u.Scheme+"://"+u.Host] {
+				continue
+			}
+			origins[u.Scheme+"://"+u.Host] = true
 
-	for len(queue) > 0 && pagesCrawled < MaxPagesPerRun {
+			found := sitemapURLs(ctx, client, discoverSitemaps(ctx, robots, u))
+			log.Printf("sitemap: %d URLs for %s", len(found), u.Host)
+			for _, loc := range found {
+				if norm, err := normalizeURL(u, loc); err == nil && cfg.urlAllowed(norm) {
+					queue = append(queue, QueueItem{URL: norm.String(), Depth: 0, MaxDepth: item.MaxDepth})
+				}
+			}
+		}
+	}
 
-		item := queue[0]
-		queue = queue[1:]
+	// Pages whose schedule says they are due get revisited along with their
+	// direct links, which is where new content on hub pages shows up.
+	if c.adaptive {
+		due, err := c.store.DueForRecrawl(ctx, MaxPagesPerRun/2)
+		if err != nil {
+			log.Printf("recrawl: %v", err)
+		}
+		for _, u := range due {
+			queue = append(queue, QueueItem{URL: u, Depth: 0, MaxDepth: 1})
+		}
+	}
 
-		if visited[item.URL] {
-			continue
+	c.enqueue(queue...)
+	return c.finish(ctx)
+}
+
+// finish runs the workers to completion and checkpoints leftover work.
+func (c *crawler) finish(ctx context.Context) (crawlSummary, error) {
+	c.run(ctx, getEnvInt("CRAWL_WORKERS", DefaultWorkers))
+	c.renderer.Close()
+	if c.search != nil {
+		if err := c.search.save(); err != nil {
+			log.Printf("index: %v", err)
 		}
-		visited[item.URL] = true
+		c.search.close()
+	}
 
-		parsedURL, err := url.Parse(item.URL)
-		if err != nil {
-			continue
+	if len(c.failures) > 0 {
+		log.Printf("%d URLs failed this run", len(c.failures))
+	}
+	summary := crawlSummary{Pages: c.pagesCrawled, Failures: len(c.failures), Trips: c.breaker.Trips(), Report: c.report()}
+	summary.Report.Log()
+
+	if c.stopped() || ctx.Err() != nil {
+		summary.Interrupted = true
+		if err := c.checkpoint(); err != nil {
+			return summary, err
 		}
+	}
+	return summary, nil
+}
 
-		if !isAllowedDomain(parsedURL, allowedDomains) {
-			continue
+// checkpoint saves the current state for a later -resume. ctx may be the
+// reason the run stopped, so it saves on a fresh one.
+func (c *crawler) checkpoint() error {
+	cp := c.snapshot(c.runID)
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := c.store.SaveCheckpoint(ctx, cp); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	log.Printf("checkpoint: %d queued, %d visited saved for run %s", len(cp.Frontier), len(cp.Visited), c.runID.Hex())
+	return nil
+}
+
+// run starts the workers and waits until the frontier drains or the page
+// budget is spent.
+func (c *crawler) run(ctx context.Context, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	// Wake idle workers on stop so they notice it.
+	finished := make(chan struct{})
+	defer close(finished)
+	go func() {
+		select {
+		case <-c.stop:
+			log.Printf("shutdown: finishing in-flight fetches")
+			c.mu.Lock()
+			c.cond.Broadcast()
+			c.mu.Unlock()
+		case <-finished:
 		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, ok := c.next(ctx)
+				if !ok {
+					return
+				}
+				c.process(ctx, item)
+				c.done(item)
+			}
+		}()
+	}
+	wg.Wait()
+}
 
-		exists, err := pageExists(ctx, col, item.URL)
-		if err == nil && exists {
+func (c *crawler) enqueue(items ...QueueItem) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range items {
+		var ok bool
+		if item.URL, ok = c.https.apply(item.URL); !ok {
 			continue
 		}
-
-		log.Printf("Fetching: %s", item.URL)
-		doc, err := fetchPage(item.URL)
-		if err != nil {
-			log.Printf("error: %v", err)
+		if c.seenLocked(item.URL) {
 			continue
 		}
+		inlinks := c.frontier.Discover(item.URL) - 1
+		if inlinks == 0 && item.Depth > 0 {
+			if u, err := url.Parse(item.URL); err == nil {
+				if _, trapped := c.traps.Check(u); trapped {
+					c.visited.Add(item.URL)
+					continue
+				}
+			}
+		}
+		sig := FrontierSignals{
+			Inlinks:       inlinks,
+			HostRemaining: c.budgetRemainingLocked(item.URL),
+		}
+		c.frontier.Push(item, c.score(item, sig))
+	}
+	c.cond.Broadcast()
+}
+
+// next blocks until an unvisited item is available. It returns false once
+// the queue is empty with nothing in flight or parked, or the budget is
+// used up. Items on hosts with an open circuit are parked until it closes.
+func (c *crawler) next(ctx context.Context) (QueueItem, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for {
+		if c.stopped() || ctx.Err() != nil || c.pagesCrawled+c.inFlight >= MaxPagesPerRun {
+			return QueueItem{}, false
+		}
+		if c.frontier.Len() > 0 {
+			item := c.frontier.Pop()
+			if c.seenLocked(item.URL) {
+				continue
+			}
+			if c.parkLocked(item) {
+				continue
+			}
+			c.active[item.URL] = item
+			c.inFlight++
+			return item, true
+		}
+		if c.inFlight == 0 && len(c.parked) == 0 {
+			return QueueItem{}, false
+		}
+		c.cond.Wait()
+	}
+}
+
+// parkLocked holds item back if its host's circuit is open, and drops it
+// if the host has been given up on. c.mu must be held.
+func (c *crawler) parkLocked(item QueueItem) bool {
+	u, err := url.Parse(item.URL)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	wait, dead, open := c.breaker.Open(host)
+	if !open {
+		return false
+	}
+	if dead {
+		return true
+	}
+	if len(c.parked[host]) == 0 {
+		time.AfterFunc(wait, func() { c.unpark(host) })
+	}
+	c.parked[host] = append(c.parked[host], item)
+	return true
+}
+
+// unpark returns host's parked items to the frontier once its cool-down
+// has passed.
+func (c *crawler) unpark(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items := c.parked[host]
+	delete(c.parked, host)
+	for _, item := range items {
+		sig := FrontierSignals{
+			Inlinks:       c.frontier.Inlinks(item.URL) - 1,
+			HostRemaining: c.budgetRemainingLocked(item.URL),
+		}
+		c.frontier.Push(item, c.score(item, sig))
+	}
+	c.cond.Broadcast()
+}
+
+// fetch downloads item, or renders it in headless Chrome when its domain
+// is configured with "renderer": "chrome".
+func (c *crawler) fetch(ctx context.Context, u *url.URL, rawURL string, prev *pageMeta) (*fetchResult, error) {
+	wait := func() error { return c.limiter.Wait(ctx, u.Hostname()) }
+	if dc, ok := c.cfg.forHost(u.Hostname()); ok && dc.Renderer == "chrome" {
+		pace := paced(wait)
+		return withRetry(ctx, c.retry, func() (*fetchResult, *fetchError) {
+			if fe := pace(); fe != nil {
+				return nil, fe
+			}
+			return c.renderer.Render(ctx, rawURL, dc)
+		})
+	}
+	return fetchPage(ctx, c.client, c.retry, rawURL, prev, wait)
+}
 
-		page := extractPage(item.URL, doc)
-		upsertPage(ctx, col, page)
+// recrawlDue decides whether a stored page should be fetched again. With
+// ADAPTIVE_RECRAWL the page's own schedule wins; otherwise, or for pages
+// stored before scheduling existed, RECRAWL_AFTER applies (0 = never).
+func (c *crawler) recrawlDue(prev *pageMeta) bool {
+	if c.adaptive && !prev.NextCrawl.IsZero() {
+		return !time.Now().Before(prev.NextCrawl)
+	}
+	return c.recrawlAfter > 0 && time.Since(prev.CrawlTime) >= c.recrawlAfter
+}
 
-		pagesCrawled++
-		log.Printf("Crawled %d pages", pagesCrawled)
+// seedDepth is the link depth allowed below a seed on u's domain.
+func (c *crawler) seedDepth(u *url.URL) int {
+	if dc, ok := c.cfg.forHost(u.Hostname()); ok && dc.MaxDepth > 0 {
+		return dc.MaxDepth
+	}
+	return MaxDepth
+}
 
-		if item.Depth < MaxDepth {
-			for _, href := range page.Links {
-				norm, err := normalizeURL(parsedURL, href)
-				if err == nil && !visited[norm.String()] {
-					queue = append(queue, QueueItem{URL: norm.String(), Depth: item.Depth + 1})
-				}
+// budgetFor returns host's budget key and page limit (0 = unlimited).
+// Budgets are tracked per configured domain, or per hostname when none
+// matches.
+func (c *crawler) budgetFor(host string) (string, int) {
+	key, dc, ok := c.cfg.lookup(host)
+	if !ok {
+		key = strings.ToLower(host)
+	}
+	if dc.MaxPages > 0 {
+		return key, dc.MaxPages
+	}
+	return key, c.domainBudget
+}
+
+// budgetRemainingLocked is the budget left for rawURL's host, or -1 when
+// unlimited. c.mu must be held.
+func (c *crawler) budgetRemainingLocked(rawURL string) int {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return -1
+	}
+	key, limit := c.budgetFor(u.Hostname())
+	if limit <= 0 {
+		return -1
+	}
+	return max(limit-c.domainPages[key], 0)
+}
+
+// reservation is the budget page an in-flight URL holds until done, and
+// whether it already counts in pagesCrawled.
+type reservation struct {
+	key     string
+	crawled bool
+}
+
+// reserveBudget claims one page of host's per-run budget for rawURL.
+func (c *crawler) reserveBudget(rawURL, host string) bool {
+	key, limit := c.budgetFor(host)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if limit > 0 && c.domainPages[key] >= limit {
+		return false
+	}
+	c.domainPages[key]++
+	c.reserved[rawURL] = reservation{key: key}
+	return true
+}
+
+func (c *crawler) releaseBudget(rawURL string) {
+	c.mu.Lock()
+	if r, ok := c.reserved[rawURL]; ok {
+		c.domainPages[r.key]--
+		delete(c.reserved, rawURL)
+	}
+	c.mu.Unlock()
+}
+
+// markVisited records a URL reached indirectly (e.g. as a redirect target).
+func (c *crawler) markVisited(u string) {
+	c.mu.Lock()
+	c.visited.Add(u)
+	c.mu.Unlock()
+}
+
+// pageDirectives merges X-Robots-Tag headers with <meta name="robots"> and
+// <meta name="basicsearchbot"> tags. Ignored along with robots.txt.
+func (c *crawler) pageDirectives(res *fetchResult, ex *extraction) robotsDirectives {
+	var d robotsDirectives
+	if c.robots == nil {
+		return d
+	}
+	for _, v := range res.RobotsTag {
+		d.parse(v, CrawlerName)
+	}
+	for _, v := range ex.Robots {
+		d.parse(v, CrawlerName)
+	}
+	return d
+}
+
+func (c *crawler) stopped() bool {
+	select {
+	case <-c.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// seenLocked reports whether u is in flight or already done. URLs join the
+// visited set only once processed, so a checkpoint's set never contains
+// in-flight URLs, which matters for sets that can't forget. c.mu must be
+// held.
+func (c *crawler) seenLocked(u string) bool {
+	_, inFlight := c.active[u]
+	return inFlight || c.visited.Has(u)
+}
+
+func (c *crawler) done(item QueueItem) {
+	c.mu.Lock()
+	c.visited.Add(item.URL)
+	delete(c.active, item.URL)
+	delete(c.reserved, item.URL)
+	c.inFlight--
+	c.cond.Broadcast()
+	c.mu.Unlock()
+}
+
+func (c *crawler) process(ctx context.Context, item QueueItem) {
+	parsedURL, err := url.Parse(item.URL)
+	if err != nil {
+		return
+	}
+
+	if !isAllowedDomain(parsedURL, c.allowedDomains) {
+		return
+	}
+
+	if c.robots != nil {
+		if !c.robots.Allowed(ctx, parsedURL) {
+			log.Printf("robots: disallowed %s", item.URL)
+			return
+		}
+		if d := c.robots.CrawlDelay(ctx, parsedURL); d > 0 {
+			c.limiter.SetMinInterval(parsedURL.Hostname(), d)
+		}
+	}
+
+	prev, err := c.store.PageMeta(ctx, item.URL)
+	if err != nil {
+		return
+	}
+	if prev != nil && !c.recrawlDue(prev) {
+		return
+	}
+
+	if c.store.PermanentlyFailed(ctx, item.URL, c.permanentTTL) {
+		return
+	}
+
+	if !c.reserveBudget(item.URL, parsedURL.Hostname()) {
+		return
+	}
+	fetched := false
+	defer func() {
+		if !fetched {
+			c.releaseBudget(item.URL)
+		}
+	}()
+
+	if err := c.limiter.Wait(ctx, parsedURL.Hostname()); err != nil {
+		return
+	}
+
+	log.Printf("Fetching: %s", item.URL)
+	res, err := c.fetch(ctx, parsedURL, item.URL, prev)
+	if err != nil {
+		log.Printf("error: %s: %v", item.URL, err)
+		c.mu.Lock()
+		c.failures[item.URL] = err.Error()
+		c.mu.Unlock()
+		c.recordFailure(err)
+		c.store.RecordCrawlError(ctx, item.URL, err)
+		if ctx.Err() == nil && hostFailure(err) {
+			if cooldown, tripped := c.breaker.Failure(parsedURL.Hostname(), err); tripped {
+				log.Printf("breaker: %s failing, pausing it for %s", parsedURL.Hostname(), cooldown)
 			}
 		}
+		return
+	}
+	c.breaker.Success(parsedURL.Hostname())
 
-		time.Sleep(PolitenessDelay)
+	fetched = true
+	c.recordFetch(res)
+	c.store.ClearCrawlError(ctx, item.URL)
+
+	if res.NotModified {
+		log.Printf("Unchanged: %s", item.URL)
+		c.store.TouchPage(ctx, item.URL, nextSchedule(prev, false))
+		return
 	}
 
-	return nil
+	// Store under the final URL and remember how we got there.
+	if res.FinalURL != item.URL {
+		finalURL, err := url.Parse(res.FinalURL)
+		if err != nil || !isAllowedDomain(finalURL, c.allowedDomains) {
+			log.Printf("redirect: %s left allowed domains", item.URL)
+			return
+		}
+		c.markVisited(res.FinalURL)
+		parsedURL = finalURL
+	}
+
+	ex, err := c.parser.extract(res, parsedURL)
+	if err != nil {
+		log.Printf("parse: %s: %v", res.FinalURL, err)
+		return
+	}
+	var ok bool
+	if res, ex, parsedURL, ok = c.followRefresh(ctx, res, ex, parsedURL); !ok {
+		return
+	}
+
+	directives := c.pageDirectives(res, ex)
+
+	page := ex.Page
+	var followLinks []Link
+	page.Links, followLinks = applyLinkPolicies(c.linkPolicies, page.Links)
+	page.Aliases = res.Redirects
+
+	// Prefer the page's declared canonical URL as its storage key.
+	if canon := ex.Canonical; canon != "" && canon != page.URL {
+		if cu, err := url.Parse(canon); err == nil && isAllowedDomain(cu, c.allowedDomains) {
+			page.Aliases = append(page.Aliases, page.URL)
+			page.URL = canon
+			c.markVisited(canon)
+		}
+	}
+
+	page.ETag = res.ETag
+	page.LastModified = res.LastModified
+	page.Response = res.Meta
+	page.ContentHash = contentHash(page.Text)
+	change := detectChange(prev, page.MainText, page.ContentHash)
+	change.apply(&page)
+	nextSchedule(prev, change.significant()).apply(&page)
+
+	store := !directives.NoIndex
+	if !store {
+		log.Printf("robots: noindex %s", page.URL)
+	}
+
+	// Mirrors and print views: fold into the existing document.
+	if store && len(page.Text) >= MinDedupeChars {
+		if orig, err := c.store.FindDuplicate(ctx, page.ContentHash, page.URL); err == nil && orig != "" {
+			log.Printf("duplicate: %s of %s", page.URL, orig)
+			c.store.AddAlias(ctx, orig, page.URL)
+			store = false
+		}
+	}
+
+	// Near-duplicates are flagged, or with NEAR_DUPLICATES=collapse folded
+	// into the page they resemble like exact duplicates.
+	if fp, ok := simHash(page.Text); ok && store {
+		page.SimHash = int64(fp)
+		if orig, found := c.simhashes.Nearest(fp, page.URL); found {
+			log.Printf("near-duplicate: %s of %s", page.URL, orig)
+			if c.collapseNearDup {
+				c.store.AddAlias(ctx, orig, page.URL)
+				store = false
+			} else {
+				page.NearDuplicateOf = orig
+			}
+		}
+		if store {
+			c.simhashes.Add(page.URL, fp)
+		}
+	}
+
+	if store {
+		page.Favicon = c.favicons.resolve(ctx, page.Favicon, parsedURL)
+		c.store.UpsertPage(ctx, page)
+		if c.storeRaw {
+			if err := c.store.StoreRaw(ctx, page.URL, res); err != nil {
+				log.Print(err)
+			}
+		}
+		if c.search != nil {
+			c.search.update(ctx, c.store, page)
+		}
+	} else if c.search != nil {
+		c.search.remove(page.URL)
+	}
+
+	c.mu.Lock()
+	c.pagesCrawled++
+	if r, ok := c.reserved[item.URL]; ok {
+		r.crawled = true
+		c.reserved[item.URL] = r
+	}
+	c.stats.hosts[parsedURL.Hostname()]++
+	n := c.pagesCrawled
+	c.mu.Unlock()
+	log.Printf("Crawled %d pages", n)
+
+	if c.checkpointN > 0 && n%c.checkpointN == 0 {
+		if err := c.checkpoint(); err != nil {
+			log.Print(err)
+		}
+		if c.search != nil {
+			if err := c.search.save(); err != nil {
+				log.Printf("index: %v", err)
+			}
+		}
+	}
+
+	follow := !directives.NoFollow && (!directives.NoIndex || c.followNoindex)
+	if follow && item.Depth < item.MaxDepth {
+		var relevance float64
+		if c.strategy.Focused() {
+			relevance = c.strategy.Relevance(page.Title + " " + page.Text)
+		}
+		var next []QueueItem
+		for _, link := range followLinks {
+			norm, err := url.Parse(link.URL) // resolved at extraction
+			if err == nil && c.cfg.urlAllowed(norm) {
+				next = append(next, QueueItem{URL: link.URL, Depth: item.Depth + 1, MaxDepth: item.MaxDepth, Relevance: relevance})
+			}
+		}
+		c.enqueue(next...)
+	}
 }
 
+// Usage:
+//
+//	crawler [crawl [-resume <run-id>]]
+//	crawler daemon
+//	crawler reextract [-url <url>]
+//	crawler index [rebuild | compact | delete [-domain <domain>] [<url>...] | stats]
+//	crawler snapshot <target>
+//	crawler restore <source>
+//	crawler search <query>
+//	crawler suggest <prefix>
+//
+//	crawl      one crawl run (default)
+//	daemon     crawl on CRAWL_SCHEDULE until stopped
+//	reextract  rebuild pages from raw_pages with the current extraction code
+//	index      rebuild the search index in INDEX_DIR from the stored pages;
+//	           compact merges its segments, delete takes pages out of it,
+//	           stats prints its size and contents
+//	snapshot   copy the index in INDEX_DIR to a directory or s3://bucket/prefix
+//	restore    replace the index in INDEX_DIR with a snapshot, for query
+//	           servers that don't rebuild from the store
+//	search     query the search index; quote phrases: search '"web crawler" tutorial',
+//	           end words in * for prefixes: search 'crawl* golang';
+//	           SEARCH_BACKEND=mongo searches a Mongo text index instead,
+//	           SEARCH_BACKEND=bleve a Bleve index and SEARCH_BACKEND=sqlite
+//	           an SQLite FTS5 index, both filled by crawls and rebuilds
+//	suggest    complete a partly typed query to page titles: suggest 'web cra'
+//
+// Pages and crawl state are kept in MongoDB, or with STORE=sqlite in the
+// SQLite file SQLITE_PATH: with SEARCH_BACKEND=sqlite as well, nothing but
+// that file is needed.
 func main() {
 	godotenv.Load()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	cmd, args := "crawl", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		cmd, args = args[0], args[1:]
+	}
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	resume := fs.String("resume", "", "continue the crawl checkpointed by this run id")
+	only := fs.String("url", "", "reextract only this page")
+	fs.Parse(args)
+
+	// Searching only reads the index; it needs no store unless
+	// SEARCH_BACKEND is mongo, which connects itself. Snapshots only copy
+	// it.
+	switch cmd {
+	case "search", "suggest", "snapshot", "restore":
+		run := map[string]func([]string) error{
+			"search":   runSearch,
+			"suggest":  runSuggest,
+			"snapshot": runSnapshot,
+			"restore":  runRestore,
+		}[cmd]
+		if err := run(fs.Args()); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// The first SIGINT/SIGTERM stops gracefully; a second one kills.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	connectCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	client, col, err := connectMongo(ctx)
+	store, err := openStore(connectCtx)
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer client.Disconnect(ctx)
-
-	if err := crawlSeeds(ctx, col); err != nil {
+	defer store.Close()
+
+	timeout := getEnvDuration("RUN_TIMEOUT", DefaultRunTimeout)
+	switch cmd {
+	case "crawl":
+		err = runCrawl(ctx, store, timeout, *resume)
+	case "daemon":
+		err = runDaemon(ctx, store, timeout)
+	case "reextract":
+		err = runReextract(ctx, store, parserFromEnv(), *only)
+	case "index":
+		err = runIndex(ctx, store, fs.Args())
+	default:
+		err = fmt.Errorf("unknown command %q", cmd)
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 }